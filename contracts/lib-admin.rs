@@ -18,13 +18,36 @@ extern "C" {
 #[derive(Serialize, Deserialize)]
 pub struct AddAdminReq {
     pub admin_did: String,
+    // block_id and actor_did are filled in by the dApp server's callback
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub block_id: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub actor_did: Option<String>,
 }
 
-// call_do_api_call is helper function for do_api_call import function 
-pub fn call_write_to_file(input_data: &AddAdminReq) -> Result<String, WasmError> {
+#[derive(Serialize, Deserialize)]
+pub struct RemoveAdminReq {
+    pub admin_did: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub block_id: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub actor_did: Option<String>,
+}
+
+// The dApp server stores records of several kinds for this contract, so
+// each one is wrapped as {"kind": ..., "record": ...}
+#[derive(Serialize)]
+struct RecordEnvelope<'a, T: Serialize> {
+    kind: &'a str,
+    record: &'a T,
+}
+
+// call_write_to_file is helper function for write_to_json_file import function
+pub fn call_write_to_file<T: Serialize>(kind: &str, input_data: &T) -> Result<String, WasmError> {
     unsafe {
         // Convert the input data to bytes
-        let input_bytes = serde_json::to_string(&input_data).unwrap().into_bytes();
+        let envelope = RecordEnvelope { kind, record: input_data };
+        let input_bytes = serde_json::to_string(&envelope).unwrap().into_bytes();
         let input_ptr = input_bytes.as_ptr();
         let input_len = input_bytes.len();
 
@@ -62,7 +85,7 @@ pub fn call_write_to_file(input_data: &AddAdminReq) -> Result<String, WasmError>
 #[contract_fn]
 pub fn add_admin(inp: AddAdminReq) -> Result<String, WasmError> {
     
-    match call_write_to_file(&inp) {
+    match call_write_to_file("admin", &inp) {
         Ok(resp) => {
             Ok(resp)
         },
@@ -70,4 +93,9 @@ pub fn add_admin(inp: AddAdminReq) -> Result<String, WasmError> {
             Err(e)
         }
     }
-}
\ No newline at end of file
+}
+
+#[contract_fn]
+pub fn remove_admin(inp: RemoveAdminReq) -> Result<String, WasmError> {
+    call_write_to_file("admin_removal", &inp)
+}
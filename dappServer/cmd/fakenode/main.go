@@ -0,0 +1,30 @@
+// Command fakenode serves an in-process fake Rubix node for local demos
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+
+	"dapp-server/fakenode"
+)
+
+func main() {
+	port := flag.String("port", "20002", "port to listen on")
+	contractHash := flag.String("contract", "", "hash of a contract to pre-deploy")
+	deployer := flag.String("deployer", "", "deployer DID of the pre-deployed contract")
+	callback := flag.String("callback", "", "callback URL for the pre-deployed contract")
+	flag.Parse()
+
+	node := fakenode.New(*port)
+	if *contractHash != "" {
+		node.AddContract(*contractHash, *deployer)
+		if *callback != "" {
+			node.RegisterCallback(*contractHash, *callback)
+		}
+	}
+
+	fmt.Printf("Fake Rubix node listening on :%s\n", *port)
+	log.Fatal(http.ListenAndServe(":"+*port, node))
+}
@@ -0,0 +1,101 @@
+package commands
+
+import (
+	"dapp-server/database"
+	"dapp-server/server"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	apiKeyDBPath string
+	apiKeyRoles  []string
+	apiKeyDID    string
+)
+
+// APIKeyCmd manages the API keys staff tools authenticate with
+var APIKeyCmd = &cobra.Command{
+	Use:   "api-key",
+	Short: "Create, list and revoke API keys for staff tools",
+}
+
+var apiKeyCreateCmd = &cobra.Command{
+	Use:   "create NAME",
+	Short: "Create an API key and print it",
+	Long: `Create an API key with the given roles (admin, staff or user) and print it.
+The key is stored hashed and cannot be shown again. A key that adds
+activities, transfers rewards, changes admins or deploys and executes
+contracts must be bound with --did to the admin DID it acts as; its admin
+role lapses when that DID stops being an admin.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := database.InitDB(apiKeyDBPath); err != nil {
+			return err
+		}
+		defer database.CloseDB()
+
+		key, err := server.CreateAPIKey(args[0], apiKeyRoles, apiKeyDID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created API key %s with roles %s:\n%s\n", args[0], strings.Join(apiKeyRoles, ", "), key)
+		return nil
+	},
+}
+
+var apiKeyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List API keys",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := database.InitDB(apiKeyDBPath); err != nil {
+			return err
+		}
+		defer database.CloseDB()
+
+		keys, err := database.ListAPIKeys()
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			state := "active"
+			if key.RevokedAt != nil {
+				state = "revoked " + key.RevokedAt.Format("2006-01-02 15:04")
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\n", key.Name, strings.Join(key.Roles, ","), key.DID, state)
+		}
+		return nil
+	},
+}
+
+var apiKeyRevokeCmd = &cobra.Command{
+	Use:   "revoke NAME",
+	Short: "Revoke an API key",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := database.InitDB(apiKeyDBPath); err != nil {
+			return err
+		}
+		defer database.CloseDB()
+
+		revoked, err := database.RevokeAPIKey(args[0])
+		if err != nil {
+			return err
+		}
+		if !revoked {
+			return fmt.Errorf("no active API key named %s", args[0])
+		}
+		fmt.Println("Revoked API key", args[0])
+		return nil
+	},
+}
+
+func init() {
+	APIKeyCmd.PersistentFlags().StringVar(&apiKeyDBPath, "db", "./transfer_status.db", "path of the server database")
+	apiKeyCreateCmd.Flags().StringSliceVar(&apiKeyRoles, "role", []string{server.RoleStaff}, "role granted to the key (repeatable)")
+	apiKeyCreateCmd.Flags().StringVar(&apiKeyDID, "did", "", "DID the key acts as")
+	APIKeyCmd.AddCommand(apiKeyCreateCmd, apiKeyListCmd, apiKeyRevokeCmd)
+	RootCmd.AddCommand(APIKeyCmd)
+}
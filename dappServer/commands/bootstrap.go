@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"context"
+	"dapp-server/config"
+	"dapp-server/database"
+	rubix_interaction "dapp-server/rubix-interaction"
+	"dapp-server/server"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	bootstrapDBPath       string
+	bootstrapManifestPath string
+)
+
+// BootstrapCmd deploys the contracts of a manifest and registers them, so a
+// new environment needs no contract hashes in .config/.env. It can be re-run
+// safely: contracts already deployed from the same files are left alone.
+var BootstrapCmd = &cobra.Command{
+	Use:   "bootstrap",
+	Short: "Deploy and register the contracts listed in the contract manifest",
+	Long: `Deploy each contract of the manifest that the contract registry does not
+have yet, make each contract with a role the active version of that role, and
+register its callback URL with its nodes at PUBLIC_BASE_URL. Contracts already
+deployed from the same files and callbacks already registered are skipped, so
+the command can be re-run after a failure or a change to the manifest.
+
+Each contract is signed for with the credentials of its deployer DID, from
+SIGNING_KEYSTORE or the environment, and deployed on that DID's node in
+.config/config.toml.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		manifest, err := config.LoadContractManifest(bootstrapManifestPath)
+		if err != nil {
+			return err
+		}
+		config.LoadConfig(rubix_interaction.CONFIG_PATH)
+		envConfig := config.LoadEnvConfig()
+		credentials, err := rubix_interaction.LoadCredentialProvider(
+			envConfig.SigningKeystore,
+			rubix_interaction.Secret(os.Getenv("SIGNING_KEYSTORE_PASSPHRASE")),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to load signing credentials: %w", err)
+		}
+		rubix_interaction.SetCredentialProvider(credentials)
+
+		if err := database.InitDB(bootstrapDBPath); err != nil {
+			return err
+		}
+		defer database.CloseDB()
+
+		results, err := server.Bootstrap(context.Background(), manifest)
+		for _, result := range results {
+			fmt.Println(describeBootstrap(result))
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d contract(s) of %s are deployed and registered\n", len(results), bootstrapManifestPath)
+		return nil
+	},
+}
+
+func init() {
+	BootstrapCmd.Flags().StringVar(&bootstrapDBPath, "db", "./transfer_status.db", "path of the server database")
+	BootstrapCmd.Flags().StringVar(&bootstrapManifestPath, "manifest", ".config/contracts.toml", "contract manifest")
+	RootCmd.AddCommand(BootstrapCmd)
+}
+
+// describeBootstrap is a line saying what was done for a contract
+func describeBootstrap(result server.BootstrapResult) string {
+	var done []string
+	if result.Deployed {
+		done = append(done, "deployed")
+	} else {
+		done = append(done, "already deployed")
+	}
+	if result.Promoted {
+		done = append(done, "promoted")
+	}
+	for _, callback := range result.Callbacks {
+		if callback.Error != "" {
+			done = append(done, fmt.Sprintf("callback registration failed on %s: %s", callback.NodePort, callback.Error))
+		} else {
+			done = append(done, "callback registered on "+callback.NodePort)
+		}
+	}
+	name := result.Name
+	if result.Role != "" {
+		name = fmt.Sprintf("%s (%s version %d)", result.Name, result.Role, result.Version)
+	}
+	return fmt.Sprintf("%s %s: %s", name, result.ContractHash, strings.Join(done, ", "))
+}
@@ -0,0 +1,93 @@
+package commands
+
+import (
+	"dapp-server/config"
+	"dapp-server/database"
+	rubix_interaction "dapp-server/rubix-interaction"
+	"dapp-server/server"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	importDBPath         string
+	importActivitiesPath string
+	importAdminsPath     string
+)
+
+// ImportStateCmd copies the activity and admin JSON files the contracts used
+// to write into the database. It can be re-run safely: activities are
+// upserted and existing admins are left alone.
+var ImportStateCmd = &cobra.Command{
+	Use:   "import-state",
+	Short: "Import the activity and admin JSON files into the database",
+	Long: `Import the activity and admin JSON files written by earlier versions of the
+write_to_json_file host function into the activities and admins tables.
+Paths default to ACTIVITY_UPDATE_PATH and ADD_ADMIN_PATH from .config/.env.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if importActivitiesPath == "" || importAdminsPath == "" {
+			envConfig := config.LoadEnvConfig()
+			if importActivitiesPath == "" {
+				importActivitiesPath = envConfig.ActivityUpdatePath
+			}
+			if importAdminsPath == "" {
+				importAdminsPath = envConfig.AdminUpdatePath
+			}
+		}
+
+		if err := database.InitDB(importDBPath); err != nil {
+			return err
+		}
+		defer database.CloseDB()
+
+		activities, err := importRecords(importActivitiesPath, server.ActivityRecordKind)
+		if err != nil {
+			return err
+		}
+		admins, err := importRecords(importAdminsPath, server.AdminRecordKind)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Imported %d activity record(s) from %s and %d admin record(s) from %s\n",
+			activities, importActivitiesPath, admins, importAdminsPath)
+		return nil
+	},
+}
+
+func init() {
+	ImportStateCmd.Flags().StringVar(&importDBPath, "db", "./transfer_status.db", "path of the server database")
+	ImportStateCmd.Flags().StringVar(&importActivitiesPath, "activities", "", "activity JSON file (default ACTIVITY_UPDATE_PATH)")
+	ImportStateCmd.Flags().StringVar(&importAdminsPath, "admins", "", "admin JSON file (default ADD_ADMIN_PATH)")
+	RootCmd.AddCommand(ImportStateCmd)
+}
+
+// importRecords stores each record of a JSON array file as kind, in file
+// order, the same way the host function stores them. A missing path or file
+// imports nothing.
+func importRecords(path string, kind rubix_interaction.RecordKind) (int, error) {
+	if path == "" {
+		return 0, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		fmt.Printf("Skipping %s: file does not exist\n", path)
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var records []json.RawMessage
+	if err := json.Unmarshal(data, &records); err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for i, record := range records {
+		if err := kind.Store(record); err != nil {
+			return i, fmt.Errorf("record %d of %s: %w", i, path, err)
+		}
+	}
+	return len(records), nil
+}
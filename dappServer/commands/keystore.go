@@ -0,0 +1,117 @@
+package commands
+
+import (
+	"bufio"
+	"dapp-server/config"
+	rubix_interaction "dapp-server/rubix-interaction"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	keystorePath string
+	keystoreMode int
+)
+
+// KeystoreCmd manages the encrypted file of per-DID signing credentials.
+// The passphrase is read from SIGNING_KEYSTORE_PASSPHRASE.
+var KeystoreCmd = &cobra.Command{
+	Use:   "keystore",
+	Short: "Manage the encrypted keystore of DID signing credentials",
+}
+
+var keystoreSetCmd = &cobra.Command{
+	Use:   "set DID",
+	Short: "Store the signing credential of a DID",
+	Long: `Store the signing credential of a DID. The password is read from the first
+line of standard input so it never appears in the shell history.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keystore, err := openKeystore()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Password for %s: ", args[0])
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("failed to read password: %w", err)
+		}
+		keystore.Set(args[0], rubix_interaction.Credential{
+			Mode:     keystoreMode,
+			Password: rubix_interaction.Secret(strings.TrimRight(line, "\r\n")),
+		})
+		if err := keystore.Save(); err != nil {
+			return err
+		}
+		fmt.Printf("Stored signing credential for %s (mode %d)\n", args[0], keystoreMode)
+		return nil
+	},
+}
+
+var keystoreListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the DIDs in the keystore and their signature modes",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keystore, err := openKeystore()
+		if err != nil {
+			return err
+		}
+		modes := keystore.Modes()
+		dids := make([]string, 0, len(modes))
+		for did := range modes {
+			dids = append(dids, did)
+		}
+		sort.Strings(dids)
+		for _, did := range dids {
+			fmt.Printf("%s\tmode %d\n", did, modes[did])
+		}
+		return nil
+	},
+}
+
+var keystoreRemoveCmd = &cobra.Command{
+	Use:   "remove DID",
+	Short: "Remove the signing credential of a DID",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keystore, err := openKeystore()
+		if err != nil {
+			return err
+		}
+		if !keystore.Remove(args[0]) {
+			return fmt.Errorf("no signing credential stored for %s", args[0])
+		}
+		if err := keystore.Save(); err != nil {
+			return err
+		}
+		fmt.Println("Removed signing credential for", args[0])
+		return nil
+	},
+}
+
+func openKeystore() (*rubix_interaction.Keystore, error) {
+	if keystorePath == "" {
+		keystorePath = config.LoadEnvConfig().SigningKeystore
+	}
+	if keystorePath == "" {
+		return nil, fmt.Errorf("no keystore path: pass --path or set SIGNING_KEYSTORE")
+	}
+	passphrase := os.Getenv("SIGNING_KEYSTORE_PASSPHRASE")
+	if passphrase == "" {
+		return nil, fmt.Errorf("SIGNING_KEYSTORE_PASSPHRASE is not set")
+	}
+	return rubix_interaction.OpenKeystore(keystorePath, rubix_interaction.Secret(passphrase))
+}
+
+func init() {
+	KeystoreCmd.PersistentFlags().StringVar(&keystorePath, "path", "", "keystore file (default SIGNING_KEYSTORE)")
+	keystoreSetCmd.Flags().IntVar(&keystoreMode, "mode", rubix_interaction.SignatureModeBasic,
+		"signature mode: 0 basic, 1 standard, 3 child, 4 lite")
+	KeystoreCmd.AddCommand(keystoreSetCmd, keystoreListCmd, keystoreRemoveCmd)
+	RootCmd.AddCommand(KeystoreCmd)
+}
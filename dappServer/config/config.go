@@ -98,6 +98,17 @@ type EnvConfig struct {
 	TransferContract    string
 	ActivityUpdatePath  string
 	AdminUpdatePath     string
+	ReconcileInterval   string
+	ReconcileMaxAge     string
+	ClaimPolicyDefault  string
+	ClaimPolicies       string
+	PointsPerToken      string
+	BootstrapAdmins     string
+	SessionTTL          string
+	CORSAllowOrigins    string
+	SigningKeystore     string
+	PublicBaseURL       string
+	ArtifactDir         string
 }
 
 var (
@@ -121,6 +132,17 @@ func LoadEnvConfig() *EnvConfig {
 			AddAdminContract:    os.Getenv("ADD_ADMIN_CONTRACT"),
 			ActivityUpdatePath:  os.Getenv("ACTIVITY_UPDATE_PATH"),
 			AdminUpdatePath:     os.Getenv("ADD_ADMIN_PATH"),
+			ReconcileInterval:   os.Getenv("RECONCILE_INTERVAL"),
+			ReconcileMaxAge:     os.Getenv("RECONCILE_MAX_AGE"),
+			ClaimPolicyDefault:  os.Getenv("CLAIM_POLICY_DEFAULT"),
+			ClaimPolicies:       os.Getenv("CLAIM_POLICIES"),
+			PointsPerToken:      os.Getenv("REWARD_POINTS_PER_TOKEN"),
+			BootstrapAdmins:     os.Getenv("BOOTSTRAP_ADMIN_DIDS"),
+			SessionTTL:          os.Getenv("SESSION_TTL"),
+			CORSAllowOrigins:    os.Getenv("CORS_ALLOW_ORIGINS"),
+			SigningKeystore:     os.Getenv("SIGNING_KEYSTORE"),
+			PublicBaseURL:       os.Getenv("PUBLIC_BASE_URL"),
+			ArtifactDir:         os.Getenv("ARTIFACT_DIR"),
 		}
 	})
 	return envInstance
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ContractManifest lists the contracts an environment runs, for the
+// bootstrap command to deploy and register
+type ContractManifest struct {
+	Contracts []ManifestContract `toml:"contracts"`
+}
+
+// ManifestContract is a contract of the manifest: the files it is generated
+// from, the DID that deploys it, the role it is registered for and the route
+// nodes call back when it is executed. CallbackNodes, given by name or
+// port, default to the deployer's node.
+type ManifestContract struct {
+	Name             string   `toml:"name"`
+	Role             string   `toml:"role"`
+	Wasm             string   `toml:"wasm"`
+	Lib              string   `toml:"lib"`
+	State            string   `toml:"state"`
+	DeployerDID      string   `toml:"deployer_did"`
+	CallbackEndpoint string   `toml:"callback_endpoint"`
+	CallbackNodes    []string `toml:"callback_nodes"`
+}
+
+// LoadContractManifest reads a manifest. File paths in it are relative to
+// the manifest's directory.
+func LoadContractManifest(path string) (*ContractManifest, error) {
+	var manifest ContractManifest
+	if _, err := toml.DecodeFile(path, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to load contract manifest %s: %w", path, err)
+	}
+	dir := filepath.Dir(path)
+	for i := range manifest.Contracts {
+		contract := &manifest.Contracts[i]
+		for _, file := range []*string{&contract.Wasm, &contract.Lib, &contract.State} {
+			if *file != "" && !filepath.IsAbs(*file) {
+				*file = filepath.Join(dir, *file)
+			}
+		}
+	}
+	return &manifest, nil
+}
@@ -0,0 +1,190 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrAPIKeyExists is returned when an API key name is already taken
+var ErrAPIKeyExists = errors.New("api key name already used")
+
+// APIKey is a credential for a staff tool. Only the SHA-256 of the key is
+// stored; the key itself is shown once, when it is created. DID, if set, is
+// the DID the tool acts as.
+type APIKey struct {
+	Name      string     `json:"name"`
+	KeyHash   string     `json:"-"`
+	Roles     []string   `json:"roles"`
+	DID       string     `json:"did,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// DIDKey is the public key a DID signs login challenges with, as base64
+// PKIX DER
+type DIDKey struct {
+	DID       string    `json:"did"`
+	PublicKey string    `json:"public_key"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Session is a short-lived login of a DID. Only the SHA-256 of the token is
+// stored. Expiry is kept and compared as Unix seconds: SQLite compares
+// DATETIME values as text, which misorders times written with different
+// zone offsets.
+type Session struct {
+	TokenHash string
+	DID       string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// CreateAPIKey stores a new API key
+func CreateAPIKey(name string, keyHash string, roles []string, did string) error {
+	_, err := db.Exec(
+		`INSERT INTO api_keys (name, key_hash, roles, did, created_at) VALUES (?, ?, ?, ?, ?)`,
+		name, keyHash, strings.Join(roles, ","), did, time.Now(),
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrAPIKeyExists
+		}
+		return fmt.Errorf("failed to store api key: %w", err)
+	}
+	return nil
+}
+
+// GetAPIKeyByHash returns the unrevoked API key with the given hash, or nil
+func GetAPIKeyByHash(keyHash string) (*APIKey, error) {
+	row := db.QueryRow(
+		`SELECT name, key_hash, roles, did, created_at, revoked_at FROM api_keys WHERE key_hash = ? AND revoked_at IS NULL`,
+		keyHash,
+	)
+	key, err := scanAPIKey(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return key, err
+}
+
+// RevokeAPIKey revokes an API key by name and reports whether it was active
+func RevokeAPIKey(name string) (bool, error) {
+	result, err := db.Exec(`UPDATE api_keys SET revoked_at = ? WHERE name = ? AND revoked_at IS NULL`, time.Now(), name)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected == 1, nil
+}
+
+// ListAPIKeys returns every API key, revoked ones included
+func ListAPIKeys() ([]APIKey, error) {
+	rows, err := db.Query(`SELECT name, key_hash, roles, did, created_at, revoked_at FROM api_keys ORDER BY created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	return keys, nil
+}
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var key APIKey
+	var roles string
+	var revokedAt sql.NullTime
+	if err := row.Scan(&key.Name, &key.KeyHash, &roles, &key.DID, &key.CreatedAt, &revokedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to read api key: %w", err)
+	}
+	key.Roles = strings.Split(roles, ",")
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return &key, nil
+}
+
+// SetDIDKey registers or replaces the login key of a DID
+func SetDIDKey(did string, publicKey string) error {
+	_, err := db.Exec(`
+		INSERT INTO did_keys (did, public_key, created_at) VALUES (?, ?, ?)
+		ON CONFLICT(did) DO UPDATE SET public_key = excluded.public_key, created_at = excluded.created_at
+	`, did, publicKey, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to store key for %s: %w", did, err)
+	}
+	return nil
+}
+
+// GetDIDKey returns the login key of a DID, or nil if it has none
+func GetDIDKey(did string) (*DIDKey, error) {
+	var key DIDKey
+	err := db.QueryRow(`SELECT did, public_key, created_at FROM did_keys WHERE did = ?`, did).
+		Scan(&key.DID, &key.PublicKey, &key.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key for %s: %w", did, err)
+	}
+	return &key, nil
+}
+
+// CreateSession stores a session and drops the ones that have expired
+func CreateSession(session Session) error {
+	if _, err := db.Exec(`DELETE FROM sessions WHERE expires_unix <= ?`, time.Now().Unix()); err != nil {
+		return fmt.Errorf("failed to expire sessions: %w", err)
+	}
+	_, err := db.Exec(
+		`INSERT INTO sessions (token_hash, did, created_at, expires_at, expires_unix) VALUES (?, ?, ?, ?, ?)`,
+		session.TokenHash, session.DID, session.CreatedAt.UTC(), session.ExpiresAt.UTC(), session.ExpiresAt.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store session: %w", err)
+	}
+	return nil
+}
+
+// GetSession returns the unexpired session with the given token hash, or nil
+func GetSession(tokenHash string) (*Session, error) {
+	var session Session
+	var expiresUnix int64
+	err := db.QueryRow(
+		`SELECT token_hash, did, created_at, expires_unix FROM sessions WHERE token_hash = ? AND expires_unix > ?`,
+		tokenHash, time.Now().Unix(),
+	).Scan(&session.TokenHash, &session.DID, &session.CreatedAt, &expiresUnix)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	session.ExpiresAt = time.Unix(expiresUnix, 0)
+	return &session, nil
+}
+
+// DeleteSession ends a session
+func DeleteSession(tokenHash string) error {
+	if _, err := db.Exec(`DELETE FROM sessions WHERE token_hash = ?`, tokenHash); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIKeysAreFoundByHashUntilRevoked(t *testing.T) {
+	useTestDB(t)
+	if err := CreateAPIKey("front-desk", "hash-1", []string{"staff", "admin"}, "bafybmifrontdesk"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateAPIKey("front-desk", "hash-2", []string{"staff"}, ""); !errors.Is(err, ErrAPIKeyExists) {
+		t.Fatalf("second key with the same name = %v", err)
+	}
+
+	key, err := GetAPIKeyByHash("hash-1")
+	if err != nil || key == nil || key.Name != "front-desk" || key.DID != "bafybmifrontdesk" || strings.Join(key.Roles, ",") != "staff,admin" {
+		t.Fatalf("key = %+v, %v", key, err)
+	}
+	if revoked, err := RevokeAPIKey("front-desk"); err != nil || !revoked {
+		t.Fatalf("revoke = %v, %v", revoked, err)
+	}
+	if revoked, _ := RevokeAPIKey("front-desk"); revoked {
+		t.Fatal("a revoked key was revoked again")
+	}
+	if key, err := GetAPIKeyByHash("hash-1"); err != nil || key != nil {
+		t.Fatalf("revoked key = %+v, %v", key, err)
+	}
+	if keys, err := ListAPIKeys(); err != nil || len(keys) != 1 || keys[0].RevokedAt == nil {
+		t.Fatalf("keys = %+v, %v", keys, err)
+	}
+}
+
+func TestSessionExpiryIgnoresZoneOffsets(t *testing.T) {
+	useTestDB(t)
+
+	// Written by a process east of UTC: as text its expiry sorts after now
+	// in UTC even though it has passed
+	east := time.FixedZone("UTC+10", 10*60*60)
+	now := time.Now()
+	expired := Session{TokenHash: "expired", DID: "bafybmiexpired", CreatedAt: now.Add(-time.Hour).In(east), ExpiresAt: now.Add(-time.Minute).In(east)}
+	live := Session{TokenHash: "live", DID: "bafybmilive", CreatedAt: now.UTC(), ExpiresAt: now.Add(time.Hour).UTC()}
+	for _, session := range []Session{expired, live} {
+		if err := CreateSession(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if session, err := GetSession("expired"); err != nil || session != nil {
+		t.Fatalf("expired session = %+v, %v", session, err)
+	}
+	session, err := GetSession("live")
+	if err != nil || session == nil || session.ExpiresAt.Unix() != live.ExpiresAt.Unix() {
+		t.Fatalf("live session = %+v, %v", session, err)
+	}
+}
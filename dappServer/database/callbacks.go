@@ -0,0 +1,130 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// CallbackRegistration is a callback URL registered with a node for a
+// contract. The secret is carried in the URL and keys the optional HMAC
+// signature, so the server can tell the node's callbacks from forged ones.
+type CallbackRegistration struct {
+	ContractHash string    `json:"contract_hash"`
+	NodePort     string    `json:"node_port"`
+	Endpoint     string    `json:"endpoint"`
+	URL          string    `json:"url"`
+	Secret       string    `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// SaveCallbackRegistration records a registration, replacing an earlier one
+// for the same contract and node along with its secret
+func SaveCallbackRegistration(registration CallbackRegistration) error {
+	if registration.CreatedAt.IsZero() {
+		registration.CreatedAt = time.Now()
+	}
+	_, err := db.Exec(`
+		INSERT INTO callback_registrations (contract_hash, node_port, endpoint, url, secret, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(contract_hash, node_port) DO UPDATE SET
+			endpoint = excluded.endpoint,
+			url = excluded.url,
+			secret = excluded.secret,
+			created_at = excluded.created_at
+	`, registration.ContractHash, registration.NodePort, registration.Endpoint, registration.URL, registration.Secret, registration.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store callback registration: %w", err)
+	}
+	return nil
+}
+
+// GetCallbackRegistration returns the registration of a contract on a node,
+// or nil if there is none
+func GetCallbackRegistration(contractHash string, nodePort string) (*CallbackRegistration, error) {
+	var registration CallbackRegistration
+	err := db.QueryRow(
+		`SELECT contract_hash, node_port, endpoint, url, secret, created_at FROM callback_registrations WHERE contract_hash = ? AND node_port = ?`,
+		contractHash, nodePort,
+	).Scan(&registration.ContractHash, &registration.NodePort, &registration.Endpoint, &registration.URL, &registration.Secret, &registration.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get callback registration: %w", err)
+	}
+	return &registration, nil
+}
+
+// ListCallbackRegistrations returns the registrations of a contract on every
+// node, ordered by node port
+func ListCallbackRegistrations(contractHash string) ([]CallbackRegistration, error) {
+	rows, err := db.Query(
+		`SELECT contract_hash, node_port, endpoint, url, secret, created_at FROM callback_registrations WHERE contract_hash = ? ORDER BY node_port`,
+		contractHash,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list callback registrations: %w", err)
+	}
+	defer rows.Close()
+
+	registrations := []CallbackRegistration{}
+	for rows.Next() {
+		var registration CallbackRegistration
+		if err := rows.Scan(&registration.ContractHash, &registration.NodePort, &registration.Endpoint, &registration.URL, &registration.Secret, &registration.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan callback registration: %w", err)
+		}
+		registrations = append(registrations, registration)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list callback registrations: %w", err)
+	}
+	return registrations, nil
+}
+
+// UseCallbackNonce records a signed callback's nonce and reports whether it
+// is new. Nonces seen before cutoff are forgotten; callbacks that old are
+// rejected by their timestamp instead.
+func UseCallbackNonce(nonce string, cutoff time.Time) (bool, error) {
+	if _, err := db.Exec(`DELETE FROM callback_nonces WHERE seen_at < ?`, cutoff); err != nil {
+		return false, fmt.Errorf("failed to expire callback nonces: %w", err)
+	}
+	result, err := db.Exec(
+		`INSERT INTO callback_nonces (nonce, seen_at) VALUES (?, ?) ON CONFLICT(nonce) DO NOTHING`,
+		nonce, time.Now(),
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to record callback nonce: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected == 1, nil
+}
+
+// MarkCallbackBlock records that a callback handler is processing a block
+// and reports whether it is the first to do so
+func MarkCallbackBlock(contractHash string, blockID string) (bool, error) {
+	result, err := db.Exec(
+		`INSERT INTO callback_blocks (contract_hash, block_id, processed_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING`,
+		contractHash, blockID, time.Now(),
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark callback block: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected == 1, nil
+}
+
+// UnmarkCallbackBlock lets a block whose processing failed be processed by
+// the next callback
+func UnmarkCallbackBlock(contractHash string, blockID string) error {
+	if _, err := db.Exec(`DELETE FROM callback_blocks WHERE contract_hash = ? AND block_id = ?`, contractHash, blockID); err != nil {
+		return fmt.Errorf("failed to unmark callback block: %w", err)
+	}
+	return nil
+}
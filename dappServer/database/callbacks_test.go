@@ -0,0 +1,58 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCallbackRegistrationIsReplacedPerNode(t *testing.T) {
+	useTestDB(t)
+	for _, registration := range []CallbackRegistration{
+		{ContractHash: "QmContract", NodePort: "20002", Endpoint: "api/callback/trigger", URL: "http://server/api/callback/trigger", Secret: "first"},
+		{ContractHash: "QmContract", NodePort: "20001", Endpoint: "api/callback/trigger", URL: "http://server/api/callback/trigger", Secret: "other"},
+		{ContractHash: "QmContract", NodePort: "20002", Endpoint: "api/call-back-trigger", URL: "http://server/api/call-back-trigger", Secret: "second"},
+	} {
+		if err := SaveCallbackRegistration(registration); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	registration, err := GetCallbackRegistration("QmContract", "20002")
+	if err != nil || registration == nil || registration.Secret != "second" || registration.Endpoint != "api/call-back-trigger" {
+		t.Fatalf("registration = %+v, %v", registration, err)
+	}
+	registrations, err := ListCallbackRegistrations("QmContract")
+	if err != nil || len(registrations) != 2 || registrations[0].NodePort != "20001" {
+		t.Fatalf("registrations = %+v, %v", registrations, err)
+	}
+	if registration, err := GetCallbackRegistration("QmContract", "20009"); err != nil || registration != nil {
+		t.Fatalf("registration on another node = %+v, %v", registration, err)
+	}
+}
+
+func TestCallbackNoncesAndBlocksAreUsedOnce(t *testing.T) {
+	useTestDB(t)
+	now := time.Now()
+	if fresh, err := UseCallbackNonce("nonce-1", now.Add(-time.Minute)); err != nil || !fresh {
+		t.Fatalf("new nonce = %v, %v", fresh, err)
+	}
+	if fresh, _ := UseCallbackNonce("nonce-1", now.Add(-time.Minute)); fresh {
+		t.Fatal("a nonce was accepted twice")
+	}
+
+	if first, err := MarkCallbackBlock("QmContract", "block-1"); err != nil || !first {
+		t.Fatalf("first mark = %v, %v", first, err)
+	}
+	if first, _ := MarkCallbackBlock("QmContract", "block-1"); first {
+		t.Fatal("a block was marked twice")
+	}
+	if first, _ := MarkCallbackBlock("QmOtherContract", "block-1"); !first {
+		t.Fatal("a block of another contract was taken as marked")
+	}
+	if err := UnmarkCallbackBlock("QmContract", "block-1"); err != nil {
+		t.Fatal(err)
+	}
+	if first, _ := MarkCallbackBlock("QmContract", "block-1"); !first {
+		t.Fatal("an unmarked block could not be marked again")
+	}
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ActivityClaim records that a user has been rewarded for one occurrence of
+// an activity. What an occurrence is depends on the activity's claim policy:
+// the same key for a one-time activity, the day for a daily one.
+type ActivityClaim struct {
+	UserDID    string    `json:"user_did"`
+	ActivityID string    `json:"activity_id"`
+	Occurrence string    `json:"occurrence"`
+	RequestID  string    `json:"request_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// DuplicateClaimsError is returned when a transfer claims activity
+// occurrences the user has already been rewarded for
+type DuplicateClaimsError struct {
+	ActivityIDs []string
+}
+
+func (e *DuplicateClaimsError) Error() string {
+	return fmt.Sprintf("activities already claimed: %s", strings.Join(e.ActivityIDs, ", "))
+}
+
+// CreateTransferWithClaims stores a transfer together with the activity
+// claims it makes, in one transaction. If any claim is already held, or is
+// repeated within claims, nothing is written and a *DuplicateClaimsError
+// lists the activities concerned.
+func CreateTransferWithClaims(status *TransferStatus, claims []ActivityClaim) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The transfer goes in first so a reused idempotency key is reported as
+	// such rather than as the claims it duplicates
+	if err := insertTransferStatus(tx, status); err != nil {
+		return err
+	}
+
+	var duplicates []string
+	seen := make(map[ActivityClaim]bool, len(claims))
+	for _, claim := range claims {
+		key := ActivityClaim{UserDID: claim.UserDID, ActivityID: claim.ActivityID, Occurrence: claim.Occurrence}
+		if seen[key] {
+			duplicates = appendUnique(duplicates, claim.ActivityID)
+			continue
+		}
+		seen[key] = true
+
+		var held int
+		err := tx.QueryRow(
+			`SELECT COUNT(*) FROM activity_claims WHERE user_did = ? AND activity_id = ? AND occurrence = ?`,
+			claim.UserDID, claim.ActivityID, claim.Occurrence,
+		).Scan(&held)
+		if err != nil {
+			return fmt.Errorf("failed to check activity claim: %w", err)
+		}
+		if held > 0 {
+			duplicates = appendUnique(duplicates, claim.ActivityID)
+			continue
+		}
+
+		_, err = tx.Exec(
+			`INSERT INTO activity_claims (user_did, activity_id, occurrence, request_id, created_at) VALUES (?, ?, ?, ?, ?)`,
+			claim.UserDID, claim.ActivityID, claim.Occurrence, claim.RequestID, claim.CreatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to record activity claim: %w", err)
+		}
+	}
+	if len(duplicates) > 0 {
+		return &DuplicateClaimsError{ActivityIDs: duplicates}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transfer: %w", err)
+	}
+	notifyTransferStatusChange(status.RequestID)
+	return nil
+}
+
+// ReleaseActivityClaims drops the claims made by a transfer, so a user whose
+// transfer failed can claim the same activities again
+func ReleaseActivityClaims(requestID string) error {
+	if _, err := db.Exec(`DELETE FROM activity_claims WHERE request_id = ?`, requestID); err != nil {
+		return fmt.Errorf("failed to release activity claims: %w", err)
+	}
+	return nil
+}
+
+// GetActivityClaims returns every claim a user holds
+func GetActivityClaims(userDID string) ([]ActivityClaim, error) {
+	rows, err := db.Query(
+		`SELECT user_did, activity_id, occurrence, request_id, created_at FROM activity_claims WHERE user_did = ? ORDER BY created_at`,
+		userDID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list activity claims: %w", err)
+	}
+	defer rows.Close()
+
+	var claims []ActivityClaim
+	for rows.Next() {
+		var claim ActivityClaim
+		if err := rows.Scan(&claim.UserDID, &claim.ActivityID, &claim.Occurrence, &claim.RequestID, &claim.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to read activity claim: %w", err)
+		}
+		claims = append(claims, claim)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list activity claims: %w", err)
+	}
+	return claims, nil
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
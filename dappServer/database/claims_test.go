@@ -0,0 +1,52 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTransferClaimsAreAllOrNothing(t *testing.T) {
+	useTestDB(t)
+	const user = "bafybmiclaimsuser"
+	transfer := func(requestID string, claims ...ActivityClaim) error {
+		for i := range claims {
+			claims[i].UserDID, claims[i].RequestID, claims[i].CreatedAt = user, requestID, time.Now()
+		}
+		return CreateTransferWithClaims(&TransferStatus{RequestID: requestID, UserDID: user, Status: "pending"}, claims)
+	}
+	held := func() int {
+		t.Helper()
+		claims, err := GetActivityClaims(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(claims)
+	}
+
+	if err := transfer("first", ActivityClaim{ActivityID: "yoga", Occurrence: "once"}, ActivityClaim{ActivityID: "swim", Occurrence: "2026-10-16"}); err != nil {
+		t.Fatal(err)
+	}
+	// The swim of another day is a new occurrence, the yoga is not
+	var duplicates *DuplicateClaimsError
+	err := transfer("second", ActivityClaim{ActivityID: "swim", Occurrence: "2026-10-17"}, ActivityClaim{ActivityID: "yoga", Occurrence: "once"})
+	if !errors.As(err, &duplicates) || len(duplicates.ActivityIDs) != 1 || duplicates.ActivityIDs[0] != "yoga" {
+		t.Fatalf("repeat claim = %v", err)
+	}
+	if _, err := GetTransferStatus("second"); err == nil {
+		t.Fatal("transfer with a duplicate claim was stored")
+	}
+	if got := held(); got != 2 {
+		t.Fatalf("%d claims held, want 2", got)
+	}
+	if err := transfer("third", ActivityClaim{ActivityID: "gym", Occurrence: "once"}, ActivityClaim{ActivityID: "gym", Occurrence: "once"}); !errors.As(err, &duplicates) {
+		t.Fatalf("claim repeated within a transfer = %v", err)
+	}
+
+	if err := ReleaseActivityClaims("first"); err != nil {
+		t.Fatal(err)
+	}
+	if got := held(); got != 0 {
+		t.Fatalf("%d claims held after release", got)
+	}
+}
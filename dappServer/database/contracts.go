@@ -0,0 +1,201 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Roles of the contracts the server executes. Each contract registered for
+// a role is a new version of it; the role resolves to the active version.
+const (
+	ContractRoleActivity = "activity"
+	ContractRoleAdmin    = "admin"
+	ContractRoleTransfer = "transfer"
+)
+
+// ContractRoles lists every role, in the order they are usually deployed
+var ContractRoles = []string{ContractRoleActivity, ContractRoleAdmin, ContractRoleTransfer}
+
+// ContractRecord is a contract the server deployed or was configured with.
+// The digests are the SHA-256 of the files it was generated from; contracts
+// seeded from .config/.env have none. Version numbers the contracts of a
+// role from 1; contracts without a role have version 0.
+type ContractRecord struct {
+	ContractHash    string    `json:"contract_hash"`
+	Name            string    `json:"name"`
+	Role            string    `json:"role,omitempty"`
+	Version         int       `json:"version,omitempty"`
+	Active          bool      `json:"active"`
+	DeployerDID     string    `json:"deployer_did,omitempty"`
+	WasmDigest      string    `json:"wasm_digest,omitempty"`
+	LibDigest       string    `json:"lib_digest,omitempty"`
+	StateDigest     string    `json:"state_digest,omitempty"`
+	DeploymentBlock string    `json:"deployment_block,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+const contractColumns = `contract_hash, name, role, version, active, deployer_did, wasm_digest, lib_digest, state_digest, deployment_block, created_at, updated_at`
+
+// ErrContractNotFound is returned for a hash that is not in the registry
+var ErrContractNotFound = errors.New("contract is not registered")
+
+// ErrContractHasNoRole is returned when promoting a contract without a role
+var ErrContractHasNoRole = errors.New("contract has no role")
+
+// SaveContract records a contract, replacing what was recorded for the same
+// hash, and returns it as stored. A contract new to its role becomes the
+// role's next version, and its active version if the role has none yet;
+// later versions wait for PromoteContract. The first registration time is
+// kept.
+func SaveContract(contract ContractRecord) (*ContractRecord, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	existing, err := scanContract(tx.QueryRow(`SELECT `+contractColumns+` FROM contracts WHERE contract_hash = ?`, contract.ContractHash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get contract: %w", err)
+	}
+	now := time.Now()
+	contract.CreatedAt, contract.UpdatedAt = now, now
+	contract.Version, contract.Active = 0, false
+	if existing != nil {
+		contract.CreatedAt = existing.CreatedAt
+	}
+	switch {
+	case contract.Role == "":
+	case existing != nil && existing.Role == contract.Role:
+		contract.Version, contract.Active = existing.Version, existing.Active
+	default:
+		var latest, active int
+		err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0), COALESCE(SUM(active), 0) FROM contracts WHERE role = ?`, contract.Role).Scan(&latest, &active)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s versions: %w", contract.Role, err)
+		}
+		contract.Version, contract.Active = latest+1, active == 0
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO contracts (`+contractColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(contract_hash) DO UPDATE SET
+			name = excluded.name,
+			role = excluded.role,
+			version = excluded.version,
+			active = excluded.active,
+			deployer_did = excluded.deployer_did,
+			wasm_digest = excluded.wasm_digest,
+			lib_digest = excluded.lib_digest,
+			state_digest = excluded.state_digest,
+			deployment_block = excluded.deployment_block,
+			updated_at = excluded.updated_at
+	`, contract.ContractHash, contract.Name, contract.Role, contract.Version, contract.Active, contract.DeployerDID,
+		contract.WasmDigest, contract.LibDigest, contract.StateDigest, contract.DeploymentBlock, contract.CreatedAt, contract.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store contract: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit contract: %w", err)
+	}
+	return &contract, nil
+}
+
+// PromoteContract makes a contract the active version of its role and
+// returns the version it replaced, or nil if it already was active or the
+// role had none. Work started under the replaced version still refers to it
+// by hash.
+func PromoteContract(contractHash string) (*ContractRecord, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	contract, err := scanContract(tx.QueryRow(`SELECT `+contractColumns+` FROM contracts WHERE contract_hash = ?`, contractHash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get contract: %w", err)
+	}
+	if contract == nil {
+		return nil, ErrContractNotFound
+	}
+	if contract.Role == "" {
+		return nil, ErrContractHasNoRole
+	}
+	previous, err := scanContract(tx.QueryRow(
+		`SELECT `+contractColumns+` FROM contracts WHERE role = ? AND active = 1 AND contract_hash != ?`, contract.Role, contractHash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get active %s contract: %w", contract.Role, err)
+	}
+	// Deactivate first: at most one version of a role may be active
+	if _, err := tx.Exec(`UPDATE contracts SET active = 0 WHERE role = ? AND contract_hash != ?`, contract.Role, contractHash); err != nil {
+		return nil, fmt.Errorf("failed to deactivate %s contracts: %w", contract.Role, err)
+	}
+	if _, err := tx.Exec(`UPDATE contracts SET active = 1, updated_at = ? WHERE contract_hash = ?`, time.Now(), contractHash); err != nil {
+		return nil, fmt.Errorf("failed to activate contract: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit promotion: %w", err)
+	}
+	return previous, nil
+}
+
+// GetContract returns the contract with the given hash, or nil if it is not
+// registered
+func GetContract(contractHash string) (*ContractRecord, error) {
+	contract, err := scanContract(db.QueryRow(`SELECT `+contractColumns+` FROM contracts WHERE contract_hash = ?`, contractHash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get contract: %w", err)
+	}
+	return contract, nil
+}
+
+// ContractForRole returns the active version of role, or nil if there is
+// none
+func ContractForRole(role string) (*ContractRecord, error) {
+	contract, err := scanContract(db.QueryRow(`SELECT `+contractColumns+` FROM contracts WHERE role = ? AND active = 1`, role))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s contract: %w", role, err)
+	}
+	return contract, nil
+}
+
+// ListContracts returns every registered contract, oldest first, and the
+// versions of a role in order
+func ListContracts() ([]ContractRecord, error) {
+	rows, err := db.Query(`SELECT ` + contractColumns + ` FROM contracts ORDER BY created_at, version, rowid`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list contracts: %w", err)
+	}
+	defer rows.Close()
+
+	contracts := []ContractRecord{}
+	for rows.Next() {
+		contract, err := scanContract(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan contract: %w", err)
+		}
+		contracts = append(contracts, *contract)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list contracts: %w", err)
+	}
+	return contracts, nil
+}
+
+func scanContract(row interface{ Scan(...interface{}) error }) (*ContractRecord, error) {
+	var contract ContractRecord
+	err := row.Scan(&contract.ContractHash, &contract.Name, &contract.Role, &contract.Version, &contract.Active, &contract.DeployerDID, &contract.WasmDigest,
+		&contract.LibDigest, &contract.StateDigest, &contract.DeploymentBlock, &contract.CreatedAt, &contract.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &contract, nil
+}
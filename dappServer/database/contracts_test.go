@@ -0,0 +1,69 @@
+package database
+
+import "testing"
+
+func TestSaveContractKeepsItsFirstRegistration(t *testing.T) {
+	useTestDB(t)
+	first, err := SaveContract(ContractRecord{ContractHash: "QmTool", Name: "tool", WasmDigest: "digest-1"})
+	if err != nil || first.Version != 0 || first.Active {
+		t.Fatalf("contract without a role = %+v, %v", first, err)
+	}
+	again, err := SaveContract(ContractRecord{ContractHash: "QmTool", Name: "tool", WasmDigest: "digest-1", DeploymentBlock: "block-1"})
+	if err != nil || !again.CreatedAt.Equal(first.CreatedAt) || again.DeploymentBlock != "block-1" {
+		t.Fatalf("saved again = %+v, %v", again, err)
+	}
+
+	stored, err := GetContract("QmTool")
+	if err != nil || stored == nil || stored.Name != "tool" || stored.DeploymentBlock != "block-1" {
+		t.Fatalf("stored contract = %+v, %v", stored, err)
+	}
+	if contract, err := GetContract("QmNeverSaved"); err != nil || contract != nil {
+		t.Fatalf("unknown contract = %+v, %v", contract, err)
+	}
+	if contracts, err := ListContracts(); err != nil || len(contracts) != 1 {
+		t.Fatalf("contracts = %+v, %v", contracts, err)
+	}
+	if contract, err := ContractForRole(ContractRoleTransfer); err != nil || contract != nil {
+		t.Fatalf("contract of an empty role = %+v, %v", contract, err)
+	}
+}
+
+func TestContractVersionsWaitForPromotion(t *testing.T) {
+	useTestDB(t)
+	first, err := SaveContract(ContractRecord{ContractHash: "QmTransferV1", Name: "transfer_contract", Role: ContractRoleTransfer})
+	if err != nil || first.Version != 1 || !first.Active {
+		t.Fatalf("first version = %+v, %v", first, err)
+	}
+	second, err := SaveContract(ContractRecord{ContractHash: "QmTransferV2", Name: "transfer_contract", Role: ContractRoleTransfer})
+	if err != nil || second.Version != 2 || second.Active {
+		t.Fatalf("second version = %+v, %v", second, err)
+	}
+	if active, err := ContractForRole(ContractRoleTransfer); err != nil || active.ContractHash != "QmTransferV1" {
+		t.Fatalf("active before promotion = %+v, %v", active, err)
+	}
+
+	previous, err := PromoteContract("QmTransferV2")
+	if err != nil || previous == nil || previous.ContractHash != "QmTransferV1" {
+		t.Fatalf("promote = %+v, %v", previous, err)
+	}
+	if active, err := ContractForRole(ContractRoleTransfer); err != nil || active.ContractHash != "QmTransferV2" {
+		t.Fatalf("active after promotion = %+v, %v", active, err)
+	}
+	if previous, err := PromoteContract("QmTransferV2"); err != nil || previous != nil {
+		t.Fatalf("promote the active version = %+v, %v", previous, err)
+	}
+	// Saving a version again keeps its place in the role
+	if again, err := SaveContract(ContractRecord{ContractHash: "QmTransferV1", Name: "transfer_contract", Role: ContractRoleTransfer}); err != nil || again.Version != 1 || again.Active {
+		t.Fatalf("first version saved again = %+v, %v", again, err)
+	}
+
+	if _, err := PromoteContract("QmNeverSaved"); err != ErrContractNotFound {
+		t.Fatalf("promote an unknown contract = %v", err)
+	}
+	if _, err := SaveContract(ContractRecord{ContractHash: "QmTool", Name: "tool"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PromoteContract("QmTool"); err != ErrContractHasNoRole {
+		t.Fatalf("promote a contract without a role = %v", err)
+	}
+}
@@ -3,30 +3,68 @@ package database
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 var db *sql.DB
 
+// transferStatusListener is told the request ID of every transfer row that
+// is created or changed
+var (
+	transferStatusListener   func(requestID string)
+	transferStatusListenerMu sync.RWMutex
+)
+
+// OnTransferStatusChange registers fn to be called after any transfer status
+// row is written. It replaces any previously registered listener.
+func OnTransferStatusChange(fn func(requestID string)) {
+	transferStatusListenerMu.Lock()
+	defer transferStatusListenerMu.Unlock()
+	transferStatusListener = fn
+}
+
+func notifyTransferStatusChange(requestID string) {
+	transferStatusListenerMu.RLock()
+	listener := transferStatusListener
+	transferStatusListenerMu.RUnlock()
+	if listener != nil {
+		listener(requestID)
+	}
+}
+
 // TransferStatus represents a reward transfer record
 type TransferStatus struct {
-	RequestID    string    `json:"request_id"`
-	BlockId      string    `json:"block_id"`
-	ActivityIDs  []string  `json:"activity_ids"`
-	UserDID      string    `json:"user_did"`
-	AdminDID     string    `json:"admin_did"`
-	RewardPoints int       `json:"reward_points"`
-	Status       string    `json:"status"` // "pending", "success", "failed", "timeout"
-	Message      string    `json:"message"`
-	ContractHash string    `json:"contract_hash"`
-	ErrorDetails string    `json:"error_details"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	RequestID      string    `json:"request_id"`
+	TransactionID  string    `json:"transaction_id"`
+	IdempotencyKey string    `json:"idempotency_key,omitempty"`
+	BlockId        string    `json:"block_id"`
+	ActivityIDs    []string  `json:"activity_ids"`
+	UserDID        string    `json:"user_did"`
+	AdminDID       string    `json:"admin_did"`
+	RewardPoints   int       `json:"reward_points"`
+	TokenCount     float64   `json:"token_count"`
+	Status         string    `json:"status"` // "pending", "processing", "success", "failed", "timeout"
+	Message        string    `json:"message"`
+	ContractHash   string    `json:"contract_hash"`
+	ErrorDetails   string    `json:"error_details"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+
+	// RequestFingerprint identifies the request body an idempotency key was
+	// first used with
+	RequestFingerprint string `json:"-"`
 }
 
+// ErrDuplicateIdempotencyKey is returned when a transfer is created with an
+// idempotency key that is already taken
+var ErrDuplicateIdempotencyKey = errors.New("idempotency key already used")
+
 // InitDB initializes the SQLite database
 func InitDB(dbPath string) error {
 	var err error
@@ -40,6 +78,10 @@ func InitDB(dbPath string) error {
 		return fmt.Errorf("failed to ping database: %w", err)
 	}
 
+	// SQLite allows a single writer; funnel everything through one connection
+	// so concurrent handlers queue instead of failing with "database is locked"
+	db.SetMaxOpenConns(1)
+
 	// Create table if not exists
 	if err = createTables(); err != nil {
 		return fmt.Errorf("failed to create tables: %w", err)
@@ -71,14 +113,234 @@ func createTables() error {
 	CREATE INDEX IF NOT EXISTS idx_status ON transfer_status(status);
 	CREATE INDEX IF NOT EXISTS idx_created_at ON transfer_status(created_at);
 	CREATE INDEX IF NOT EXISTS idx_admin_did ON transfer_status(admin_did);
+
+	CREATE TABLE IF NOT EXISTS activity_claims (
+		user_did TEXT NOT NULL,
+		activity_id TEXT NOT NULL,
+		occurrence TEXT NOT NULL,
+		request_id TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (user_did, activity_id, occurrence)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_claims_request_id ON activity_claims(request_id);
+
+	CREATE TABLE IF NOT EXISTS activities (
+		activity_id TEXT PRIMARY KEY,
+		block_hash TEXT NOT NULL UNIQUE,
+		reward_points INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS admins (
+		admin_did TEXT PRIMARY KEY,
+		created_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS admin_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		action TEXT NOT NULL,
+		admin_did TEXT NOT NULL,
+		actor_did TEXT NOT NULL DEFAULT '',
+		block_id TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_admin_events_admin_did ON admin_events(admin_did);
+
+	CREATE TABLE IF NOT EXISTS api_keys (
+		name TEXT PRIMARY KEY,
+		key_hash TEXT NOT NULL UNIQUE,
+		roles TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		revoked_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS did_keys (
+		did TEXT PRIMARY KEY,
+		public_key TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS sessions (
+		token_hash TEXT PRIMARY KEY,
+		did TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
+
+	CREATE TABLE IF NOT EXISTS callback_registrations (
+		contract_hash TEXT NOT NULL,
+		node_port TEXT NOT NULL,
+		endpoint TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (contract_hash, node_port)
+	);
+
+	CREATE TABLE IF NOT EXISTS contracts (
+		contract_hash TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		role TEXT NOT NULL DEFAULT '',
+		deployer_did TEXT NOT NULL DEFAULT '',
+		wasm_digest TEXT NOT NULL DEFAULT '',
+		lib_digest TEXT NOT NULL DEFAULT '',
+		state_digest TEXT NOT NULL DEFAULT '',
+		deployment_block TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_contracts_role ON contracts(role);
+
+	CREATE TABLE IF NOT EXISTS callback_nonces (
+		nonce TEXT PRIMARY KEY,
+		seen_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS callback_blocks (
+		contract_hash TEXT NOT NULL,
+		block_id TEXT NOT NULL,
+		processed_at DATETIME NOT NULL,
+		PRIMARY KEY (contract_hash, block_id)
+	);
 	`
 
-	_, err := db.Exec(schema)
+	if _, err := db.Exec(schema); err != nil {
+		return err
+	}
+
+	return migrateTables()
+}
+
+// migrateTables adds the columns introduced after the first release, since
+// CREATE TABLE IF NOT EXISTS leaves existing tables untouched
+func migrateTables() error {
+	for _, column := range []string{"transaction_id", "idempotency_key", "request_fingerprint"} {
+		if _, err := addColumnIfMissing("transfer_status", column, "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+	}
+	// The callback URL, without its secret, was not recorded at first
+	if _, err := addColumnIfMissing("callback_registrations", "url", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	// API keys were not bound to a DID at first; unbound keys cannot act as
+	// an admin
+	if _, err := addColumnIfMissing("api_keys", "did", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	// Session expiry moved to Unix seconds; sessions from before have 0 and
+	// so have expired
+	if _, err := addColumnIfMissing("sessions", "expires_unix", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	added, err := addColumnIfMissing("transfer_status", "token_count", "REAL NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	if added {
+		// Transfers made before points were converted sent one token per point
+		if _, err := db.Exec(`UPDATE transfer_status SET token_count = reward_points`); err != nil {
+			return fmt.Errorf("failed to backfill token_count: %w", err)
+		}
+	}
+	if err := migrateContractVersions(); err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+	CREATE INDEX IF NOT EXISTS idx_transaction_id ON transfer_status(transaction_id);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_idempotency_key ON transfer_status(idempotency_key) WHERE idempotency_key != '';
+	CREATE INDEX IF NOT EXISTS idx_sessions_expires_unix ON sessions(expires_unix);
+	`)
+	return err
+}
+
+// migrateContractVersions adds contract versions. Before them a role
+// resolved to its latest contract, so existing contracts are numbered in
+// registration order and the latest of each role becomes its active version.
+func migrateContractVersions() error {
+	if _, err := addColumnIfMissing("contracts", "version", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	added, err := addColumnIfMissing("contracts", "active", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	if added {
+		_, err = db.Exec(`
+		UPDATE contracts SET version = (
+			SELECT COUNT(*) FROM contracts earlier
+			WHERE earlier.role = contracts.role
+				AND (earlier.created_at < contracts.created_at OR (earlier.created_at = contracts.created_at AND earlier.rowid <= contracts.rowid))
+		) WHERE role != '';
+		UPDATE contracts SET active = 1
+		WHERE role != '' AND version = (SELECT MAX(version) FROM contracts same WHERE same.role = contracts.role);
+		`)
+		if err != nil {
+			return fmt.Errorf("failed to backfill contract versions: %w", err)
+		}
+	}
+	_, err = db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_contracts_active_role ON contracts(role) WHERE active = 1`)
 	return err
 }
 
+// addColumnIfMissing adds column to table unless it already exists, and
+// reports whether it did
+func addColumnIfMissing(table string, column string, definition string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect %s: %w", table, err)
+	}
+	exists := false
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			rows.Close()
+			return false, fmt.Errorf("failed to inspect %s: %w", table, err)
+		}
+		if name == column {
+			exists = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("failed to inspect %s: %w", table, err)
+	}
+	if exists {
+		return false, nil
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return false, fmt.Errorf("failed to add %s.%s: %w", table, column, err)
+	}
+	return true, nil
+}
+
 // CreateTransferStatus creates a new transfer status record
 func CreateTransferStatus(status *TransferStatus) error {
+	if err := insertTransferStatus(db, status); err != nil {
+		return err
+	}
+
+	notifyTransferStatusChange(status.RequestID)
+	return nil
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertTransferStatus(exec execer, status *TransferStatus) error {
 	// Convert activity IDs to JSON
 	activityIDsJSON, err := json.Marshal(status.ActivityIDs)
 	if err != nil {
@@ -87,20 +349,25 @@ func CreateTransferStatus(status *TransferStatus) error {
 
 	query := `
 		INSERT INTO transfer_status (
-			request_id, block_id, activity_ids, user_did, admin_did,
-			reward_points, status, message, contract_hash, error_details,
+			request_id, transaction_id, idempotency_key, request_fingerprint,
+			block_id, activity_ids, user_did, admin_did,
+			reward_points, token_count, status, message, contract_hash, error_details,
 			created_at, updated_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	_, err = db.Exec(
+	_, err = exec.Exec(
 		query,
 		status.RequestID,
+		status.TransactionID,
+		status.IdempotencyKey,
+		status.RequestFingerprint,
 		status.BlockId,
 		string(activityIDsJSON),
 		status.UserDID,
 		status.AdminDID,
 		status.RewardPoints,
+		status.TokenCount,
 		status.Status,
 		status.Message,
 		status.ContractHash,
@@ -109,33 +376,48 @@ func CreateTransferStatus(status *TransferStatus) error {
 		status.UpdatedAt,
 	)
 
+	if isUniqueViolation(err) {
+		return ErrDuplicateIdempotencyKey
+	}
 	if err != nil {
 		return fmt.Errorf("failed to create transfer status: %w", err)
 	}
-
 	return nil
 }
 
-// GetTransferStatus retrieves a transfer status by request ID
-func GetTransferStatus(requestID string) (*TransferStatus, error) {
-	query := `
-		SELECT request_id, block_id, activity_ids, user_did, admin_did,
-		       reward_points, status, message, contract_hash, error_details,
-		       created_at, updated_at
-		FROM transfer_status
-		WHERE request_id = ?
-	`
+// isUniqueViolation reports whether err is SQLite rejecting a duplicate
+// primary key or unique column
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) &&
+		(sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+}
+
+const transferStatusColumns = `
+		request_id, transaction_id, idempotency_key, request_fingerprint,
+		block_id, activity_ids, user_did, admin_did,
+		reward_points, token_count, status, message, contract_hash, error_details,
+		created_at, updated_at`
 
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTransferStatus(row rowScanner) (*TransferStatus, error) {
 	var status TransferStatus
 	var activityIDsJSON string
 
-	err := db.QueryRow(query, requestID).Scan(
+	err := row.Scan(
 		&status.RequestID,
+		&status.TransactionID,
+		&status.IdempotencyKey,
+		&status.RequestFingerprint,
 		&status.BlockId,
 		&activityIDsJSON,
 		&status.UserDID,
 		&status.AdminDID,
 		&status.RewardPoints,
+		&status.TokenCount,
 		&status.Status,
 		&status.Message,
 		&status.ContractHash,
@@ -143,71 +425,154 @@ func GetTransferStatus(requestID string) (*TransferStatus, error) {
 		&status.CreatedAt,
 		&status.UpdatedAt,
 	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmarshal activity IDs
+	if err := json.Unmarshal([]byte(activityIDsJSON), &status.ActivityIDs); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal activity IDs: %w", err)
+	}
+
+	return &status, nil
+}
 
+func queryTransferStatus(where string, args ...interface{}) (*TransferStatus, error) {
+	query := "SELECT" + transferStatusColumns + " FROM transfer_status WHERE " + where
+	status, err := scanTransferStatus(db.QueryRow(query, args...))
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("transfer not found")
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get transfer status: %w", err)
 	}
+	return status, nil
+}
 
-	// Unmarshal activity IDs
-	if err := json.Unmarshal([]byte(activityIDsJSON), &status.ActivityIDs); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal activity IDs: %w", err)
+func queryTransferStatuses(where string, args ...interface{}) ([]*TransferStatus, error) {
+	query := "SELECT" + transferStatusColumns + " FROM transfer_status WHERE " + where
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list transfer statuses: %w", err)
+	}
+	defer rows.Close()
+
+	var statuses []*TransferStatus
+	for rows.Next() {
+		status, err := scanTransferStatus(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read transfer status: %w", err)
+		}
+		statuses = append(statuses, status)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list transfer statuses: %w", err)
 	}
+	return statuses, nil
+}
 
-	return &status, nil
+// GetTransferStatus retrieves a transfer status by request ID or by the
+// Rubix transaction ID it was signed under
+func GetTransferStatus(requestID string) (*TransferStatus, error) {
+	return queryTransferStatus("request_id = ? OR transaction_id = ?", requestID, requestID)
+}
+
+// GetTransferStatusByIdempotencyKey retrieves the transfer created with an
+// idempotency key
+func GetTransferStatusByIdempotencyKey(key string) (*TransferStatus, error) {
+	return queryTransferStatus("idempotency_key = ? AND idempotency_key != ''", key)
 }
 
 // GetTransferStatusByBlockId retrieves a transfer status by block ID
 func GetTransferStatusByBlockId(blockId string) (*TransferStatus, error) {
-	query := `
-		SELECT request_id, block_id, activity_ids, user_did, admin_did,
-		       reward_points, status, message, contract_hash, error_details,
-		       created_at, updated_at
-		FROM transfer_status
-		WHERE block_id = ?
-	`
-
-	var status TransferStatus
-	var activityIDsJSON string
+	return queryTransferStatus("block_id = ?", blockId)
+}
 
-	err := db.QueryRow(query, blockId).Scan(
-		&status.RequestID,
-		&status.BlockId,
-		&activityIDsJSON,
-		&status.UserDID,
-		&status.AdminDID,
-		&status.RewardPoints,
-		&status.Status,
-		&status.Message,
-		&status.ContractHash,
-		&status.ErrorDetails,
-		&status.CreatedAt,
-		&status.UpdatedAt,
+// ListOpenTransfers returns the transfers on a contract that no block has
+// been matched to yet
+func ListOpenTransfers(contractHash string) ([]*TransferStatus, error) {
+	return queryTransferStatuses(
+		"contract_hash = ? AND status IN ('pending', 'timeout') AND (block_id IS NULL OR block_id = '') ORDER BY created_at",
+		contractHash,
 	)
+}
 
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("transfer not found")
-	}
+// ListUnsettledTransfers returns every transfer that has not reached a final
+// state and was created before the given time
+func ListUnsettledTransfers(before time.Time) ([]*TransferStatus, error) {
+	statuses, err := queryTransferStatuses("status IN ('pending', 'processing', 'timeout') ORDER BY created_at")
 	if err != nil {
-		return nil, fmt.Errorf("failed to get transfer status: %w", err)
+		return nil, err
 	}
-
-	// Unmarshal activity IDs
-	if err := json.Unmarshal([]byte(activityIDsJSON), &status.ActivityIDs); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal activity IDs: %w", err)
+	// Filtered here rather than in SQL: created_at is stored as text with the
+	// writer's zone offset, so string comparison is not reliable
+	var unsettled []*TransferStatus
+	for _, status := range statuses {
+		if status.CreatedAt.Before(before) {
+			unsettled = append(unsettled, status)
+		}
 	}
+	return unsettled, nil
+}
 
-	return &status, nil
+// ClaimTransferBlock atomically binds a block to an open transfer and moves
+// it to "processing". It reports false if the transfer was already claimed,
+// so the contract for a block is only ever run once.
+func ClaimTransferBlock(requestID string, blockId string) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE transfer_status
+		SET block_id = ?, status = 'processing', message = ?, updated_at = ?
+		WHERE request_id = ?
+		  AND status IN ('pending', 'timeout')
+		  AND (block_id IS NULL OR block_id = '')
+	`, blockId, "Block confirmed, executing transfer contract", time.Now(), requestID)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim transfer block: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return false, nil
+	}
+	notifyTransferStatusChange(requestID)
+	return true, nil
 }
 
 // UpdateTransferStatus updates an existing transfer status
 func UpdateTransferStatus(requestID string, updates map[string]interface{}) error {
+	rowsAffected, err := updateTransferStatus(requestID, nil, updates)
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("transfer not found")
+	}
+
+	return nil
+}
+
+// UpdateTransferStatusIf applies updates only while the transfer is in one of
+// fromStatuses, and reports whether it did
+func UpdateTransferStatusIf(requestID string, fromStatuses []string, updates map[string]interface{}) (bool, error) {
+	rowsAffected, err := updateTransferStatus(requestID, fromStatuses, updates)
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+func updateTransferStatus(requestID string, fromStatuses []string, updates map[string]interface{}) (int64, error) {
 	// Build dynamic update query
 	query := "UPDATE transfer_status SET updated_at = ?"
 	args := []interface{}{time.Now()}
 
+	if transactionID, ok := updates["transaction_id"]; ok {
+		query += ", transaction_id = ?"
+		args = append(args, transactionID)
+	}
 	if blockId, ok := updates["block_id"]; ok {
 		query += ", block_id = ?"
 		args = append(args, blockId)
@@ -228,21 +593,27 @@ func UpdateTransferStatus(requestID string, updates map[string]interface{}) erro
 	query += " WHERE request_id = ?"
 	args = append(args, requestID)
 
+	if len(fromStatuses) > 0 {
+		query += " AND status IN (?" + strings.Repeat(", ?", len(fromStatuses)-1) + ")"
+		for _, status := range fromStatuses {
+			args = append(args, status)
+		}
+	}
+
 	result, err := db.Exec(query, args...)
 	if err != nil {
-		return fmt.Errorf("failed to update transfer status: %w", err)
+		return 0, fmt.Errorf("failed to update transfer status: %w", err)
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
 	}
-
-	if rowsAffected == 0 {
-		return fmt.Errorf("transfer not found")
+	if rowsAffected > 0 {
+		notifyTransferStatusChange(requestID)
 	}
 
-	return nil
+	return rowsAffected, nil
 }
 
 // CloseDB closes the database connection
@@ -0,0 +1,15 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// useTestDB points the package at a fresh database for the test
+func useTestDB(t *testing.T) {
+	t.Helper()
+	if err := InitDB(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { CloseDB() })
+}
@@ -0,0 +1,234 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrActivityNotFound is returned when an activity is not registered
+var ErrActivityNotFound = errors.New("activity not found")
+
+// ActivityRecord is an activity registered through the add_activity
+// contract. Re-registering an activity replaces its reward points and
+// anchoring block.
+type ActivityRecord struct {
+	ActivityID   string    `json:"activity_id"`
+	BlockHash    string    `json:"block_hash"`
+	RewardPoints int       `json:"reward_points"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// AdminRecord is an admin DID registered through the add_admin contract
+type AdminRecord struct {
+	AdminDID  string    `json:"admin_did"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UpsertActivity records an activity, or updates it if it is already
+// registered. Writing the same block twice is a no-op, but a block cannot
+// anchor two different activities.
+func UpsertActivity(activity ActivityRecord) error {
+	now := time.Now()
+	_, err := db.Exec(`
+		INSERT INTO activities (activity_id, block_hash, reward_points, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(activity_id) DO UPDATE SET
+			block_hash = excluded.block_hash,
+			reward_points = excluded.reward_points,
+			updated_at = excluded.updated_at
+	`, activity.ActivityID, activity.BlockHash, activity.RewardPoints, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to store activity %s: %w", activity.ActivityID, err)
+	}
+	return nil
+}
+
+// GetActivity retrieves a registered activity
+func GetActivity(activityID string) (*ActivityRecord, error) {
+	var activity ActivityRecord
+	err := db.QueryRow(
+		`SELECT activity_id, block_hash, reward_points, created_at, updated_at FROM activities WHERE activity_id = ?`,
+		activityID,
+	).Scan(&activity.ActivityID, &activity.BlockHash, &activity.RewardPoints, &activity.CreatedAt, &activity.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrActivityNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get activity: %w", err)
+	}
+	return &activity, nil
+}
+
+// ListActivities returns every registered activity in registration order
+func ListActivities() ([]ActivityRecord, error) {
+	rows, err := db.Query(`SELECT activity_id, block_hash, reward_points, created_at, updated_at FROM activities ORDER BY rowid`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list activities: %w", err)
+	}
+	defer rows.Close()
+
+	var activities []ActivityRecord
+	for rows.Next() {
+		var activity ActivityRecord
+		if err := rows.Scan(&activity.ActivityID, &activity.BlockHash, &activity.RewardPoints, &activity.CreatedAt, &activity.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to read activity: %w", err)
+		}
+		activities = append(activities, activity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list activities: %w", err)
+	}
+	return activities, nil
+}
+
+// Admin event actions
+const (
+	AdminAdded   = "added"
+	AdminRemoved = "removed"
+)
+
+// ErrLastAdmin is returned when removing an admin would leave none
+var ErrLastAdmin = errors.New("cannot remove the last admin")
+
+// AdminEvent is one entry in the admin history: who added or removed whom,
+// and in which block of the add_admin contract. Admins imported from a
+// file have no actor or block.
+type AdminEvent struct {
+	ID        int64     `json:"id"`
+	Action    string    `json:"action"`
+	AdminDID  string    `json:"admin_did"`
+	ActorDID  string    `json:"actor_did,omitempty"`
+	BlockID   string    `json:"block_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AddAdmin records an admin DID and reports whether it was new
+func AddAdmin(adminDID string) (bool, error) {
+	return ApplyAdminEvent(AdminEvent{Action: AdminAdded, AdminDID: adminDID})
+}
+
+// RemoveAdmin drops an admin DID and reports whether it was registered
+func RemoveAdmin(adminDID string) (bool, error) {
+	return ApplyAdminEvent(AdminEvent{Action: AdminRemoved, AdminDID: adminDID})
+}
+
+// ApplyAdminEvent adds or removes an admin and appends the event to the
+// admin history, in one transaction. It reports whether the admin set
+// changed; replaying an event that is already applied records nothing.
+// Removing the only remaining admin fails with ErrLastAdmin.
+func ApplyAdminEvent(event AdminEvent) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	var result sql.Result
+	switch event.Action {
+	case AdminAdded:
+		result, err = tx.Exec(
+			`INSERT INTO admins (admin_did, created_at) VALUES (?, ?) ON CONFLICT(admin_did) DO NOTHING`,
+			event.AdminDID, event.CreatedAt,
+		)
+	case AdminRemoved:
+		var remaining int
+		err = tx.QueryRow(`SELECT COUNT(*) FROM admins WHERE admin_did != ?`, event.AdminDID).Scan(&remaining)
+		if err != nil {
+			return false, fmt.Errorf("failed to count admins: %w", err)
+		}
+		if remaining == 0 {
+			return false, ErrLastAdmin
+		}
+		result, err = tx.Exec(`DELETE FROM admins WHERE admin_did = ?`, event.AdminDID)
+	default:
+		return false, fmt.Errorf("unknown admin action %q", event.Action)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to store admin %s: %w", event.AdminDID, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return false, nil
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO admin_events (action, admin_did, actor_did, block_id, created_at) VALUES (?, ?, ?, ?, ?)`,
+		event.Action, event.AdminDID, event.ActorDID, event.BlockID, event.CreatedAt,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to record admin event: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit admin event: %w", err)
+	}
+	return true, nil
+}
+
+// ListAdminEvents returns the admin history, oldest first. If adminDID is
+// set only the events concerning that admin are returned.
+func ListAdminEvents(adminDID string) ([]AdminEvent, error) {
+	query := `SELECT id, action, admin_did, actor_did, block_id, created_at FROM admin_events`
+	var args []interface{}
+	if adminDID != "" {
+		query += ` WHERE admin_did = ?`
+		args = append(args, adminDID)
+	}
+	rows, err := db.Query(query+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list admin events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []AdminEvent
+	for rows.Next() {
+		var event AdminEvent
+		if err := rows.Scan(&event.ID, &event.Action, &event.AdminDID, &event.ActorDID, &event.BlockID, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to read admin event: %w", err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list admin events: %w", err)
+	}
+	return events, nil
+}
+
+// IsAdmin reports whether a DID is a registered admin
+func IsAdmin(adminDID string) (bool, error) {
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM admins WHERE admin_did = ?`, adminDID).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to look up admin: %w", err)
+	}
+	return count > 0, nil
+}
+
+// ListAdmins returns every registered admin in registration order
+func ListAdmins() ([]AdminRecord, error) {
+	rows, err := db.Query(`SELECT admin_did, created_at FROM admins ORDER BY rowid`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list admins: %w", err)
+	}
+	defer rows.Close()
+
+	var admins []AdminRecord
+	for rows.Next() {
+		var admin AdminRecord
+		if err := rows.Scan(&admin.AdminDID, &admin.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to read admin: %w", err)
+		}
+		admins = append(admins, admin)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list admins: %w", err)
+	}
+	return admins, nil
+}
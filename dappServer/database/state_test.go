@@ -0,0 +1,70 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestActivityReRegistrationReplacesIt(t *testing.T) {
+	useTestDB(t)
+	for _, activity := range []ActivityRecord{
+		{ActivityID: "yoga", BlockHash: "block-1", RewardPoints: 50},
+		{ActivityID: "yoga", BlockHash: "block-2", RewardPoints: 10},
+		{ActivityID: "yoga", BlockHash: "block-2", RewardPoints: 10},
+		{ActivityID: "swim", BlockHash: "block-3", RewardPoints: 20},
+	} {
+		if err := UpsertActivity(activity); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	activity, err := GetActivity("yoga")
+	if err != nil || activity.BlockHash != "block-2" || activity.RewardPoints != 10 {
+		t.Fatalf("re-registered activity = %+v, %v", activity, err)
+	}
+	if activities, err := ListActivities(); err != nil || len(activities) != 2 || activities[0].ActivityID != "yoga" {
+		t.Fatalf("activities = %+v, %v", activities, err)
+	}
+	if err := UpsertActivity(ActivityRecord{ActivityID: "run", BlockHash: "block-3", RewardPoints: 5}); err == nil {
+		t.Fatal("a block anchored a second activity")
+	}
+	if _, err := GetActivity("never-registered"); !errors.Is(err, ErrActivityNotFound) {
+		t.Fatalf("missing activity = %v", err)
+	}
+}
+
+func TestAdminEventsAreRecordedOnce(t *testing.T) {
+	useTestDB(t)
+	const first, second = "bafybmifirstadmin", "bafybmisecondadmin"
+	for _, event := range []AdminEvent{
+		{Action: AdminAdded, AdminDID: first},
+		{Action: AdminAdded, AdminDID: second, ActorDID: first, BlockID: "block-1"},
+		{Action: AdminRemoved, AdminDID: second, ActorDID: first, BlockID: "block-2"},
+	} {
+		if changed, err := ApplyAdminEvent(event); err != nil || !changed {
+			t.Fatalf("%s %s = %v, %v", event.Action, event.AdminDID, changed, err)
+		}
+	}
+	// Replayed callbacks change nothing and leave no event
+	if changed, err := ApplyAdminEvent(AdminEvent{Action: AdminRemoved, AdminDID: second, ActorDID: first, BlockID: "block-2"}); err != nil || changed {
+		t.Fatalf("replayed removal = %v, %v", changed, err)
+	}
+	if changed, err := AddAdmin(first); err != nil || changed {
+		t.Fatalf("re-adding an admin = %v, %v", changed, err)
+	}
+
+	events, err := ListAdminEvents(second)
+	if err != nil || len(events) != 2 || events[0].Action != AdminAdded || events[1].Action != AdminRemoved || events[1].BlockID != "block-2" {
+		t.Fatalf("history of %s = %+v, %v", second, events, err)
+	}
+	if events, _ := ListAdminEvents(""); len(events) != 3 {
+		t.Fatalf("history = %+v", events)
+	}
+
+	if _, err := RemoveAdmin(first); !errors.Is(err, ErrLastAdmin) {
+		t.Fatalf("removing the last admin = %v", err)
+	}
+	if isAdmin, err := IsAdmin(first); err != nil || !isAdmin {
+		t.Fatalf("last admin after a refused removal = %v, %v", isAdmin, err)
+	}
+}
@@ -0,0 +1,430 @@
+// Package fakenode is an in-process stand-in for a Rubix node. It serves the
+// smart contract endpoints used by the dApp server, keeps an in-memory token
+// chain per contract and fires registered callback URLs the way a real node
+// does, so handlers can be exercised with httptest and demoed without a
+// running quorum.
+package fakenode
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	rubix_interaction "dapp-server/rubix-interaction"
+)
+
+type contract struct {
+	hash     string
+	deployer string
+	deployed bool
+	blocks   []rubix_interaction.SmartContractBlock
+}
+
+type requestKind int
+
+const (
+	deployRequest requestKind = iota
+	executeRequest
+	registerDIDRequest
+)
+
+type signatureRequest struct {
+	kind     requestKind
+	token    string
+	did      string
+	data     string
+	password string
+}
+
+// Node is a fake Rubix node. It implements http.Handler.
+type Node struct {
+	port           string
+	password       string
+	asyncCallbacks bool
+	httpClient     *http.Client
+	mux            *http.ServeMux
+
+	mu        sync.Mutex
+	seq       int
+	contracts map[string]*contract
+	requests  map[string]*signatureRequest
+	callbacks map[string]string
+	dids      map[string]bool
+	wg        sync.WaitGroup
+}
+
+// Option configures a Node
+type Option func(*Node)
+
+// WithPassword makes signature responses fail unless they carry password
+func WithPassword(password string) Option {
+	return func(n *Node) {
+		n.password = password
+	}
+}
+
+// WithAsyncCallbacks fires callbacks after the signature response has been
+// answered instead of before it
+func WithAsyncCallbacks() Option {
+	return func(n *Node) {
+		n.asyncCallbacks = true
+	}
+}
+
+// WithHTTPClient sets the client used to fire callbacks
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(n *Node) {
+		n.httpClient = httpClient
+	}
+}
+
+// New returns a node that reports port in the callbacks it fires
+func New(port string, opts ...Option) *Node {
+	n := &Node{
+		port:       port,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		contracts:  make(map[string]*contract),
+		requests:   make(map[string]*signatureRequest),
+		callbacks:  make(map[string]string),
+		dids:       make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+
+	n.mux = http.NewServeMux()
+	n.mux.HandleFunc("/api/generate-smart-contract", n.handleGenerate)
+	n.mux.HandleFunc("/api/deploy-smart-contract", n.handleDeploy)
+	n.mux.HandleFunc("/api/execute-smart-contract", n.handleExecute)
+	n.mux.HandleFunc("/api/signature-response", n.handleSignature)
+	n.mux.HandleFunc("/api/get-smart-contract-token-chain-data", n.handleTokenChain)
+	n.mux.HandleFunc("/api/register-callback-url", n.handleRegisterCallback)
+	n.mux.HandleFunc("/api/register-did", n.handleRegisterDID)
+	return n
+}
+
+func (n *Node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	n.mux.ServeHTTP(w, r)
+}
+
+// Port returns the port the node reports in its callbacks
+func (n *Node) Port() string {
+	return n.port
+}
+
+// AddContract registers an already deployed contract with a genesis block
+func (n *Node) AddContract(hash string, deployerDID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.contracts[hash] = &contract{
+		hash:     hash,
+		deployer: deployerDID,
+		deployed: true,
+		blocks:   []rubix_interaction.SmartContractBlock{n.newBlockLocked(0, "", deployerDID, "")},
+	}
+}
+
+// RegisterCallback sets the callback URL for a contract, as
+// /api/register-callback-url would
+func (n *Node) RegisterCallback(hash string, callbackURL string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.callbacks[hash] = callbackURL
+}
+
+// CallbackURL returns the callback URL registered for a contract
+func (n *Node) CallbackURL(hash string) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.callbacks[hash]
+}
+
+// Deployed reports whether the contract has been deployed on this node
+func (n *Node) Deployed(hash string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	c, ok := n.contracts[hash]
+	return ok && c.deployed
+}
+
+// Blocks returns a copy of the token chain of a contract
+func (n *Node) Blocks(hash string) []rubix_interaction.SmartContractBlock {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	c, ok := n.contracts[hash]
+	if !ok {
+		return nil
+	}
+	return append([]rubix_interaction.SmartContractBlock(nil), c.blocks...)
+}
+
+// Wait blocks until every asynchronous callback has been delivered
+func (n *Node) Wait() {
+	n.wg.Wait()
+}
+
+func (n *Node) handleGenerate(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeV1(w, false, "invalid multipart form: "+err.Error(), "")
+		return
+	}
+	did := r.FormValue("did")
+	if did == "" {
+		writeV1(w, false, "did is required", "")
+		return
+	}
+
+	digest := sha256.New()
+	for _, field := range []string{"binaryCodePath", "rawCodePath", "schemaFilePath"} {
+		file, _, err := r.FormFile(field)
+		if err != nil {
+			writeV1(w, false, fmt.Sprintf("missing %s: %v", field, err), "")
+			return
+		}
+		_, err = io.Copy(digest, file)
+		file.Close()
+		if err != nil {
+			writeV1(w, false, err.Error(), "")
+			return
+		}
+	}
+	hash := "Qm" + hex.EncodeToString(digest.Sum(nil))[:44]
+
+	n.mu.Lock()
+	if _, exists := n.contracts[hash]; !exists {
+		n.contracts[hash] = &contract{hash: hash, deployer: did}
+	}
+	n.mu.Unlock()
+
+	writeV1(w, true, "Smart contract generated successfully", hash)
+}
+
+func (n *Node) handleDeploy(w http.ResponseWriter, r *http.Request) {
+	var req rubix_interaction.DeploySmartContractRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeV2(w, false, "invalid request body", "")
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.contracts[req.SmartContractToken]; !ok {
+		writeV2(w, false, "smart contract token not found", "")
+		return
+	}
+	id := n.addRequestLocked(&signatureRequest{kind: deployRequest, token: req.SmartContractToken, did: req.DeployerAddr})
+	writeV2(w, true, "Signature needed", id)
+}
+
+func (n *Node) handleExecute(w http.ResponseWriter, r *http.Request) {
+	var req rubix_interaction.ExecuteSmartContractRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeV2(w, false, "invalid request body", "")
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	c, ok := n.contracts[req.SmartContractToken]
+	if !ok || !c.deployed {
+		writeV2(w, false, "smart contract is not deployed", "")
+		return
+	}
+	id := n.addRequestLocked(&signatureRequest{
+		kind:  executeRequest,
+		token: req.SmartContractToken,
+		did:   req.ExecutorAddr,
+		data:  req.SmartContractData,
+	})
+	writeV2(w, true, "Signature needed", id)
+}
+
+func (n *Node) handleRegisterDID(w http.ResponseWriter, r *http.Request) {
+	var req rubix_interaction.RegisterDIDRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DID == "" {
+		writeV2(w, false, "invalid request body", "")
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	id := n.addRequestLocked(&signatureRequest{kind: registerDIDRequest, did: req.DID})
+	writeV2(w, true, "Signature needed", id)
+}
+
+func (n *Node) handleSignature(w http.ResponseWriter, r *http.Request) {
+	var req rubix_interaction.SignatureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeV1(w, false, "invalid request body", "")
+		return
+	}
+
+	n.mu.Lock()
+	pending, ok := n.requests[req.Id]
+	if !ok {
+		n.mu.Unlock()
+		writeV1(w, false, "signature request not found", "")
+		return
+	}
+	if n.password != "" && req.Password != n.password {
+		n.mu.Unlock()
+		writeV1(w, false, "invalid password", "")
+		return
+	}
+	delete(n.requests, req.Id)
+
+	var callbackURL string
+	var result string
+	message := "Signature accepted"
+	switch pending.kind {
+	case deployRequest:
+		c := n.contracts[pending.token]
+		c.deployed = true
+		c.blocks = append(c.blocks, n.newBlockLocked(0, "", pending.did, ""))
+		message = "Smart contract deployed successfully"
+	case executeRequest:
+		c := n.contracts[pending.token]
+		n.seq++
+		txID := n.hashLocked(pending.token, pending.data, strconv.Itoa(n.seq))
+		block := n.newBlockLocked(uint64(len(c.blocks)), pending.data, pending.did, txID)
+		c.blocks = append(c.blocks, block)
+		callbackURL = n.callbacks[pending.token]
+		result = txID
+		message = "Smart contract executed successfully"
+	case registerDIDRequest:
+		n.dids[pending.did] = true
+		message = "DID registered successfully"
+	}
+	n.mu.Unlock()
+
+	if callbackURL == "" {
+		writeV1(w, true, message, result)
+		return
+	}
+	if !n.asyncCallbacks {
+		// A real node calls back before it answers the signature response
+		n.fireCallback(callbackURL, pending.token)
+		writeV1(w, true, message, result)
+		return
+	}
+	writeV1(w, true, message, result)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.fireCallback(callbackURL, pending.token)
+	}()
+}
+
+func (n *Node) handleTokenChain(w http.ResponseWriter, r *http.Request) {
+	var req rubix_interaction.TokenChainDataRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, rubix_interaction.TokenChainDataResponse{Message: "invalid request body"})
+		return
+	}
+
+	n.mu.Lock()
+	c, ok := n.contracts[req.Token]
+	var blocks []rubix_interaction.SmartContractBlock
+	if ok {
+		blocks = append(blocks, c.blocks...)
+	}
+	n.mu.Unlock()
+
+	if !ok || len(blocks) == 0 {
+		writeJSON(w, rubix_interaction.TokenChainDataResponse{Message: "no token chain data found"})
+		return
+	}
+	if req.Latest {
+		blocks = blocks[len(blocks)-1:]
+	}
+	writeJSON(w, rubix_interaction.TokenChainDataResponse{
+		Status:       true,
+		Message:      "Fetched smart contract token chain data",
+		SCTDataReply: blocks,
+	})
+}
+
+func (n *Node) handleRegisterCallback(w http.ResponseWriter, r *http.Request) {
+	var req rubix_interaction.RegisterCallbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CallBackURL == "" || req.SmartContractToken == "" {
+		writeV1(w, false, "invalid request body", "")
+		return
+	}
+	n.RegisterCallback(req.SmartContractToken, req.CallBackURL)
+	writeV1(w, true, "Callback URL registered successfully", "")
+}
+
+func (n *Node) fireCallback(callbackURL string, hash string) {
+	body, _ := json.Marshal(map[string]string{
+		"smart_contract_hash": hash,
+		"port":                n.port,
+	})
+	resp, err := n.httpClient.Post(callbackURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		fmt.Printf("fakenode: callback to %s failed: %v\n", callbackURL, err)
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+func (n *Node) addRequestLocked(req *signatureRequest) string {
+	n.seq++
+	id := n.hashLocked("request", strconv.Itoa(n.seq))
+	n.requests[id] = req
+	return id
+}
+
+func (n *Node) newBlockLocked(blockNo uint64, data string, executor string, signData string) rubix_interaction.SmartContractBlock {
+	n.seq++
+	return rubix_interaction.SmartContractBlock{
+		BlockNo:            blockNo,
+		BlockId:            fmt.Sprintf("%d-%s", blockNo, n.hashLocked(data, executor, strconv.Itoa(n.seq))),
+		SmartContractData:  data,
+		Epoch:              uint64(time.Now().Unix()),
+		InitiatorSignature: n.hashLocked("signature", signData),
+		ExecutorDID:        executor,
+		InitiatorSignData:  signData,
+	}
+}
+
+func (n *Node) hashLocked(parts ...string) string {
+	h := sha256.New()
+	h.Write([]byte(n.port))
+	for _, p := range parts {
+		h.Write([]byte{0})
+		h.Write([]byte(p))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func writeV1(w http.ResponseWriter, status bool, message string, result string) {
+	writeJSON(w, rubix_interaction.SmartContractAPIResponseV1{Status: status, Message: message, Result: result})
+}
+
+func writeV2(w http.ResponseWriter, status bool, message string, id string) {
+	writeJSON(w, rubix_interaction.SmartContractAPIResponseV2{
+		Status:  status,
+		Message: message,
+		Result:  rubix_interaction.SmartContractResult{Id: id},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -1,17 +1,32 @@
 package main
 
 import (
+	"dapp-server/commands"
 	"dapp-server/config"
 	"dapp-server/database"
+	rubix_interaction "dapp-server/rubix-interaction"
 	"dapp-server/server"
 	"fmt"
 	"log"
+	"os"
+
+	"github.com/spf13/cobra"
 )
 
 const CONFIG_PATH = ".config/config.toml"
 const DB_PATH = "./transfer_status.db"
 
 func main() {
+	// Without a subcommand the binary runs the dApp server
+	commands.RootCmd.Run = func(cmd *cobra.Command, args []string) {
+		runServer()
+	}
+	if err := commands.RootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func runServer() {
 	// Initialize database
 	fmt.Println("Initializing database...")
 	err := database.InitDB(DB_PATH)
@@ -24,6 +39,21 @@ func main() {
 	config.LoadConfig(CONFIG_PATH)
 	config.LoadEnvConfig()
 
+	// Signing credentials come from the keystore or the environment
+	credentials, err := rubix_interaction.LoadCredentialProvider(
+		config.GetEnvConfig().SigningKeystore,
+		rubix_interaction.Secret(os.Getenv("SIGNING_KEYSTORE_PASSPHRASE")),
+	)
+	if err != nil {
+		log.Fatalf("Failed to load signing credentials: %v", err)
+	}
+	rubix_interaction.SetCredentialProvider(credentials)
+
+	// Contract hashes still set in .config/.env seed the contract registry
+	if err := server.SeedContractRegistry(); err != nil {
+		log.Fatalf("Failed to seed the contract registry: %v", err)
+	}
+
 	// Start server
 	server.BootupServer()
 }
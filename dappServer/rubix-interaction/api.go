@@ -1,80 +1,128 @@
 package rubix_interaction
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
-	"io"
-	"net/http"
+	"strings"
 )
 
-func GetSmartContractData(token string, address string) []byte {
-	data := map[string]interface{}{
-		"token":  token,
-		"latest": true,
-	}
-	bodyJSON, err := json.Marshal(data)
+// Sign answers the signature request requestID on the node behind client,
+// as did, with the credential the configured CredentialProvider holds for it
+func Sign(ctx context.Context, client NodeClient, did string, requestID string) (*SmartContractAPIResponseV1, error) {
+	credential, err := CredentialFor(did)
 	if err != nil {
-		fmt.Println("Error marshaling JSON:", err)
-		return nil
+		return nil, err
 	}
-	url := address + "/api/get-smart-contract-token-chain-data"
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(bodyJSON))
+	return client.SignatureResponse(ctx, &SignatureRequest{
+		Id:       requestID,
+		Mode:     credential.Mode,
+		Password: credential.Password.Reveal(),
+	})
+}
+
+// GetLatestBlock fetches the most recent block of a smart contract token chain
+func GetLatestBlock(ctx context.Context, client NodeClient, contractHash string) (*SmartContractBlock, error) {
+	data, err := client.GetSmartContractTokenChainData(ctx, &TokenChainDataRequest{
+		Token:  contractHash,
+		Latest: true,
+	})
 	if err != nil {
-		fmt.Println("Error creating HTTP request:", err)
-		return nil
+		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	block := data.LatestBlock()
+	if block == nil {
+		return nil, fmt.Errorf("unable to fetch blocks for smart contract token : %v", contractHash)
+	}
+	return block, nil
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Println("Error sending HTTP request:", err)
-		return nil
+// CallbackTokenParam is the query parameter that carries a callback
+// registration's secret
+const CallbackTokenParam = "callback_token"
+
+// RegisterCallBackUrl asks the node behind client to call endPoint on the
+// dApp server reachable at baseURL whenever the contract is executed. Each
+// registration gets a fresh secret, carried in the callback URL. It returns
+// the URL without the secret, and the secret, for the server to keep so it
+// only accepts callbacks that present it. Registering again replaces the
+// secret.
+func RegisterCallBackUrl(ctx context.Context, client NodeClient, smartContractTokenHash string, baseURL string, endPoint string) (url string, secret string, err error) {
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", "", fmt.Errorf("failed to generate callback secret: %w", err)
 	}
+	secret = hex.EncodeToString(secretBytes)
+	endPoint = strings.TrimPrefix(endPoint, "/")
 
-	fmt.Println("Response Status:", resp.Status)
-	data2, err := io.ReadAll(resp.Body)
+	url = strings.TrimRight(baseURL, "/") + "/" + endPoint
+	err = client.RegisterCallbackURL(ctx, &RegisterCallbackRequest{
+		CallBackURL:        fmt.Sprintf("%s?%s=%s", url, CallbackTokenParam, secret),
+		SmartContractToken: smartContractTokenHash,
+	})
 	if err != nil {
-		fmt.Printf("Error reading response body: %s\n", err)
-		return nil
+		return "", "", err
 	}
-	// Process the data as needed
-	fmt.Println("Response Body in get smart contract data :", string(data2))
+	return url, secret, nil
+}
 
-	return data2
+// CallbackTarget is a node to register a contract's callback URL with
+type CallbackTarget struct {
+	Client   NodeClient
+	NodePort string
+	BaseURL  string
+	Endpoint string
+}
 
+// CallbackResult is the outcome of registering a callback URL with a node.
+// A registration that succeeded has the URL, without the secret, and the
+// secret the caller must keep.
+type CallbackResult struct {
+	NodePort string `json:"node_port"`
+	Endpoint string `json:"endpoint"`
+	URL      string `json:"url,omitempty"`
+	Secret   string `json:"-"`
+	Error    string `json:"error,omitempty"`
 }
 
-func RegisterCallBackUrl(smartContractTokenHash string, urlPort string, endPoint string, nodePort string) {
-	callBackUrl := fmt.Sprintf("http://localhost:%s/%s", urlPort, endPoint)
-	data := map[string]interface{}{
-		"CallBackURL":        callBackUrl,
-		"SmartContractToken": smartContractTokenHash,
+// RegisterCallbacks registers the contract's callback URL with every target.
+// A node that refuses does not stop the others; its result carries the error.
+// Nothing is stored: the caller keeps each secret.
+func RegisterCallbacks(ctx context.Context, contractHash string, targets []CallbackTarget) []CallbackResult {
+	results := make([]CallbackResult, 0, len(targets))
+	for _, target := range targets {
+		result := CallbackResult{NodePort: target.NodePort, Endpoint: strings.TrimPrefix(target.Endpoint, "/")}
+		url, secret, err := RegisterCallBackUrl(ctx, target.Client, contractHash, target.BaseURL, target.Endpoint)
+		if err != nil {
+			fmt.Printf("Failed to register callback url with node %s: %v\n", target.NodePort, err)
+			result.Error = err.Error()
+		}
+		result.URL, result.Secret = url, secret
+		results = append(results, result)
 	}
-	bodyJSON, err := json.Marshal(data)
-	if err != nil {
-		fmt.Println("Error marshaling JSON:", err)
-		return
-	}
-	url := fmt.Sprintf("http://localhost:%s/api/register-callback-url", nodePort)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(bodyJSON))
-	if err != nil {
-		fmt.Println("Error creating HTTP request:", err)
-		return
-	}
-	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Println("Error sending HTTP request:", err)
-		return
+	return results
+}
+
+// FailedCallbacks counts the registrations that failed
+func FailedCallbacks(results []CallbackResult) int {
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
 	}
-	fmt.Println("Response Status:", resp.Status)
-	data2, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("Error reading response body: %s\n", err)
-		return
+	return failed
+}
+
+// NewExecuteSmartContractRequest builds the execute request used for every
+// contract call made by the dApp server
+func NewExecuteSmartContractRequest(contractHash string, executorDid string, contractMsg string) *ExecuteSmartContractRequest {
+	return &ExecuteSmartContractRequest{
+		Comment:            "Contract execution",
+		ExecutorAddr:       executorDid,
+		QuorumType:         2,
+		SmartContractData:  contractMsg,
+		SmartContractToken: contractHash,
 	}
-	fmt.Println("Response Body in register callback url :", string(data2))
 }
@@ -0,0 +1,295 @@
+package rubix_interaction
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultRequestTimeout bounds a single call to a Rubix node. Signature
+// responses wait on quorum consensus, so this is deliberately generous.
+const DefaultRequestTimeout = 2 * time.Minute
+
+// NodeClient is the set of Rubix node endpoints used by the dApp server.
+// Handlers depend on this interface rather than on RubixClient directly.
+type NodeClient interface {
+	BaseURL() string
+	GenerateSmartContract(ctx context.Context, req *GenerateSmartContractRequest) (string, error)
+	DeploySmartContract(ctx context.Context, req *DeploySmartContractRequest) (string, error)
+	ExecuteSmartContract(ctx context.Context, req *ExecuteSmartContractRequest) (string, error)
+	SignatureResponse(ctx context.Context, req *SignatureRequest) (*SmartContractAPIResponseV1, error)
+	GetSmartContractTokenChainData(ctx context.Context, req *TokenChainDataRequest) (*TokenChainDataResponse, error)
+	RegisterCallbackURL(ctx context.Context, req *RegisterCallbackRequest) error
+	RegisterDID(ctx context.Context, req *RegisterDIDRequest) (string, error)
+}
+
+// NodeError is returned when a Rubix node answers but rejects the call
+type NodeError struct {
+	Endpoint   string
+	StatusCode int
+	Message    string
+}
+
+func (e *NodeError) Error() string {
+	if e.StatusCode != 0 && e.StatusCode != http.StatusOK {
+		return fmt.Sprintf("%s: node returned %d: %s", e.Endpoint, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Endpoint, e.Message)
+}
+
+// defaultHTTPClient is shared by every RubixClient so connections to the
+// local nodes are pooled instead of re-dialled on each call.
+var defaultHTTPClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        64,
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
+// RubixClient talks to a single Rubix node over its HTTP API
+type RubixClient struct {
+	baseURL    string
+	httpClient *http.Client
+	timeout    time.Duration
+}
+
+// ClientOption configures a RubixClient
+type ClientOption func(*RubixClient)
+
+// WithHTTPClient replaces the shared HTTP client
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(c *RubixClient) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTimeout sets the per-call timeout. Zero disables it and leaves the
+// deadline entirely to the caller's context.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(c *RubixClient) {
+		c.timeout = timeout
+	}
+}
+
+// NewRubixClient returns a client for the node at baseURL
+func NewRubixClient(baseURL string, opts ...ClientOption) *RubixClient {
+	c := &RubixClient{
+		baseURL:    baseURL,
+		httpClient: defaultHTTPClient,
+		timeout:    DefaultRequestTimeout,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// LocalNodeURL returns the base URL of a node listening on localhost
+func LocalNodeURL(port string) string {
+	return fmt.Sprintf("http://localhost:%s", port)
+}
+
+func (c *RubixClient) BaseURL() string {
+	return c.baseURL
+}
+
+// GenerateSmartContract uploads the contract's artifacts. They are streamed
+// from disk as the multipart body is sent rather than buffered in memory.
+func (c *RubixClient) GenerateSmartContract(ctx context.Context, req *GenerateSmartContractRequest) (string, error) {
+	files := []struct {
+		field string
+		path  string
+		file  *os.File
+	}{
+		{field: "binaryCodePath", path: req.WasmPath},
+		{field: "rawCodePath", path: req.LibPath},
+		{field: "schemaFilePath", path: req.StatePath},
+	}
+	// Open every file first so a missing one fails before anything is sent
+	for i := range files {
+		file, err := os.Open(files[i].path)
+		if err != nil {
+			return "", fmt.Errorf("failed to open %s: %w", files[i].field, err)
+		}
+		defer file.Close()
+		files[i].file = file
+	}
+
+	body, pipe := io.Pipe()
+	writer := multipart.NewWriter(pipe)
+	go func() {
+		err := writer.WriteField("did", req.DID)
+		if err != nil {
+			err = fmt.Errorf("failed to add did field: %w", err)
+		}
+		for _, f := range files {
+			if err != nil {
+				break
+			}
+			err = addFormFile(writer, f.field, f.path, f.file)
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		pipe.CloseWithError(err)
+	}()
+	// Unblock the writer if the request ends before the body is read
+	defer body.Close()
+
+	var apiResp SmartContractAPIResponseV1
+	endpoint := "/api/generate-smart-contract"
+	if err := c.do(ctx, endpoint, writer.FormDataContentType(), body, &apiResp); err != nil {
+		return "", err
+	}
+	if !apiResp.Status {
+		return "", &NodeError{Endpoint: endpoint, Message: apiResp.Message}
+	}
+	return apiResp.Result, nil
+}
+
+func (c *RubixClient) DeploySmartContract(ctx context.Context, req *DeploySmartContractRequest) (string, error) {
+	var apiResp SmartContractAPIResponseV2
+	endpoint := "/api/deploy-smart-contract"
+	if err := c.postJSON(ctx, endpoint, req, &apiResp); err != nil {
+		return "", err
+	}
+	if !apiResp.Status {
+		return "", &NodeError{Endpoint: endpoint, Message: apiResp.Message}
+	}
+	return apiResp.Result.Id, nil
+}
+
+func (c *RubixClient) ExecuteSmartContract(ctx context.Context, req *ExecuteSmartContractRequest) (string, error) {
+	var apiResp SmartContractAPIResponseV2
+	endpoint := "/api/execute-smart-contract"
+	if err := c.postJSON(ctx, endpoint, req, &apiResp); err != nil {
+		return "", err
+	}
+	if !apiResp.Status {
+		return "", &NodeError{Endpoint: endpoint, Message: apiResp.Message}
+	}
+	return apiResp.Result.Id, nil
+}
+
+// SignatureResponse answers a pending signature request. The parsed
+// response is returned alongside a rejection so callers can inspect it.
+func (c *RubixClient) SignatureResponse(ctx context.Context, req *SignatureRequest) (*SmartContractAPIResponseV1, error) {
+	var apiResp SmartContractAPIResponseV1
+	endpoint := "/api/signature-response"
+	if err := c.postJSON(ctx, endpoint, req, &apiResp); err != nil {
+		return nil, err
+	}
+	if !apiResp.Status {
+		return &apiResp, &NodeError{Endpoint: endpoint, Message: apiResp.Message}
+	}
+	return &apiResp, nil
+}
+
+func (c *RubixClient) GetSmartContractTokenChainData(ctx context.Context, req *TokenChainDataRequest) (*TokenChainDataResponse, error) {
+	var apiResp TokenChainDataResponse
+	endpoint := "/api/get-smart-contract-token-chain-data"
+	if err := c.postJSON(ctx, endpoint, req, &apiResp); err != nil {
+		return nil, err
+	}
+	if !apiResp.Status {
+		return &apiResp, &NodeError{Endpoint: endpoint, Message: apiResp.Message}
+	}
+	return &apiResp, nil
+}
+
+func (c *RubixClient) RegisterCallbackURL(ctx context.Context, req *RegisterCallbackRequest) error {
+	var apiResp SmartContractAPIResponseV1
+	endpoint := "/api/register-callback-url"
+	if err := c.postJSON(ctx, endpoint, req, &apiResp); err != nil {
+		return err
+	}
+	if !apiResp.Status {
+		return &NodeError{Endpoint: endpoint, Message: apiResp.Message}
+	}
+	return nil
+}
+
+// RegisterDID starts DID registration and returns the signature request ID
+func (c *RubixClient) RegisterDID(ctx context.Context, req *RegisterDIDRequest) (string, error) {
+	var apiResp SmartContractAPIResponseV2
+	endpoint := "/api/register-did"
+	if err := c.postJSON(ctx, endpoint, req, &apiResp); err != nil {
+		return "", err
+	}
+	if !apiResp.Status {
+		return "", &NodeError{Endpoint: endpoint, Message: apiResp.Message}
+	}
+	return apiResp.Result.Id, nil
+}
+
+func (c *RubixClient) postJSON(ctx context.Context, endpoint string, body interface{}, out interface{}) error {
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request body: %w", err)
+	}
+	return c.do(ctx, endpoint, "application/json", bytes.NewReader(bodyBytes), out)
+}
+
+// do sends a POST to endpoint and decodes the JSON reply into out
+func (c *RubixClient) do(ctx context.Context, endpoint string, contentType string, body io.Reader, out interface{}) error {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	requestURL, err := url.JoinPath(c.baseURL, endpoint)
+	if err != nil {
+		return fmt.Errorf("%s: unable to form request URL: %w", endpoint, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, requestURL, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: failed to send request: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%s: failed to read response: %w", endpoint, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &NodeError{Endpoint: endpoint, StatusCode: resp.StatusCode, Message: string(respBody)}
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("%s: failed to parse response: %w", endpoint, err)
+	}
+	return nil
+}
+
+func addFormFile(writer *multipart.Writer, field string, path string, file io.Reader) error {
+	part, err := writer.CreateFormFile(field, filepath.Base(path))
+	if err != nil {
+		return fmt.Errorf("failed to create %s form file: %w", field, err)
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", field, err)
+	}
+	return nil
+}
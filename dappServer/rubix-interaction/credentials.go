@@ -0,0 +1,318 @@
+package rubix_interaction
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Signature modes of a Rubix DID, numbered as the node's
+// /api/signature-response expects them
+const (
+	SignatureModeBasic    = 0
+	SignatureModeStandard = 1
+	SignatureModeWallet   = 2
+	SignatureModeChild    = 3
+	SignatureModeLite     = 4
+)
+
+// Secret is a string that is redacted whenever it is printed or marshaled,
+// so a credential cannot end up in a log line by accident
+type Secret string
+
+const redacted = "[REDACTED]"
+
+func (s Secret) String() string   { return redacted }
+func (s Secret) GoString() string { return redacted }
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(redacted)
+}
+
+// Reveal returns the secret itself, for the one place that sends it
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+// Credential is what a node needs to sign for a DID
+type Credential struct {
+	Mode     int    `json:"mode"`
+	Password Secret `json:"password"`
+}
+
+// CredentialProvider looks up the signing credential of a DID. It returns
+// ErrNoCredential when it has none, so providers can be chained.
+type CredentialProvider interface {
+	Credential(did string) (*Credential, error)
+}
+
+// ErrNoCredential is returned by a provider that has no credential for a DID
+var ErrNoCredential = errors.New("no signing credential")
+
+// ErrNoCredentialSource is returned by LoadCredentialProvider when neither
+// the keystore nor the environment holds a credential, so every signature
+// would fail
+var ErrNoCredentialSource = errors.New("no signing credentials are configured: set RUBIX_SIGNATURE_PASSWORD or RUBIX_CREDENTIAL_<did>, or point SIGNING_KEYSTORE at a keystore filled with the keystore command")
+
+// ErrUnsupportedSignatureMode is returned for a DID whose signature mode the
+// server cannot sign with
+var ErrUnsupportedSignatureMode = errors.New("unsupported signature mode")
+
+var (
+	credentialsMu sync.RWMutex
+	credentials   CredentialProvider = EnvCredentials{}
+)
+
+// SetCredentialProvider replaces the provider Sign uses
+func SetCredentialProvider(provider CredentialProvider) {
+	credentialsMu.Lock()
+	credentials = provider
+	credentialsMu.Unlock()
+}
+
+// CredentialFor returns the signing credential of did from the configured
+// provider
+func CredentialFor(did string) (*Credential, error) {
+	credentialsMu.RLock()
+	provider := credentials
+	credentialsMu.RUnlock()
+
+	credential, err := provider.Credential(did)
+	if errors.Is(err, ErrNoCredential) {
+		return nil, fmt.Errorf("%w for DID %s", ErrNoCredential, did)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if credential.Mode == SignatureModeWallet {
+		return nil, fmt.Errorf("%w: DID %s uses wallet signature mode, which signs outside the node", ErrUnsupportedSignatureMode, did)
+	}
+	if credential.Mode < SignatureModeBasic || credential.Mode > SignatureModeLite {
+		return nil, fmt.Errorf("%w: DID %s has unknown signature mode %d", ErrUnsupportedSignatureMode, did, credential.Mode)
+	}
+	return credential, nil
+}
+
+// ChainCredentials asks each provider in turn
+type ChainCredentials []CredentialProvider
+
+func (c ChainCredentials) Credential(did string) (*Credential, error) {
+	for _, provider := range c {
+		credential, err := provider.Credential(did)
+		if errors.Is(err, ErrNoCredential) {
+			continue
+		}
+		return credential, err
+	}
+	return nil, ErrNoCredential
+}
+
+// EnvCredentials reads credentials from the environment:
+// RUBIX_CREDENTIAL_<did>="<mode>:<password>" for one DID, and
+// RUBIX_SIGNATURE_MODE / RUBIX_SIGNATURE_PASSWORD for every other DID.
+type EnvCredentials struct{}
+
+func (EnvCredentials) Credential(did string) (*Credential, error) {
+	if value, ok := os.LookupEnv("RUBIX_CREDENTIAL_" + did); ok {
+		modeText, password, found := strings.Cut(value, ":")
+		mode, err := strconv.Atoi(modeText)
+		if !found || err != nil {
+			return nil, fmt.Errorf("RUBIX_CREDENTIAL_%s must be <mode>:<password>", did)
+		}
+		return &Credential{Mode: mode, Password: Secret(password)}, nil
+	}
+
+	password, ok := os.LookupEnv("RUBIX_SIGNATURE_PASSWORD")
+	if !ok {
+		return nil, ErrNoCredential
+	}
+	mode := SignatureModeBasic
+	if modeText := os.Getenv("RUBIX_SIGNATURE_MODE"); modeText != "" {
+		var err error
+		if mode, err = strconv.Atoi(modeText); err != nil {
+			return nil, fmt.Errorf("invalid RUBIX_SIGNATURE_MODE %q", modeText)
+		}
+	}
+	return &Credential{Mode: mode, Password: Secret(password)}, nil
+}
+
+// Configured reports whether the environment holds any credential
+func (EnvCredentials) Configured() bool {
+	if _, ok := os.LookupEnv("RUBIX_SIGNATURE_PASSWORD"); ok {
+		return true
+	}
+	for _, entry := range os.Environ() {
+		if strings.HasPrefix(entry, "RUBIX_CREDENTIAL_") {
+			return true
+		}
+	}
+	return false
+}
+
+// keystoreFile is the on-disk form of a Keystore: the credentials map,
+// sealed with AES-256-GCM under a key derived from the passphrase by scrypt
+type keystoreFile struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+const (
+	keystoreVersion = 1
+	scryptN         = 1 << 15
+	scryptR         = 8
+	scryptP         = 1
+)
+
+// Keystore is an encrypted local file of per-DID credentials
+type Keystore struct {
+	path       string
+	passphrase Secret
+	entries    map[string]keystoreEntry
+}
+
+// keystoreEntry is a credential as sealed in the file, where the password
+// must survive marshaling
+type keystoreEntry struct {
+	Mode     int    `json:"mode"`
+	Password string `json:"password"`
+}
+
+// OpenKeystore decrypts the keystore at path. A missing file opens as an
+// empty keystore that Save will create.
+func OpenKeystore(path string, passphrase Secret) (*Keystore, error) {
+	keystore := &Keystore{path: path, passphrase: passphrase, entries: make(map[string]keystoreEntry)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return keystore, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keystore: %w", err)
+	}
+
+	var file keystoreFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse keystore: %w", err)
+	}
+	if file.Version != keystoreVersion {
+		return nil, fmt.Errorf("unsupported keystore version %d", file.Version)
+	}
+	aead, err := keystoreCipher(passphrase, file.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, file.Nonce, file.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt keystore: wrong passphrase or corrupt file")
+	}
+	if err := json.Unmarshal(plaintext, &keystore.entries); err != nil {
+		return nil, fmt.Errorf("failed to parse keystore contents: %w", err)
+	}
+	return keystore, nil
+}
+
+func keystoreCipher(passphrase Secret, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase.Reveal()), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive keystore key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (k *Keystore) Credential(did string) (*Credential, error) {
+	entry, ok := k.entries[did]
+	if !ok {
+		return nil, ErrNoCredential
+	}
+	return &Credential{Mode: entry.Mode, Password: Secret(entry.Password)}, nil
+}
+
+// Set adds or replaces the credential of a DID. Call Save to persist it.
+func (k *Keystore) Set(did string, credential Credential) {
+	k.entries[did] = keystoreEntry{Mode: credential.Mode, Password: credential.Password.Reveal()}
+}
+
+// Remove drops the credential of a DID and reports whether it had one
+func (k *Keystore) Remove(did string) bool {
+	_, ok := k.entries[did]
+	delete(k.entries, did)
+	return ok
+}
+
+// Modes returns the signature mode of every DID in the keystore
+func (k *Keystore) Modes() map[string]int {
+	modes := make(map[string]int, len(k.entries))
+	for did, entry := range k.entries {
+		modes[did] = entry.Mode
+	}
+	return modes
+}
+
+// Save re-encrypts the keystore with a fresh salt and nonce and writes it
+// readable by the owner only
+func (k *Keystore) Save() error {
+	plaintext, err := json.Marshal(k.entries)
+	if err != nil {
+		return fmt.Errorf("failed to encode keystore: %w", err)
+	}
+	file := keystoreFile{Version: keystoreVersion, Salt: make([]byte, 16)}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return fmt.Errorf("failed to generate keystore salt: %w", err)
+	}
+	aead, err := keystoreCipher(k.passphrase, file.Salt)
+	if err != nil {
+		return err
+	}
+	file.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return fmt.Errorf("failed to generate keystore nonce: %w", err)
+	}
+	file.Ciphertext = aead.Seal(nil, file.Nonce, plaintext, nil)
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode keystore: %w", err)
+	}
+	if err := os.WriteFile(k.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write keystore: %w", err)
+	}
+	return nil
+}
+
+// LoadCredentialProvider returns the provider the server signs with: the
+// keystore at keystorePath if one is configured, then the environment. It
+// returns ErrNoCredentialSource if neither has a credential.
+func LoadCredentialProvider(keystorePath string, passphrase Secret) (CredentialProvider, error) {
+	env := EnvCredentials{}
+	if keystorePath == "" {
+		if !env.Configured() {
+			return nil, ErrNoCredentialSource
+		}
+		return env, nil
+	}
+	if passphrase == "" {
+		return nil, fmt.Errorf("a keystore is configured but SIGNING_KEYSTORE_PASSPHRASE is not set")
+	}
+	keystore, err := OpenKeystore(keystorePath, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(keystore.entries) == 0 && !env.Configured() {
+		return nil, fmt.Errorf("%w (the keystore %s is empty)", ErrNoCredentialSource, keystorePath)
+	}
+	return ChainCredentials{keystore, env}, nil
+}
@@ -0,0 +1,119 @@
+package rubix_interaction
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// clearCredentialEnv unsets every credential variable for the test
+func clearCredentialEnv(t *testing.T) {
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		if name == "RUBIX_SIGNATURE_PASSWORD" || strings.HasPrefix(name, "RUBIX_CREDENTIAL_") {
+			t.Setenv(name, "")
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func TestKeystoreSealsItsCredentials(t *testing.T) {
+	const password = "keystore-password"
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	keystore, err := OpenKeystore(path, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keystore.Set("bafybmikeystoredid", Credential{Mode: SignatureModeStandard, Password: password})
+	if err := keystore.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := os.ReadFile(path); strings.Contains(string(raw), password) {
+		t.Fatal("keystore file holds the password in clear")
+	}
+	if _, err := OpenKeystore(path, "wrong"); err == nil {
+		t.Fatal("keystore opened with the wrong passphrase")
+	}
+
+	reopened, err := OpenKeystore(path, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, err := ChainCredentials{reopened, EnvCredentials{}}.Credential("bafybmikeystoredid")
+	if err != nil || credential.Mode != SignatureModeStandard || credential.Password.Reveal() != password {
+		t.Fatalf("credential = %+v, %v", credential, err)
+	}
+	if !reopened.Remove("bafybmikeystoredid") || reopened.Remove("bafybmikeystoredid") {
+		t.Fatal("removing a credential did not report whether it was stored")
+	}
+}
+
+func TestSecretsAreRedacted(t *testing.T) {
+	const password = "never-printed"
+	credential := &Credential{Mode: SignatureModeBasic, Password: password}
+	encoded, _ := json.Marshal(credential)
+	request := SignatureRequest{Id: "id", Mode: credential.Mode, Password: credential.Password.Reveal()}
+	for _, printed := range []string{
+		fmt.Sprintf("%v %+v %#v %s", credential, credential, credential, credential.Password),
+		fmt.Sprintf("%v %+v %#v", request, request, &request),
+		string(encoded),
+	} {
+		if strings.Contains(printed, password) {
+			t.Fatalf("secret leaked in %q", printed)
+		}
+	}
+}
+
+func TestCredentialForRefusesWalletMode(t *testing.T) {
+	clearCredentialEnv(t)
+	t.Setenv("RUBIX_CREDENTIAL_bafybmiwalletdid", "2:secret")
+	t.Setenv("RUBIX_CREDENTIAL_bafybmibrokendid", "secret")
+	SetCredentialProvider(EnvCredentials{})
+
+	if _, err := CredentialFor("bafybmiwalletdid"); !errors.Is(err, ErrUnsupportedSignatureMode) {
+		t.Fatalf("wallet signature mode = %v", err)
+	}
+	if _, err := CredentialFor("bafybmibrokendid"); err == nil {
+		t.Fatal("a credential without a mode was accepted")
+	}
+	if _, err := CredentialFor("bafybmiunknowndid"); !errors.Is(err, ErrNoCredential) {
+		t.Fatalf("DID without a credential = %v", err)
+	}
+}
+
+func TestLoadCredentialProviderNeedsACredentialSource(t *testing.T) {
+	clearCredentialEnv(t)
+	keystorePath := filepath.Join(t.TempDir(), "keystore.json")
+
+	if _, err := LoadCredentialProvider("", ""); !errors.Is(err, ErrNoCredentialSource) {
+		t.Fatalf("no keystore and no environment = %v", err)
+	}
+	if _, err := LoadCredentialProvider(keystorePath, "passphrase"); !errors.Is(err, ErrNoCredentialSource) {
+		t.Fatalf("empty keystore and no environment = %v", err)
+	}
+
+	keystore, err := OpenKeystore(keystorePath, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keystore.Set("bafybmikeystoredid", Credential{Mode: SignatureModeBasic, Password: "secret"})
+	if err := keystore.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCredentialProvider(keystorePath, "passphrase"); err != nil {
+		t.Fatalf("filled keystore = %v", err)
+	}
+
+	t.Setenv("RUBIX_CREDENTIAL_bafybmienvdid", "0:secret")
+	provider, err := LoadCredentialProvider("", "")
+	if err != nil {
+		t.Fatalf("per-DID credential in the environment = %v", err)
+	}
+	if _, err := provider.Credential("bafybmiotherdid"); !errors.Is(err, ErrNoCredential) {
+		t.Fatalf("credential of a DID without one = %v", err)
+	}
+}
@@ -1,15 +1,9 @@
 package rubix_interaction
 
 import (
-	"bytes"
+	"context"
 	// "dapp-server/config"
-	"encoding/json"
 	"fmt"
-	"io"
-
-	// "mime/multipart"
-	"net/http"
-	"net/url"
 	// "github.com/rubixchain/rubix-nexus/config"
 )
 
@@ -22,16 +16,6 @@ type createDidResponse struct {
 	} `json:"result"`
 }
 
-type registerDidResponse struct {
-	Status  bool   `json:"status"`
-	Message string `json:"message"`
-	Result  struct {
-		Id          string `json:"id"`
-		Mode        int    `json:"mode"`
-		OnlyPrivKey bool   `json:"only_priv_key"`
-	}
-}
-
 // func CreateDID(homeDir string, isLocalnet bool) (string, error) {
 // 	cfg, err := config.LoadConfig(homeDir)
 // 	if err != nil {
@@ -121,61 +105,16 @@ type registerDidResponse struct {
 // 	return response.Result.DID, nil
 // }
 
-func registerDID(baseURL string, did string) error {
-	requestURL, err := url.JoinPath(baseURL, "/api/register-did")
-	if err != nil {
-		return fmt.Errorf("failed to join URL: %v", err)
-	}
-
-	requestBody := map[string]interface{}{
-		"did": did,
-	}
-
-	requestBodyBytes, err := json.Marshal(requestBody)
-	if err != nil {
-		return fmt.Errorf("failed to marshal JSON: %v", err)
-	}
-
-	req, err := http.NewRequest("POST", requestURL, bytes.NewBuffer(requestBodyBytes))
+// RegisterDID registers did with the network through the node behind client
+func RegisterDID(ctx context.Context, client NodeClient, did string) error {
+	requestId, err := client.RegisterDID(ctx, &RegisterDIDRequest{DID: did})
 	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
+		return fmt.Errorf("failed to Register DID: %w", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to perform request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	responseBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response body: %v", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d, response: %s", resp.StatusCode, string(responseBody))
-	}
-
-	var registerDidResp registerDidResponse
-	if err = json.Unmarshal(responseBody, &registerDidResp); err != nil {
-		return fmt.Errorf("registerDID: failed to unmarshal JSON: %v", err)
-	}
-
-	if !registerDidResp.Status {
-		return fmt.Errorf("failed to Register DID, error: %s", registerDidResp.Message)
-	}
-
-	requestId := registerDidResp.Result.Id
-	_, err = SignatureResponse(baseURL, requestId)
-	if err != nil {
+	if _, err = Sign(ctx, client, did, requestId); err != nil {
 		return fmt.Errorf("failed to send signature response: %v", err)
 	}
-	// if err = SignatureResponse(baseURL, requestId); err != nil {
-	// 	return fmt.Errorf("failed to send signature response: %v", err)
-	// }
 
 	return nil
 }
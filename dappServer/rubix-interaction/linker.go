@@ -1,10 +1,7 @@
 package rubix_interaction
 
 import (
-	"dapp-server/config"
-	"encoding/json"
 	"fmt"
-	"os"
 
 	"github.com/bytecodealliance/wasmtime-go"
 	wasmContext "github.com/rubixchain/rubix-wasm/go-wasm-bridge/context"
@@ -18,17 +15,20 @@ type Activity struct {
 	RewardPoints int    `json:"reward_points"`
 }
 
-type AddAdmin struct {
-	AdminDID string `json:"admin_did"`
-}
-
+// WriteToJsonFile is the "write_to_json_file" host function contracts call
+// with each record they accept. Despite the name, which deployed contracts
+// import and so cannot change, records are written to the database by the
+// record kinds declared for the contract being run.
 type WriteToJsonFile struct {
 	allocFunc *wasmtime.Func
 	memory    *wasmtime.Memory
+	kinds     []RecordKind
 }
 
-func NewWriteToJsonFile() *WriteToJsonFile {
-	return &WriteToJsonFile{}
+// NewWriteToJsonFile returns the host function for a contract that writes
+// the given record kinds
+func NewWriteToJsonFile(kinds ...RecordKind) *WriteToJsonFile {
+	return &WriteToJsonFile{kinds: kinds}
 }
 
 func (h *WriteToJsonFile) Name() string {
@@ -40,8 +40,8 @@ func (h *WriteToJsonFile) FuncType() *wasmtime.FuncType {
 		[]*wasmtime.ValType{
 			wasmtime.NewValType(wasmtime.KindI32), // data_ptr
 			wasmtime.NewValType(wasmtime.KindI32), // data_len
-			wasmtime.NewValType(wasmtime.KindI32), // file_path_ptr
-			wasmtime.NewValType(wasmtime.KindI32), // file_path_len
+			wasmtime.NewValType(wasmtime.KindI32), // file_path_ptr (unused)
+			wasmtime.NewValType(wasmtime.KindI32), // file_path_len (unused)
 		},
 		[]*wasmtime.ValType{wasmtime.NewValType(wasmtime.KindI32)}, // return i32
 	)
@@ -52,6 +52,11 @@ func (h *WriteToJsonFile) Initialize(allocFunc, deallocFunc *wasmtime.Func, memo
 	h.memory = memory
 }
 
+// Store persists a record the contract wrote as one of its record kinds
+func (h *WriteToJsonFile) Store(data []byte) error {
+	return StoreRecord(h.kinds, data)
+}
+
 func (h *WriteToJsonFile) Callback() host.HostFunctionCallBack {
 	return h.callback
 }
@@ -63,106 +68,24 @@ func (h *WriteToJsonFile) callback(
 	// Extract input arguments
 	inputArgs, outputArgs := utils.HostFunctionParamExtraction(args, true, true)
 
-	// Extract data and file path from WASM memory
-	dataBytes, memory, err := utils.ExtractDataFromWASM(caller, inputArgs) // Extract data
+	// Extract the record from WASM memory
+	dataBytes, memory, err := utils.ExtractDataFromWASM(caller, inputArgs)
 	if err != nil {
 		fmt.Println("Failed to extract data from WASM", err)
 		return utils.HandleError(err.Error())
 	}
-
-	// filePathBytes, _, err := utils.ExtractDataFromWASM(caller, inputArgs) // Extract file path
-	// if err != nil {
-	// 	fmt.Println("Failed to extract file path from WASM", err)
-	// 	return utils.HandleError(err.Error())
-	// }
 	h.memory = memory
 
-	// data := string(dataBytes)
-	// filePath := string(filePathBytes)
-
-	// Parse the data into JSON (if necessary) and write it to a file
-	var rawData map[string]interface{}
-	if err := json.Unmarshal(dataBytes, &rawData); err != nil {
-		fmt.Printf("Failed to parse incoming JSON: %v\n", err)
-		return utils.HandleError(err.Error())
-	}
-
-	var jsonData interface{}
-	var filePath string
-
-	// Step 2: Dynamically identify the type and determine the file path
-	if _, ok := rawData["activity_id"]; ok {
-		var activity Activity
-		if err := json.Unmarshal(dataBytes, &activity); err != nil {
-			fmt.Printf("Failed to unmarshal as Activity: %v\n", err)
-			return utils.HandleError(err.Error())
-		}
-		jsonData = activity
-		filePath = config.GetEnvConfig().ActivityUpdatePath // File for Activity data
-	} else if _, ok := rawData["admin_did"]; ok {
-		var addAdmin AddAdmin
-		if err := json.Unmarshal(dataBytes, &addAdmin); err != nil {
-			fmt.Printf("Failed to unmarshal as AddAdmin: %v\n", err)
-			return utils.HandleError(err.Error())
-		}
-		jsonData = addAdmin
-		fmt.Println("The AddAdmin data is :", addAdmin)
-		fmt.Println("The jsonData data is :", jsonData)
-		filePath = config.GetEnvConfig().AdminUpdatePath // File for AddAdmin data
-		fmt.Println("The file path is :", filePath)
-	} else {
-		fmt.Println("Unknown data structure")
-		return utils.HandleError(err.Error())
-	}
-
-	// var jsonData interface{}
-	if err := json.Unmarshal(dataBytes, &jsonData); err != nil {
-		fmt.Printf("Failed to parse JSON data: %v\n", err)
-		return utils.HandleError("Invalid JSON data")
-	}
-
-	// filePath := "C:/Users/allen/Working-repo/ymca/ymca-wellness-cafe-project/dappServer/test.json"
-	// filePath := config.GetEnvConfig().ActivityUpdatePath
-	// Step 1: Read the existing file content
-	existingContent, err := os.ReadFile(filePath)
-	if err != nil && !os.IsNotExist(err) { // Ignore error if file doesn't exist
-		fmt.Printf("Failed to read existing file: %v\n", err)
+	if err := h.Store(dataBytes); err != nil {
+		fmt.Printf("Failed to store contract record: %v\n", err)
 		return utils.HandleError(err.Error())
 	}
 
-	var existingData []interface{}
-	if len(existingContent) > 0 {
-		if err := json.Unmarshal(existingContent, &existingData); err != nil {
-			fmt.Printf("Failed to parse existing JSON data: %v\n", err)
-			return utils.HandleError("Invalid existing JSON data")
-		}
-	} else {
-		existingData = []interface{}{}
-	}
-
-	// Step 2: Append new data to existing data
-	existingData = append(existingData, jsonData)
-
-	// Step 3: Write the updated data back to the file
-	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		panic(err) // Handle the error appropriately in production code
-	}
-	defer file.Close()
-
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ") // Pretty-print JSON
-	if err := encoder.Encode(existingData); err != nil {
-		fmt.Printf("Failed to write JSON data to file: %v\n", err)
-		return utils.HandleError(err.Error())
-	}
-	response := fmt.Sprintf("Succesfully wrote data to DB")
+	response := "Succesfully wrote data to DB"
 	err = utils.UpdateDataToWASM(caller, h.allocFunc, response, outputArgs)
 	if err != nil {
 		fmt.Println("Failed to update data to WASM", err)
 		return utils.HandleError(err.Error())
 	}
-
-	fmt.Printf("Successfully wrote data to JSON file: %s\n", filePath)
 	return utils.HandleOk() // Return success
 }
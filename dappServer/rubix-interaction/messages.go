@@ -0,0 +1,91 @@
+package rubix_interaction
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ContractRequest is the input of a contract function. A contract message is
+// the request keyed by the function's name, e.g. {"add_activity": {...}}.
+type ContractRequest interface {
+	ContractFunction() string
+}
+
+// ContractMessage marshals req into the message that calls its contract
+// function
+func ContractMessage(req ContractRequest) (string, error) {
+	message, err := json.Marshal(map[string]ContractRequest{req.ContractFunction(): req})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal %s message: %w", req.ContractFunction(), err)
+	}
+	return string(message), nil
+}
+
+// AddActivityReq mirrors AddActivityReq of the activity contract. BlockHash is
+// left out of the message that records an activity on chain; the callback
+// sets it to the block that carried the message before running the contract.
+type AddActivityReq struct {
+	ActivityID   string `json:"activity_id"`
+	RewardPoints uint32 `json:"reward_points"`
+	BlockHash    string `json:"block_hash,omitempty"`
+}
+
+func (AddActivityReq) ContractFunction() string { return "add_activity" }
+
+// AddAdminReq mirrors AddAdminReq of the add_admin contract, which also
+// writes it as its record. The callback fills in the block that carried the
+// change and its executor.
+type AddAdminReq struct {
+	AdminDID string `json:"admin_did"`
+	BlockID  string `json:"block_id,omitempty"`
+	ActorDID string `json:"actor_did,omitempty"`
+}
+
+func (AddAdminReq) ContractFunction() string { return "add_admin" }
+
+// RemoveAdminReq mirrors RemoveAdminReq of the add_admin contract
+type RemoveAdminReq struct {
+	AdminDID string `json:"admin_did"`
+	BlockID  string `json:"block_id,omitempty"`
+	ActorDID string `json:"actor_did,omitempty"`
+}
+
+func (RemoveAdminReq) ContractFunction() string { return "remove_admin" }
+
+// TransferSampleFTReq mirrors TransferSampleFTReq of the FT contract. Name
+// must be on the contract's whitelist.
+type TransferSampleFTReq struct {
+	Name   string     `json:"name"`
+	FTInfo TransferFT `json:"ft_info"`
+}
+
+func (TransferSampleFTReq) ContractFunction() string { return "transfer_sample_ft" }
+
+// TransferFT mirrors rubixwasm_std's TransferFt, which the contract passes on
+// to the do_transfer_ft host function. The quorum type defaults to the node's.
+type TransferFT struct {
+	Comment    string `json:"comment"`
+	FTCount    int32  `json:"ft_count"`
+	FTName     string `json:"ft_name"`
+	Sender     string `json:"sender"`
+	CreatorDID string `json:"creatorDID"`
+	Receiver   string `json:"receiver"`
+	QuorumType int32  `json:"quorum_type,omitempty"`
+}
+
+// MintSampleFTReq mirrors MintSampleFTReq of the FT contract
+type MintSampleFTReq struct {
+	Name   string `json:"name"`
+	FTInfo MintFT `json:"ft_info"`
+}
+
+func (MintSampleFTReq) ContractFunction() string { return "mint_sample_ft" }
+
+// MintFT mirrors rubixwasm_std's MintFt, which the contract passes on to the
+// do_mint_ft host function
+type MintFT struct {
+	DID        string `json:"did"`
+	FTCount    int32  `json:"ft_count"`
+	FTName     string `json:"ft_name"`
+	TokenCount int32  `json:"token_count"`
+}
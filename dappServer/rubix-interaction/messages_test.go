@@ -0,0 +1,143 @@
+package rubix_interaction
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden contract messages")
+
+// messageCases are the contract messages the server sends, each with the
+// Rust source declaring the request struct it must match
+var messageCases = []struct {
+	request    ContractRequest
+	rustSource string
+	rustStruct string
+}{
+	{
+		request:    AddActivityReq{ActivityID: `yoga "flow" 101`, RewardPoints: 25, BlockHash: "1-d546bc72"},
+		rustSource: "../../activity_contract/src/lib.rs",
+		rustStruct: "AddActivityReq",
+	},
+	{
+		request:    AddAdminReq{AdminDID: "bafybmiadmin", BlockID: "2-9f0c11aa", ActorDID: "bafybmiactor"},
+		rustSource: "../../add_admin_contract/src/lib.rs",
+		rustStruct: "AddAdminReq",
+	},
+	{
+		request:    RemoveAdminReq{AdminDID: "bafybmiadmin"},
+		rustSource: "../../add_admin_contract/src/lib.rs",
+		rustStruct: "RemoveAdminReq",
+	},
+	{
+		request: TransferSampleFTReq{Name: "rubix1", FTInfo: TransferFT{
+			Comment:    "reward:tr_0123",
+			FTCount:    3,
+			FTName:     "ytoken",
+			Sender:     "bafybmiadmin",
+			CreatorDID: "bafybmiadmin",
+			Receiver:   "bafybmiuser",
+		}},
+		rustSource: "../../first-contract/src/lib.rs",
+		rustStruct: "TransferSampleFTReq",
+	},
+	{
+		request: MintSampleFTReq{Name: "rubix1", FTInfo: MintFT{
+			DID:        "bafybmiadmin",
+			FTCount:    100,
+			FTName:     "ytoken",
+			TokenCount: 1,
+		}},
+		rustSource: "../../first-contract/src/lib.rs",
+		rustStruct: "MintSampleFTReq",
+	},
+}
+
+func TestContractMessagesMatchGoldenFiles(t *testing.T) {
+	for _, tc := range messageCases {
+		function := tc.request.ContractFunction()
+		t.Run(function, func(t *testing.T) {
+			message, err := ContractMessage(tc.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", function+".json")
+			if *update {
+				if err := os.WriteFile(golden, []byte(message+"\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if message != strings.TrimSpace(string(want)) {
+				t.Fatalf("message = %s\nwant      %s", message, want)
+			}
+
+			// The golden message decodes back into the request, field for field
+			var envelope map[string]json.RawMessage
+			if err := json.Unmarshal(want, &envelope); err != nil {
+				t.Fatal(err)
+			}
+			if len(envelope) != 1 || envelope[function] == nil {
+				t.Fatalf("message is not keyed by %s alone: %s", function, want)
+			}
+			decoded := reflect.New(reflect.TypeOf(tc.request))
+			decoder := json.NewDecoder(bytes.NewReader(envelope[function]))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(decoded.Interface()); err != nil {
+				t.Fatal(err)
+			}
+			if got := decoded.Elem().Interface(); !reflect.DeepEqual(got, tc.request) {
+				t.Fatalf("round trip = %+v, want %+v", got, tc.request)
+			}
+		})
+	}
+}
+
+func TestContractMessagesMatchRustStructs(t *testing.T) {
+	for _, tc := range messageCases {
+		t.Run(tc.rustStruct, func(t *testing.T) {
+			source, err := os.ReadFile(tc.rustSource)
+			if os.IsNotExist(err) {
+				t.Skipf("contract sources are not checked out next to the server: %v", err)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			body := regexp.MustCompile(`pub struct ` + tc.rustStruct + `\s*\{([^}]*)\}`).FindSubmatch(source)
+			if body == nil {
+				t.Fatalf("%s does not declare %s", tc.rustSource, tc.rustStruct)
+			}
+			var rustFields []string
+			for _, field := range regexp.MustCompile(`pub (\w+):`).FindAllSubmatch(body[1], -1) {
+				rustFields = append(rustFields, string(field[1]))
+			}
+			sort.Strings(rustFields)
+
+			if goFields := jsonFields(reflect.TypeOf(tc.request)); !reflect.DeepEqual(goFields, rustFields) {
+				t.Fatalf("Go fields %v, Rust fields %v", goFields, rustFields)
+			}
+		})
+	}
+}
+
+// jsonFields returns the sorted JSON names of a struct's fields
+func jsonFields(typ reflect.Type) []string {
+	var fields []string
+	for i := 0; i < typ.NumField(); i++ {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}
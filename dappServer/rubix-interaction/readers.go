@@ -0,0 +1,101 @@
+package rubix_interaction
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/bytecodealliance/wasmtime-go"
+	wasmContext "github.com/rubixchain/rubix-wasm/go-wasm-bridge/context"
+	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/host"
+	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/utils"
+)
+
+// StateReader is a read-only host function that lets a contract query the
+// server state, so validation can live in the contract. The contract passes
+// a JSON query and gets a JSON answer back through the same four arguments
+// as do_api_call: input_ptr, input_len, resp_ptr_ptr, resp_len_ptr.
+type StateReader struct {
+	name      string
+	read      func(query []byte) (interface{}, error)
+	allocFunc *wasmtime.Func
+	memory    *wasmtime.Memory
+}
+
+// NewStateReader returns a reader named name whose queries decode strictly
+// into Q and are answered by read
+func NewStateReader[Q any](name string, read func(query Q) (interface{}, error)) *StateReader {
+	return &StateReader{
+		name: name,
+		read: func(data []byte) (interface{}, error) {
+			var query Q
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&query); err != nil {
+				return nil, fmt.Errorf("invalid %s query: %w", name, err)
+			}
+			return read(query)
+		},
+	}
+}
+
+// Query answers a JSON query the way the host function would
+func (h *StateReader) Query(query []byte) ([]byte, error) {
+	result, err := h.read(query)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(result)
+}
+
+func (h *StateReader) Name() string {
+	return h.name
+}
+
+func (h *StateReader) FuncType() *wasmtime.FuncType {
+	return wasmtime.NewFuncType(
+		[]*wasmtime.ValType{
+			wasmtime.NewValType(wasmtime.KindI32), // input_ptr
+			wasmtime.NewValType(wasmtime.KindI32), // input_len
+			wasmtime.NewValType(wasmtime.KindI32), // resp_ptr_ptr
+			wasmtime.NewValType(wasmtime.KindI32), // resp_len_ptr
+		},
+		[]*wasmtime.ValType{wasmtime.NewValType(wasmtime.KindI32)}, // return i32
+	)
+}
+
+func (h *StateReader) Initialize(allocFunc, deallocFunc *wasmtime.Func, memory *wasmtime.Memory, nodeAddress string, quorumType int, wasmCtx *wasmContext.WasmContext) {
+	h.allocFunc = allocFunc
+	h.memory = memory
+}
+
+func (h *StateReader) Callback() host.HostFunctionCallBack {
+	return h.callback
+}
+
+func (h *StateReader) callback(
+	caller *wasmtime.Caller,
+	args []wasmtime.Val,
+) ([]wasmtime.Val, *wasmtime.Trap) {
+	inputArgs, outputArgs := utils.HostFunctionParamExtraction(args, true, true)
+
+	queryBytes, memory, err := utils.ExtractDataFromWASM(caller, inputArgs)
+	if err != nil {
+		fmt.Println("Failed to extract data from WASM", err)
+		return utils.HandleError(err.Error())
+	}
+	h.memory = memory
+
+	response, err := h.Query(queryBytes)
+	if err != nil {
+		fmt.Printf("%s failed: %v\n", h.name, err)
+		return utils.HandleError(err.Error())
+	}
+
+	err = utils.UpdateDataToWASM(caller, h.allocFunc, string(response), outputArgs)
+	if err != nil {
+		fmt.Println("Failed to update data to WASM", err)
+		return utils.HandleError(err.Error())
+	}
+	return utils.HandleOk()
+}
@@ -0,0 +1,75 @@
+package rubix_interaction
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// RecordKind is a typed record a contract can persist through the
+// write_to_json_file host function, together with the store it goes to.
+// The store is the caller's: this package does not touch the database.
+// Each handler that runs a contract declares the kinds that contract may
+// write when it registers the host function, so a new contract only needs
+// a new RecordKind, not changes to the host function.
+type RecordKind struct {
+	Name string
+	// Store decodes, validates and persists one record
+	Store func(data []byte) error
+}
+
+// NewRecordKind returns a RecordKind whose records decode strictly into T
+// and are persisted by store
+func NewRecordKind[T any](name string, store func(record T) error) RecordKind {
+	return RecordKind{
+		Name: name,
+		Store: func(data []byte) error {
+			var record T
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&record); err != nil {
+				return fmt.Errorf("invalid %s record: %w", name, err)
+			}
+			return store(record)
+		},
+	}
+}
+
+// recordEnvelope lets a contract that declares several kinds say which one
+// it is writing: {"kind": "activity", "record": {...}}
+type recordEnvelope struct {
+	Kind   string          `json:"kind"`
+	Record json.RawMessage `json:"record"`
+}
+
+// StoreRecord persists data as one of the declared kinds. The data is
+// either an envelope naming its kind or a bare record, which is stored as
+// the first declared kind: contracts built before envelopes, such as the
+// deployed add_admin contract, write only bare records of that kind.
+func StoreRecord(kinds []RecordKind, data []byte) error {
+	var envelope recordEnvelope
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&envelope); err == nil && envelope.Kind != "" {
+		for _, kind := range kinds {
+			if kind.Name == envelope.Kind {
+				return kind.Store(envelope.Record)
+			}
+		}
+		return fmt.Errorf("record kind %q is not declared for this contract (declared: %s)", envelope.Kind, recordKindNames(kinds))
+	}
+
+	if len(kinds) == 0 {
+		return fmt.Errorf("no record kinds are declared for this contract")
+	}
+	return kinds[0].Store(data)
+}
+
+func recordKindNames(kinds []RecordKind) string {
+	names := make([]string, 0, len(kinds))
+	for _, kind := range kinds {
+		names = append(names, kind.Name)
+	}
+	return strings.Join(names, ", ")
+}
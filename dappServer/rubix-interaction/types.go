@@ -1,10 +1,14 @@
 package rubix_interaction
 
+import "fmt"
+
 // DeploymentResult represents the result of a contract deployment
 type DeploymentResult struct {
-	ContractHash string
-	Success      bool
-	Message      string
+	ContractHash    string
+	Success         bool
+	Message         string
+	DeploymentBlock string
+	Callbacks       []CallbackResult
 }
 
 // DeploymentStage represents a stage in the deployment process
@@ -21,8 +25,8 @@ type StageCallback func(stage DeploymentStage)
 
 // ExecutionResult represents the result of a contract execution
 type ExecutionResult struct {
-	Success bool
-	Message string
+	Success        bool
+	Message        string
 	ContractResult string
 }
 
@@ -40,15 +44,96 @@ type SmartContractAPIResponseV1 struct {
 	Result  string `json:"result"`
 }
 
-// SmartContractAPIResponseV1 represents the standard API response structure
+// SmartContractAPIResponseV2 represents the API response structure for calls
+// that hand back a signature request
 type SmartContractAPIResponseV2 struct {
 	Status  bool                `json:"status"`
 	Message string              `json:"message"`
 	Result  SmartContractResult `json:"result"`
 }
 
+// SmartContractBlock is a single block of a smart contract token chain
 type SmartContractBlock struct {
-	BlockNo           string `json:"BlockNo"`
-	BlockId           string `json:"BlockId"`
-	SmartContractData string `json:"SmartContractData"`
+	BlockNo            uint64 `json:"BlockNo"`
+	BlockId            string `json:"BlockId"`
+	SmartContractData  string `json:"SmartContractData"`
+	Epoch              uint64 `json:"Epoch"`
+	InitiatorSignature string `json:"InitiatorSignature"`
+	ExecutorDID        string `json:"ExecutorDID"`
+	InitiatorSignData  string `json:"InitiatorSignData"`
+}
+
+// GenerateSmartContractRequest carries the artifacts for /api/generate-smart-contract
+type GenerateSmartContractRequest struct {
+	DID       string
+	WasmPath  string
+	LibPath   string
+	StatePath string
+}
+
+// DeploySmartContractRequest is the body of /api/deploy-smart-contract
+type DeploySmartContractRequest struct {
+	Comment            string  `json:"comment"`
+	DeployerAddr       string  `json:"deployerAddr"`
+	QuorumType         int     `json:"quorumType"`
+	RbtAmount          float64 `json:"rbtAmount"`
+	SmartContractToken string  `json:"smartContractToken"`
+}
+
+// ExecuteSmartContractRequest is the body of /api/execute-smart-contract
+type ExecuteSmartContractRequest struct {
+	Comment            string `json:"comment"`
+	ExecutorAddr       string `json:"executorAddr"`
+	QuorumType         int    `json:"quorumType"`
+	SmartContractData  string `json:"smartContractData"`
+	SmartContractToken string `json:"smartContractToken"`
+}
+
+// SignatureRequest is the body of /api/signature-response
+type SignatureRequest struct {
+	Id       string `json:"id"`
+	Mode     int    `json:"mode"`
+	Password string `json:"password"`
+}
+
+// String keeps the password out of anything that prints the request
+func (r SignatureRequest) String() string {
+	return fmt.Sprintf("{Id:%s Mode:%d Password:%s}", r.Id, r.Mode, redacted)
+}
+
+func (r SignatureRequest) GoString() string {
+	return r.String()
+}
+
+// TokenChainDataRequest is the body of /api/get-smart-contract-token-chain-data
+type TokenChainDataRequest struct {
+	Token  string `json:"token"`
+	Latest bool   `json:"latest"`
+}
+
+// TokenChainDataResponse is the reply of /api/get-smart-contract-token-chain-data
+type TokenChainDataResponse struct {
+	Status       bool                 `json:"status"`
+	Message      string               `json:"message"`
+	Result       interface{}          `json:"result"`
+	SCTDataReply []SmartContractBlock `json:"SCTDataReply"`
+}
+
+// LatestBlock returns the last block of the reply, or nil if there is none
+func (r *TokenChainDataResponse) LatestBlock() *SmartContractBlock {
+	if len(r.SCTDataReply) == 0 {
+		return nil
+	}
+	return &r.SCTDataReply[len(r.SCTDataReply)-1]
+}
+
+// RegisterCallbackRequest is the body of /api/register-callback-url
+type RegisterCallbackRequest struct {
+	CallBackURL        string `json:"CallBackURL"`
+	SmartContractToken string `json:"SmartContractToken"`
+}
+
+// RegisterDIDRequest is the body of /api/register-did
+type RegisterDIDRequest struct {
+	DID string `json:"did"`
 }
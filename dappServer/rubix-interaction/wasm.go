@@ -0,0 +1,68 @@
+package rubix_interaction
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bytecodealliance/wasmtime-go"
+)
+
+// ContractABIFunctions are the functions every contract built with
+// rubixwasm_std exports besides its memory: the node passes inputs through
+// memory it allocates with alloc and frees with dealloc
+var ContractABIFunctions = []string{"alloc", "dealloc"}
+
+// ContractFunctionExport is the name a #[contract_fn] function is exported
+// under, e.g. add_activity_ for add_activity
+func ContractFunctionExport(function string) string {
+	return function + "_"
+}
+
+// ValidateContractWasm checks that wasm is a module the node can run as a
+// contract: it must parse, export the contract ABI and export each of
+// functions as a contract function. With no functions named, it must export
+// at least one.
+func ValidateContractWasm(wasm []byte, functions []string) error {
+	module, err := wasmtime.NewModule(wasmtime.NewEngine(), wasm)
+	if err != nil {
+		return fmt.Errorf("not a valid wasm module: %w", err)
+	}
+	exported := map[string]bool{}
+	var memory bool
+	var contractFunctions int
+	for _, export := range module.Exports() {
+		switch {
+		case export.Type().FuncType() != nil:
+			exported[export.Name()] = true
+			if strings.HasSuffix(export.Name(), "_") {
+				contractFunctions++
+			}
+		case export.Type().MemoryType() != nil && export.Name() == "memory":
+			memory = true
+		}
+	}
+
+	var missing []string
+	if !memory {
+		missing = append(missing, "memory")
+	}
+	for _, name := range ContractABIFunctions {
+		if !exported[name] {
+			missing = append(missing, name)
+		}
+	}
+	for _, function := range functions {
+		if !exported[ContractFunctionExport(function)] {
+			missing = append(missing, ContractFunctionExport(function))
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("wasm module does not export %s", strings.Join(missing, ", "))
+	}
+	if len(functions) == 0 && contractFunctions == 0 {
+		return fmt.Errorf("wasm module exports no contract functions")
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+package server
+
+import (
+	"context"
+	"dapp-server/config"
+	"dapp-server/database"
+	rubix_interaction "dapp-server/rubix-interaction"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultActivityPageSize = 20
+	maxActivityPageSize     = 100
+	// activityChainTTL is how long add_activity chain metadata is reused
+	// before the token chain is fetched again
+	activityChainTTL = 30 * time.Second
+	// activityChainMinRefresh throttles refetches triggered by activities
+	// whose block is not in the cached chain yet
+	activityChainMinRefresh = 2 * time.Second
+)
+
+// CatalogActivity is an activity as listed to members: its registered reward
+// together with who registered it and when, taken from its anchoring block
+type CatalogActivity struct {
+	ActivityID   string      `json:"activity_id"`
+	RewardPoints int         `json:"reward_points"`
+	ClaimPolicy  ClaimPolicy `json:"claim_policy"`
+	BlockHash    string      `json:"block_hash"`
+	AdminDID     string      `json:"admin_did"`
+	CreatedAt    time.Time   `json:"created_at"`
+}
+
+// activityChain caches the add_activity contract's blocks by block ID
+type activityChain struct {
+	mu        sync.Mutex
+	fetchedAt time.Time
+	blocks    map[string]rubix_interaction.SmartContractBlock
+	// refreshing is closed when the fetch in progress ends; nil when none is
+	refreshing chan struct{}
+}
+
+var addActivityChain = &activityChain{}
+
+// blocksFor returns the cached blocks, refetching them when the cache is
+// stale or is missing one of the wanted block IDs. The lock is not held
+// while the nodes are asked: one caller fetches, and the others meanwhile
+// get the cached blocks, or wait for the fetch if nothing is cached yet.
+func (a *activityChain) blocksFor(ctx context.Context, wanted []string) map[string]rubix_interaction.SmartContractBlock {
+	a.mu.Lock()
+	age := time.Since(a.fetchedAt)
+	stale := a.blocks == nil || age > activityChainTTL
+	if !stale && age > activityChainMinRefresh {
+		for _, blockID := range wanted {
+			if _, ok := a.blocks[blockID]; !ok {
+				stale = true
+				break
+			}
+		}
+	}
+	if !stale {
+		defer a.mu.Unlock()
+		return a.blocks
+	}
+	if refreshing := a.refreshing; refreshing != nil {
+		if a.blocks != nil {
+			defer a.mu.Unlock()
+			return a.blocks
+		}
+		a.mu.Unlock()
+		select {
+		case <-refreshing:
+		case <-ctx.Done():
+		}
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return a.blocks
+	}
+	refreshing := make(chan struct{})
+	a.refreshing = refreshing
+	a.mu.Unlock()
+
+	blocks, err := fetchActivityChain(ctx)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.refreshing = nil
+	close(refreshing)
+	if err != nil {
+		// Serve what we have; the catalog is still usable without metadata
+		fmt.Println("Unable to fetch add_activity token chain:", err)
+		return a.blocks
+	}
+	a.blocks = blocks
+	a.fetchedAt = time.Now()
+	return a.blocks
+}
+
+// fetchActivityChain reads the add_activity token chain from the first
+// configured node that answers
+func fetchActivityChain(ctx context.Context) (map[string]rubix_interaction.SmartContractBlock, error) {
+	contractHash, err := registeredContractHash(database.ContractRoleActivity)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range cfg.Nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lastErr := fmt.Errorf("no nodes configured")
+	for _, name := range names {
+		client := newNodeClient(cfg.Nodes[name].Port)
+		dataReply, err := client.GetSmartContractTokenChainData(ctx, &rubix_interaction.TokenChainDataRequest{
+			Token:  contractHash,
+			Latest: false,
+		})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		blocks := make(map[string]rubix_interaction.SmartContractBlock, len(dataReply.SCTDataReply))
+		for _, block := range dataReply.SCTDataReply {
+			blocks[block.BlockId] = block
+		}
+		return blocks, nil
+	}
+	return nil, lastErr
+}
+
+// loadCatalog returns every registered activity with its chain metadata
+func loadCatalog(ctx context.Context) ([]CatalogActivity, error) {
+	activities, err := database.ListActivities()
+	if err != nil {
+		return nil, err
+	}
+	wanted := make([]string, 0, len(activities))
+	for _, activity := range activities {
+		wanted = append(wanted, activity.BlockHash)
+	}
+	blocks := addActivityChain.blocksFor(ctx, wanted)
+
+	catalog := make([]CatalogActivity, 0, len(activities))
+	for _, activity := range activities {
+		entry := CatalogActivity{
+			ActivityID:   activity.ActivityID,
+			RewardPoints: activity.RewardPoints,
+			ClaimPolicy:  ClaimPolicyFor(activity.ActivityID),
+			BlockHash:    activity.BlockHash,
+		}
+		// The block is the authoritative record of when the activity was
+		// registered; the row's own timestamp stands in until it is fetched
+		createdAt := activity.UpdatedAt
+		if block, ok := blocks[activity.BlockHash]; ok {
+			entry.AdminDID = block.ExecutorDID
+			if block.Epoch > 0 {
+				createdAt = time.Unix(int64(block.Epoch), 0).UTC()
+			}
+		}
+		entry.CreatedAt = createdAt
+		catalog = append(catalog, entry)
+	}
+	return catalog, nil
+}
+
+// activityQuery holds the filters, sort order and page of GET /api/activities
+type activityQuery struct {
+	search      string
+	adminDID    string
+	claimPolicy ClaimPolicy
+	minPoints   *int
+	maxPoints   *int
+	sortBy      string
+	descending  bool
+	page        int
+	pageSize    int
+}
+
+func parseActivityQuery(c *gin.Context) (activityQuery, error) {
+	query := activityQuery{
+		search:   strings.ToLower(c.Query("q")),
+		adminDID: c.Query("admin_did"),
+		sortBy:   c.DefaultQuery("sort", "activity_id"),
+		page:     1,
+		pageSize: defaultActivityPageSize,
+	}
+
+	if value := c.Query("claim_policy"); value != "" {
+		policy, err := parseClaimPolicy(value)
+		if err != nil {
+			return query, err
+		}
+		query.claimPolicy = policy
+	}
+	for name, target := range map[string]**int{"min_points": &query.minPoints, "max_points": &query.maxPoints} {
+		if value := c.Query(name); value != "" {
+			points, err := strconv.Atoi(value)
+			if err != nil {
+				return query, fmt.Errorf("%s must be an integer", name)
+			}
+			*target = &points
+		}
+	}
+
+	switch query.sortBy {
+	case "activity_id", "reward_points", "created_at":
+	default:
+		return query, fmt.Errorf("sort must be one of activity_id, reward_points, created_at")
+	}
+	switch order := c.DefaultQuery("order", "asc"); order {
+	case "asc":
+	case "desc":
+		query.descending = true
+	default:
+		return query, fmt.Errorf("order must be asc or desc")
+	}
+
+	if value := c.Query("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return query, fmt.Errorf("page must be a positive integer")
+		}
+		query.page = page
+	}
+	if value := c.Query("page_size"); value != "" {
+		pageSize, err := strconv.Atoi(value)
+		if err != nil || pageSize < 1 || pageSize > maxActivityPageSize {
+			return query, fmt.Errorf("page_size must be between 1 and %d", maxActivityPageSize)
+		}
+		query.pageSize = pageSize
+	}
+	return query, nil
+}
+
+func (q activityQuery) matches(activity CatalogActivity) bool {
+	if q.search != "" && !strings.Contains(strings.ToLower(activity.ActivityID), q.search) {
+		return false
+	}
+	if q.adminDID != "" && activity.AdminDID != q.adminDID {
+		return false
+	}
+	if q.claimPolicy != "" && activity.ClaimPolicy != q.claimPolicy {
+		return false
+	}
+	if q.minPoints != nil && activity.RewardPoints < *q.minPoints {
+		return false
+	}
+	if q.maxPoints != nil && activity.RewardPoints > *q.maxPoints {
+		return false
+	}
+	return true
+}
+
+func (q activityQuery) less(a CatalogActivity, b CatalogActivity) bool {
+	switch q.sortBy {
+	case "reward_points":
+		if a.RewardPoints != b.RewardPoints {
+			return a.RewardPoints < b.RewardPoints
+		}
+	case "created_at":
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+	}
+	return a.ActivityID < b.ActivityID
+}
+
+// APIListActivities lists the activity catalog. It accepts the filters q,
+// admin_did, claim_policy, min_points and max_points, sort (activity_id,
+// reward_points or created_at) with order (asc or desc), and page/page_size.
+func APIListActivities(c *gin.Context) {
+	query, err := parseActivityQuery(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid query", err)
+		return
+	}
+	catalog, err := loadCatalog(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to load activities", err)
+		fmt.Println("failed to load activities:", err)
+		return
+	}
+
+	matched := make([]CatalogActivity, 0, len(catalog))
+	for _, activity := range catalog {
+		if query.matches(activity) {
+			matched = append(matched, activity)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if query.descending {
+			return query.less(matched[j], matched[i])
+		}
+		return query.less(matched[i], matched[j])
+	})
+
+	total := len(matched)
+	totalPages := (total + query.pageSize - 1) / query.pageSize
+	// Compare pages before multiplying: a huge page would overflow the offset
+	start := total
+	if query.page-1 < totalPages {
+		start = (query.page - 1) * query.pageSize
+	}
+	end := start + query.pageSize
+	if end > total {
+		end = total
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data":   matched[start:end],
+		"pagination": gin.H{
+			"page":        query.page,
+			"page_size":   query.pageSize,
+			"total":       total,
+			"total_pages": totalPages,
+		},
+	})
+}
+
+// APIGetActivity returns a single activity from the catalog
+func APIGetActivity(c *gin.Context) {
+	activityID := c.Param("id")
+	catalog, err := loadCatalog(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to load activities", err)
+		fmt.Println("failed to load activities:", err)
+		return
+	}
+	for _, activity := range catalog {
+		if activity.ActivityID == activityID {
+			c.JSON(http.StatusOK, gin.H{"status": true, "data": activity})
+			return
+		}
+	}
+	respondError(c, http.StatusNotFound, CodeNotFound, "Activity not found", nil)
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	rubix_interaction "dapp-server/rubix-interaction"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestActivityCatalog(t *testing.T) {
+	node := startNode(t)
+
+	status, body := postJSON(t, "/api/activity/add", AddActivityRequest{
+		ActivityID:   "catalog-pilates",
+		RewardPoints: 40,
+		AdminDID:     testAdminDID,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("add activity = %d, %v", status, body)
+	}
+	// The callback contract is faked, so record the activity the way it would
+	blocks := node.Blocks(testActivityContract)
+	err := ActivityRecordKind.Store([]byte(fmt.Sprintf(
+		`{"activity_id":"catalog-pilates","reward_points":40,"block_hash":"%s"}`, blocks[len(blocks)-1].BlockId)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, body = getJSON(t, "/api/activities/catalog-pilates")
+	if status != http.StatusOK {
+		t.Fatalf("get activity = %d, %v", status, body)
+	}
+	data, _ := body["data"].(map[string]interface{})
+	if data["admin_did"] != testAdminDID || data["created_at"] == nil || data["reward_points"] != float64(40) {
+		t.Fatalf("activity = %v", data)
+	}
+
+	status, body = getJSON(t, "/api/activities?min_points=20&sort=reward_points&order=desc&page_size=2")
+	if status != http.StatusOK {
+		t.Fatalf("list = %d, %v", status, body)
+	}
+	var ids []string
+	list, _ := body["data"].([]interface{})
+	for _, item := range list {
+		ids = append(ids, item.(map[string]interface{})["activity_id"].(string))
+	}
+	if got := strings.Join(ids, ","); got != "catalog-pilates,yoga-once" {
+		t.Fatalf("page 1 = %s", got)
+	}
+	pagination, _ := body["pagination"].(map[string]interface{})
+	if pagination["total"] != float64(3) || pagination["total_pages"] != float64(2) {
+		t.Fatalf("pagination = %v", pagination)
+	}
+
+	if status, _ := getJSON(t, "/api/activities/no-such-activity"); status != http.StatusNotFound {
+		t.Fatalf("missing activity = %d", status)
+	}
+	if status, _ := getJSON(t, "/api/activities?sort=colour"); status != http.StatusBadRequest {
+		t.Fatalf("bad sort = %d", status)
+	}
+}
+
+func TestActivityQueryFiltersAndSorts(t *testing.T) {
+	parse := func(rawQuery string) (activityQuery, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/activities?"+rawQuery, nil)
+		return parseActivityQuery(c)
+	}
+	early, late := time.Unix(1700000000, 0), time.Unix(1800000000, 0)
+	catalog := []CatalogActivity{
+		{ActivityID: "Swim-Laps", RewardPoints: 20, ClaimPolicy: ClaimDaily, AdminDID: "bafybmione", CreatedAt: late},
+		{ActivityID: "yoga", RewardPoints: 20, ClaimPolicy: ClaimOneTime, AdminDID: "bafybmitwo", CreatedAt: early},
+		{ActivityID: "swim-relay", RewardPoints: 50, ClaimPolicy: ClaimDaily, AdminDID: "bafybmitwo", CreatedAt: early},
+	}
+	ids := func(query activityQuery) string {
+		var matched []CatalogActivity
+		for _, activity := range catalog {
+			if query.matches(activity) {
+				matched = append(matched, activity)
+			}
+		}
+		sort.SliceStable(matched, func(i, j int) bool { return query.less(matched[i], matched[j]) })
+		var ids []string
+		for _, activity := range matched {
+			ids = append(ids, activity.ActivityID)
+		}
+		return strings.Join(ids, ",")
+	}
+
+	for rawQuery, want := range map[string]string{
+		"":                                     "Swim-Laps,swim-relay,yoga",
+		"q=SWIM":                               "Swim-Laps,swim-relay",
+		"admin_did=bafybmitwo&sort=created_at": "swim-relay,yoga",
+		"claim_policy=daily&min_points=30":     "swim-relay",
+		"max_points=20&sort=reward_points":     "Swim-Laps,yoga",
+		"sort=created_at":                      "swim-relay,yoga,Swim-Laps",
+	} {
+		query, err := parse(rawQuery)
+		if err != nil {
+			t.Fatalf("%q: %v", rawQuery, err)
+		}
+		if got := ids(query); got != want {
+			t.Errorf("%q = %s, want %s", rawQuery, got, want)
+		}
+	}
+	for _, rawQuery := range []string{"sort=colour", "order=up", "page=0", "page_size=101", "min_points=many", "claim_policy=weekly"} {
+		if _, err := parse(rawQuery); err == nil {
+			t.Errorf("%q was accepted", rawQuery)
+		}
+	}
+}
+
+func TestActivityPageBeyondTheLastIsEmpty(t *testing.T) {
+	status, body := getJSON(t, "/api/activities?page=9223372036854775807&page_size=2")
+	data, _ := body["data"].([]interface{})
+	pagination, _ := body["pagination"].(map[string]interface{})
+	if status != http.StatusOK || len(data) != 0 || pagination["page"] != float64(9223372036854775807) {
+		t.Fatalf("huge page = %d, %v", status, body)
+	}
+}
+
+func TestActivityChainIsServedWhileRefreshing(t *testing.T) {
+	release := make(chan struct{})
+	slowNode := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		<-release
+		w.Write([]byte(`{"status":true,"SCTDataReply":[]}`))
+	}))
+	defer slowNode.Close()
+	previous := newNodeClient
+	newNodeClient = func(port string) rubix_interaction.NodeClient {
+		return rubix_interaction.NewRubixClient(slowNode.URL)
+	}
+	defer func() { newNodeClient = previous }()
+
+	cached := map[string]rubix_interaction.SmartContractBlock{"1-cached": {BlockId: "1-cached"}}
+	chain := &activityChain{blocks: cached, fetchedAt: time.Now().Add(-2 * activityChainTTL)}
+	refreshed := make(chan struct{})
+	go func() {
+		chain.blocksFor(context.Background(), nil)
+		close(refreshed)
+	}()
+	// Wait for the refresh to start, then read while the node is stalled
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		chain.mu.Lock()
+		started := chain.refreshing != nil
+		chain.mu.Unlock()
+		if started {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("refresh did not start")
+		}
+	}
+	done := make(chan map[string]rubix_interaction.SmartContractBlock)
+	go func() { done <- chain.blocksFor(context.Background(), nil) }()
+	select {
+	case blocks := <-done:
+		if _, ok := blocks["1-cached"]; !ok {
+			t.Fatalf("blocks during refresh = %v", blocks)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("reader waited for the slow node")
+	}
+	close(release)
+	<-refreshed
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bytes"
+	"dapp-server/config"
+	"dapp-server/database"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminSetTTL bounds how long an admin added or removed on chain can go
+// unnoticed by a server that did not run the callback itself
+const adminSetTTL = 30 * time.Second
+
+// adminSet caches the admin DIDs recorded by the add_admin contract. DIDs
+// listed in BOOTSTRAP_ADMIN_DIDS are admins as well, so the first admin can
+// be added before the contract has recorded any.
+type adminSet struct {
+	mu        sync.RWMutex
+	bootstrap map[string]bool
+	admins    map[string]bool
+	loadedAt  time.Time
+}
+
+var (
+	adminSetOnce     sync.Once
+	adminSetInstance *adminSet
+)
+
+func getAdminSet() *adminSet {
+	adminSetOnce.Do(func() {
+		bootstrap := make(map[string]bool)
+		for _, did := range strings.Split(config.GetEnvConfig().BootstrapAdmins, ",") {
+			if did = strings.TrimSpace(did); did != "" {
+				bootstrap[did] = true
+			}
+		}
+		adminSetInstance = &adminSet{bootstrap: bootstrap}
+	})
+	return adminSetInstance
+}
+
+// IsAdmin reports whether did is a current admin
+func (s *adminSet) IsAdmin(did string) (bool, error) {
+	if s.bootstrap[did] {
+		return true, nil
+	}
+	s.mu.RLock()
+	admins, fresh := s.admins, time.Since(s.loadedAt) < adminSetTTL
+	s.mu.RUnlock()
+	if admins == nil || !fresh {
+		var err error
+		if admins, err = s.reload(); err != nil {
+			return false, err
+		}
+	}
+	return admins[did], nil
+}
+
+// Bootstrap returns the configured bootstrap admins, sorted
+func (s *adminSet) Bootstrap() []string {
+	dids := make([]string, 0, len(s.bootstrap))
+	for did := range s.bootstrap {
+		dids = append(dids, did)
+	}
+	sort.Strings(dids)
+	return dids
+}
+
+// Invalidate makes the next lookup re-read the admin store
+func (s *adminSet) Invalidate() {
+	s.mu.Lock()
+	s.admins = nil
+	s.mu.Unlock()
+}
+
+func (s *adminSet) reload() (map[string]bool, error) {
+	records, err := database.ListAdmins()
+	if err != nil {
+		return nil, err
+	}
+	admins := make(map[string]bool, len(records))
+	for _, record := range records {
+		admins[record.AdminDID] = true
+	}
+	s.mu.Lock()
+	s.admins = admins
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+	return admins, nil
+}
+
+// RequireAdmin rejects requests whose acting DID, read from the given field
+// of the JSON body or multipart form, is not a current admin, or is not the
+// DID the caller acts as: its session's, or its API key's. A key bound to
+// no DID cannot act as an admin. The body is left intact for the handler.
+func RequireAdmin(field string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var did string
+		if c.ContentType() == gin.MIMEMultipartPOSTForm {
+			// The parsed form stays on the request for the handler
+			form, ok := parseUploadForm(c)
+			if !ok {
+				return
+			}
+			if values := form.Value[field]; len(values) > 0 {
+				did = values[0]
+			}
+		} else {
+			body, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body", err)
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(body, &fields); err != nil {
+				respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body", err)
+				return
+			}
+			if raw, ok := fields[field]; ok {
+				json.Unmarshal(raw, &did)
+			}
+		}
+		if did == "" {
+			respondError(c, http.StatusBadRequest, CodeValidationFailed, "Acting admin DID is required", nil,
+				gin.H{"fields": FieldErrors{field: "is required"}})
+			return
+		}
+
+		// A caller can only act as its own DID, so the actor recorded for an
+		// admin change is the caller
+		principal := currentPrincipal(c)
+		if principal == nil || principal.DID == "" {
+			respondError(c, http.StatusForbidden, CodeForbidden, "Caller is not bound to a DID",
+				fmt.Errorf("log in as %s, or use an API key created with --did %s", did, did))
+			return
+		}
+		if principal.DID != did {
+			respondError(c, http.StatusForbidden, CodeForbidden, "Acting DID does not match the caller",
+				fmt.Errorf("caller acts as %s but %s is %s", principal.DID, field, did))
+			return
+		}
+
+		isAdmin, err := getAdminSet().IsAdmin(did)
+		if err != nil {
+			fmt.Printf("Failed to load admins: %v\n", err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to load admins", err)
+			return
+		}
+		if !isAdmin {
+			fmt.Println("Rejected request from non-admin DID:", did)
+			respondError(c, http.StatusForbidden, CodeNotAdmin, "Not an admin", fmt.Errorf("%s is not a current admin", did))
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"dapp-server/database"
+	rubix_interaction "dapp-server/rubix-interaction"
+
+	wasmbridge "github.com/rubixchain/rubix-wasm/go-wasm-bridge"
+)
+
+func TestAdminRoutesRejectNonAdmins(t *testing.T) {
+	node := startNode(t)
+	const outsider = "bafybmiadminoutsider"
+	before := len(node.Blocks(testActivityContract))
+	// A tool acting as the outsider, so only its admin status decides. Its
+	// admin role only counts once the DID is an admin.
+	outsiderKey, err := CreateAPIKey("outsider-tool", []string{RoleAdmin, RoleStaff}, outsider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, call := range []struct {
+		path string
+		body interface{}
+		code ErrorCode
+	}{
+		{"/api/activity/add", AddActivityRequest{ActivityID: "outsider-run", RewardPoints: 10, AdminDID: outsider}, CodeNotAdmin},
+		{"/api/rewards/transfer", TransferRewardRequest{ActivityID: []string{"stretch"}, UserDID: testUserDID, AdminDID: outsider}, CodeNotAdmin},
+		{"/api/admin/add", AddAdminRequest{NewAdminDID: outsider, ExistingAdminDID: outsider}, CodeForbidden},
+		{"/api/execute-contract", ExecuteRequest{ContractHash: testActivityContract, ExecutorDid: outsider}, CodeForbidden},
+	} {
+		if status, body := callWith(t, http.MethodPost, call.path, apiKeyHeader, outsiderKey, call.body); status != http.StatusForbidden || body["code"] != string(call.code) {
+			t.Fatalf("%s by a non-admin = %d, %v", call.path, status, body)
+		}
+	}
+	if status, body := postJSON(t, "/api/activity/add", AddActivityRequest{ActivityID: "anonymous-run", RewardPoints: 10}); status != http.StatusBadRequest {
+		t.Fatalf("activity without an admin DID = %d, %v", status, body)
+	}
+	if after := len(node.Blocks(testActivityContract)); after != before {
+		t.Fatalf("a rejected request reached the contract: %d blocks, was %d", after, before)
+	}
+
+	// Once the add_admin contract records the DID it is let through
+	if err := AdminRecordKind.Store([]byte(`{"admin_did":"` + outsider + `"}`)); err != nil {
+		t.Fatal(err)
+	}
+	getAdminSet().Invalidate()
+	status, body := callWith(t, http.MethodPost, "/api/rewards/transfer", apiKeyHeader, outsiderKey,
+		TransferRewardRequest{ActivityID: []string{"stretch"}, UserDID: testUserDID, AdminDID: outsider})
+	if status == http.StatusForbidden {
+		t.Fatalf("recorded admin rejected: %v", body)
+	}
+}
+
+func TestAdminSetCachesTheStore(t *testing.T) {
+	const cached, other = "bafybmicachedadmin", "bafybmicachedother"
+	set := &adminSet{bootstrap: map[string]bool{"bafybmibootstrapped": true}}
+	if isAdmin, err := set.IsAdmin("bafybmibootstrapped"); err != nil || !isAdmin {
+		t.Fatalf("bootstrap admin = %v, %v", isAdmin, err)
+	}
+
+	for _, did := range []string{cached, other} {
+		if _, err := database.AddAdmin(did); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { database.RemoveAdmin(other) })
+	if isAdmin, err := set.IsAdmin(cached); err != nil || !isAdmin {
+		t.Fatalf("recorded admin = %v, %v", isAdmin, err)
+	}
+	if _, err := database.RemoveAdmin(cached); err != nil {
+		t.Fatal(err)
+	}
+	if isAdmin, _ := set.IsAdmin(cached); !isAdmin {
+		t.Fatal("the cached admin set was re-read before it went stale")
+	}
+	set.Invalidate()
+	if isAdmin, err := set.IsAdmin(cached); err != nil || isAdmin {
+		t.Fatalf("removed admin after invalidating = %v, %v", isAdmin, err)
+	}
+}
+
+func TestAdminRemovalAndHistory(t *testing.T) {
+	node := startNode(t)
+	registerCallback(t, node, testAdminContract, "api/callback/add-admin")
+	const target, last = "bafybmiremovableadmin", "bafybmilastadmin"
+	if _, err := database.AddAdmin(last); err != nil {
+		t.Fatal(err)
+	}
+
+	// The admin contract is faked, so apply the record the callback hands it
+	applyAdminCall := func(t *testing.T) {
+		t.Helper()
+		var payload Payload
+		if err := json.Unmarshal([]byte(lastContractCall(t, testAdminContract).Input), &payload); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		if payload.AddAdmin != nil {
+			record, _ := json.Marshal(payload.AddAdmin)
+			err = AdminRecordKind.Store(record)
+		} else {
+			record, _ := json.Marshal(payload.RemoveAdmin)
+			err = AdminRemovalRecordKind.Store(record)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if status, body := postJSON(t, "/api/admin/add", AddAdminRequest{NewAdminDID: target, ExistingAdminDID: testAdminDID}); status != http.StatusOK {
+		t.Fatalf("add = %d, %v", status, body)
+	}
+	applyAdminCall(t)
+
+	status, body := getJSON(t, "/api/admins")
+	if status != http.StatusOK || !strings.Contains(fmt.Sprint(body["data"]), target) || fmt.Sprint(body["bootstrap_admins"]) != "["+testAdminDID+"]" {
+		t.Fatalf("admins = %d, %v", status, body)
+	}
+
+	if status, body := deleteJSON(t, "/api/admins/"+target, RemoveAdminRequest{ExistingAdminDID: testAdminDID}); status != http.StatusOK {
+		t.Fatalf("remove = %d, %v", status, body)
+	}
+	applyAdminCall(t)
+	if isAdmin, _ := database.IsAdmin(target); isAdmin {
+		t.Fatal("removed admin is still recorded")
+	}
+
+	status, body = getJSON(t, "/api/admins/history?admin_did="+target)
+	events, _ := body["data"].([]interface{})
+	if status != http.StatusOK || len(events) != 2 {
+		t.Fatalf("history = %d, %v", status, body)
+	}
+	for i, action := range []string{database.AdminAdded, database.AdminRemoved} {
+		event := events[i].(map[string]interface{})
+		if event["action"] != action || event["actor_did"] != testAdminDID || event["block_id"] == nil {
+			t.Fatalf("event %d = %v", i, event)
+		}
+	}
+
+	if status, body := deleteJSON(t, "/api/admins/"+target, RemoveAdminRequest{ExistingAdminDID: testAdminDID}); status != http.StatusNotFound {
+		t.Fatalf("removing a non-admin = %d, %v", status, body)
+	}
+
+	// Reduce the store to one admin; that one cannot be removed
+	admins, err := database.ListAdmins()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var others []string
+	for _, admin := range admins {
+		if admin.AdminDID != last {
+			others = append(others, admin.AdminDID)
+			if _, err := database.RemoveAdmin(admin.AdminDID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	t.Cleanup(func() {
+		for _, did := range others {
+			database.AddAdmin(did)
+		}
+		getAdminSet().Invalidate()
+	})
+	if status, body := deleteJSON(t, "/api/admins/"+last, RemoveAdminRequest{ExistingAdminDID: testAdminDID}); status != http.StatusConflict {
+		t.Fatalf("removing the last admin = %d, %v", status, body)
+	}
+}
+
+// reduceAdminsTo leaves only dids recorded as admins until the test ends
+func reduceAdminsTo(t *testing.T, dids ...string) {
+	t.Helper()
+	admins, err := database.ListAdmins()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var others []string
+	for _, admin := range admins {
+		others = append(others, admin.AdminDID)
+		if _, err := database.RemoveAdmin(admin.AdminDID); err != nil && !errors.Is(err, database.ErrLastAdmin) {
+			t.Fatal(err)
+		}
+	}
+	for _, did := range dids {
+		if _, err := database.AddAdmin(did); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, did := range others {
+		if _, err := database.RemoveAdmin(did); err != nil && !errors.Is(err, database.ErrLastAdmin) {
+			t.Fatal(err)
+		}
+	}
+	getAdminSet().Invalidate()
+	t.Cleanup(func() {
+		for _, did := range others {
+			database.AddAdmin(did)
+		}
+		for _, did := range dids {
+			database.RemoveAdmin(did)
+		}
+		adminRemovals.Lock()
+		for _, did := range dids {
+			delete(adminRemovals.pending, did)
+		}
+		adminRemovals.Unlock()
+		getAdminSet().Invalidate()
+	})
+}
+
+func TestConcurrentAdminRemovalsKeepAnAdmin(t *testing.T) {
+	node := startNode(t)
+	const first, second = "bafybmiconcurrentfirst", "bafybmiconcurrentsecond"
+	reduceAdminsTo(t, first, second)
+	before := len(node.Blocks(testAdminContract))
+
+	// The faked contract does not record the removals, so both admins stay
+	// recorded while both requests are checked
+	statuses := make(chan int, 2)
+	var wg sync.WaitGroup
+	for _, did := range []string{first, second} {
+		wg.Add(1)
+		go func(did string) {
+			defer wg.Done()
+			status, _ := deleteJSON(t, "/api/admins/"+did, RemoveAdminRequest{ExistingAdminDID: testAdminDID})
+			statuses <- status
+		}(did)
+	}
+	wg.Wait()
+	close(statuses)
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusOK] != 1 || counts[http.StatusConflict] != 1 {
+		t.Fatalf("concurrent removals = %v, want one removed and one refused", counts)
+	}
+	if after := len(node.Blocks(testAdminContract)); after != before+1 {
+		t.Fatalf("%d removals reached the chain, want 1", after-before)
+	}
+}
+
+func TestAdminCallbackReportsTheLastAdmin(t *testing.T) {
+	node := startNode(t)
+	const last = "bafybmionlyrecordedadmin"
+	reduceAdminsTo(t, last)
+
+	// A removal of the last admin made on chain outside this server
+	ctx := context.Background()
+	client := newNodeClient(testPort)
+	message, err := rubix_interaction.ContractMessage(rubix_interaction.RemoveAdminReq{AdminDID: last})
+	if err != nil {
+		t.Fatal(err)
+	}
+	executeID, err := client.ExecuteSmartContract(ctx, rubix_interaction.NewExecuteSmartContractRequest(testAdminContract, testAdminDID, message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rubix_interaction.Sign(ctx, client, testAdminDID, executeID); err != nil {
+		t.Fatal(err)
+	}
+	registerCallback(t, node, testAdminContract, "api/callback/add-admin")
+
+	// Run the contract as far as its record: it hands the removal to the
+	// store and carries on when the store refuses it
+	previous := runContract
+	runContract = func(contractHash string, port string, nodeURL string, registry *wasmbridge.HostFunctionRegistry, contractInput string) (string, error) {
+		var payload Payload
+		if err := json.Unmarshal([]byte(contractInput), &payload); err != nil || payload.RemoveAdmin == nil {
+			return "", fmt.Errorf("unexpected input %s", contractInput)
+		}
+		record, _ := json.Marshal(map[string]interface{}{"kind": AdminRemovalRecordKind.Name, "record": payload.RemoveAdmin})
+		for _, function := range registry.GetHostFunctions() {
+			if writer, ok := function.(*rubix_interaction.WriteToJsonFile); ok {
+				writer.Store(record)
+			}
+		}
+		return "success", nil
+	}
+	t.Cleanup(func() { runContract = previous })
+
+	registration, _ := database.GetCallbackRegistration(testAdminContract, testPort)
+	status, body := postJSON(t, "/api/callback/add-admin?"+rubix_interaction.CallbackTokenParam+"="+registration.Secret,
+		ContractInputRequest{Port: testPort, SmartContractHash: testAdminContract})
+	if status != http.StatusConflict || body["code"] != string(CodeLastAdmin) {
+		t.Fatalf("callback removing the last admin = %d, %v", status, body)
+	}
+	if isAdmin, _ := database.IsAdmin(last); !isAdmin {
+		t.Fatal("the last admin was removed")
+	}
+}
@@ -1,11 +1,11 @@
 package server
 
 import (
-	"encoding/json"
+	"dapp-server/database"
 	"fmt"
 	"net/http"
+	"os"
 
-	"dapp-server/config"
 	rubix "dapp-server/rubix-interaction"
 
 	"github.com/gin-gonic/gin"
@@ -18,53 +18,91 @@ type ExecuteRequest struct {
 	ContractInput string `json:"contract_input"`
 }
 
+// DeployRequest deploys a contract and registers it under Name, which
+// defaults to the wasm file's name, and Role, if given. If CallbackEndpoint
+// is set, nodes are told to call it whenever the contract is executed: the
+// CallbackNodes, given by name or port, or else the deployer's node. The
+// files may instead be uploaded, see bindDeployUpload.
 type DeployRequest struct {
-	WasmPath    string `json:"wasm_path"`
-	LibPath     string `json:"lib_path"`
-	DeployerDid string `json:"deployer_did"`
-	StatePath   string `json:"state_path"`
+	WasmPath         string   `json:"wasm_path"`
+	LibPath          string   `json:"lib_path"`
+	DeployerDid      string   `json:"deployer_did"`
+	StatePath        string   `json:"state_path"`
+	Name             string   `json:"name,omitempty"`
+	Role             string   `json:"role,omitempty"`
+	CallbackEndpoint string   `json:"callback_endpoint"`
+	CallbackNodes    []string `json:"callback_nodes"`
+}
+
+func (r ExecuteRequest) Validate() FieldErrors {
+	problems := FieldErrors{}
+	problems.Required("contract_hash", r.ContractHash)
+	problems.DID("executor_did", r.ExecutorDid)
+	problems.Required("contract_input", r.ContractInput)
+	return problems
+}
+
+func (r DeployRequest) Validate() FieldErrors {
+	problems := FieldErrors{}
+	for field, path := range map[string]string{
+		"wasm_path":  r.WasmPath,
+		"lib_path":   r.LibPath,
+		"state_path": r.StatePath,
+	} {
+		problems.Required(field, path)
+		if _, err := os.Stat(path); path != "" && err != nil {
+			problems.add(field, "is not a readable file on the server")
+		}
+	}
+	r.validateContract(problems)
+	return problems
+}
+
+// validateContract checks everything but the contract's files
+func (r DeployRequest) validateContract(problems FieldErrors) {
+	problems.DID("deployer_did", r.DeployerDid)
+	if r.Name != "" {
+		problems.ID("name", r.Name)
+	}
+	if r.Role != "" {
+		problems.ContractRole("role", r.Role)
+	}
+	if r.CallbackEndpoint != "" {
+		problems.CallbackEndpoint("callback_endpoint", r.CallbackEndpoint)
+	} else if len(r.CallbackNodes) > 0 {
+		problems.add("callback_endpoint", "is required with callback_nodes")
+	}
 }
 
 func APIExecuteContract(c *gin.Context) {
 	var req ExecuteRequest
-	err := json.NewDecoder(c.Request.Body).Decode(&req)
-
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
-		fmt.Printf("Error reading response body: %s\n", err)
+	if !bindRequest(c, &req) {
 		return
 	}
-	// Load config to get API URL
-	cfg, err := config.GetConfig()
+	// Rewards go through /api/rewards/transfer, which claims the activities
+	// and prices the reward before the transfer contract runs
+	contract, err := database.GetContract(req.ContractHash)
 	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to look up contract", err)
 		return
 	}
-	nodeName, exist := config.GetNodeNameByDid(cfg, req.ExecutorDid)
-	if !exist {
-		fmt.Println("Failed to fetch node name from config")
-	}
-	fmt.Println("The node name is :", nodeName)
-	result, err := rubix.Execute(req.ContractHash, req.ExecutorDid, req.ContractInput, nodeName)
-	if err != nil {
-		fmt.Println("Failed to execute Contract err :", err)
+	if contract != nil && contract.Role == database.ContractRoleTransfer {
+		respondError(c, http.StatusForbidden, CodeForbidden, "Transfer contracts cannot be executed directly",
+			fmt.Errorf("use /api/rewards/transfer"))
+		return
 	}
-	fmt.Println("The result returned : ", result)
-	port, exist := config.GetPortByNodeName(cfg, nodeName)
-	if !exist {
-		fmt.Println("Failed to fetch port from config")
+	port, ok := nodePortFor(c, "executor_did", req.ExecutorDid)
+	if !ok {
+		return
 	}
-
-	url := fmt.Sprintf("http://localhost:%s", port)
-	fmt.Println("The url is :", url)
-	// Call signature-response API
-	_, err = rubix.SignatureResponse(url, result.ContractResult)
+	fmt.Println("The node port is :", port)
+	// Execute signs the execution itself, which creates the block
+	result, err := rubix.Execute(c.Request.Context(), newNodeClient(port), req.ContractHash, req.ExecutorDid, req.ContractInput)
 	if err != nil {
-		fmt.Println("Failed to send signature response:", err)
+		respondNodeError(c, "Failed to execute contract", err)
 		return
 	}
-	// if err := rubix.SignatureResponse(url, result.ContractResult); err != nil {
-	// 	return
-	// }
+	fmt.Println("The result returned : ", result)
 
 	resultFinal := gin.H{
 		"message": "DApp executed successfully",
@@ -77,29 +115,71 @@ func APIExecuteContract(c *gin.Context) {
 
 func APIDeployContract(c *gin.Context) {
 	var req DeployRequest
-	err := json.NewDecoder(c.Request.Body).Decode(&req)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
-		fmt.Printf("Error reading response body: %s\n", err)
+	if c.ContentType() == gin.MIMEMultipartPOSTForm {
+		if !bindDeployUpload(c, &req) {
+			return
+		}
+	} else if !bindRequest(c, &req) {
 		return
 	}
-	// Load config to get API URL
-	cfg, err := config.GetConfig()
-	if err != nil {
+	port, ok := nodePortFor(c, "deployer_did", req.DeployerDid)
+	if !ok {
 		return
 	}
-	nodeName, exist := config.GetNodeNameByDid(cfg, req.DeployerDid)
-	if !exist {
-		fmt.Println("Failed to fetch node name from config")
+	var callbacks []rubix.CallbackTarget
+	if req.CallbackEndpoint != "" {
+		nodes := req.CallbackNodes
+		if len(nodes) == 0 {
+			nodes = []string{port}
+		}
+		callbackPorts, ok := callbackNodePorts(c, "callback_nodes", nodes)
+		if !ok {
+			return
+		}
+		for _, callbackPort := range callbackPorts {
+			callbacks = append(callbacks, callbackTarget(callbackPort, req.CallbackEndpoint))
+		}
+	}
+	contract := database.ContractRecord{Name: req.Name, Role: req.Role, DeployerDID: req.DeployerDid}
+	if contract.Name == "" {
+		contract.Name = contractName(req.WasmPath)
 	}
-	result, err := rubix.Deploy(req.WasmPath, req.LibPath, req.DeployerDid, req.StatePath, nodeName)
+	for _, file := range []struct {
+		path   string
+		digest *string
+	}{
+		{req.WasmPath, &contract.WasmDigest},
+		{req.LibPath, &contract.LibDigest},
+		{req.StatePath, &contract.StateDigest},
+	} {
+		digest, err := fileDigest(file.path)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to read contract files", err)
+			return
+		}
+		*file.digest = digest
+	}
+
+	client := newNodeClient(port)
+	result, err := rubix.Deploy(c.Request.Context(), client, req.WasmPath, req.LibPath, req.DeployerDid, req.StatePath, callbacks...)
 	if err != nil {
-		fmt.Println("Failed to deploy contract err :", err)
+		respondNodeError(c, "Failed to deploy contract", err)
+		return
 	}
 	fmt.Println("The result returned : ", result)
+	result.Callbacks = saveCallbackRegistrations(result.ContractHash, result.Callbacks)
+	contract.ContractHash = result.ContractHash
+	contract.DeploymentBlock = result.DeploymentBlock
+	registered, err := database.SaveContract(contract)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Contract deployed but not registered", err,
+			gin.H{"contract_hash": result.ContractHash})
+		return
+	}
 	resultFinal := gin.H{
-		"message": "Contract Deployed Successfully",
-		"data":    result,
+		"message":  "Contract Deployed Successfully",
+		"data":     result,
+		"contract": registered,
 	}
 	c.JSON(http.StatusOK, resultFinal)
 }
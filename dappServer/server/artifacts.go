@@ -0,0 +1,203 @@
+package server
+
+import (
+	"crypto/sha256"
+	"dapp-server/config"
+	"dapp-server/database"
+	rubix_interaction "dapp-server/rubix-interaction"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxDeployUpload bounds the whole multipart body of a deploy
+const maxDeployUpload = 32 << 20
+
+// defaultArtifactDir is where uploaded contract files are kept when
+// ARTIFACT_DIR is not set
+const defaultArtifactDir = "artifacts"
+
+// roleFunctions are the contract functions the server calls on a contract
+// of each role, and so the functions its wasm must export
+var roleFunctions = map[string][]rubix_interaction.ContractRequest{
+	database.ContractRoleActivity: {rubix_interaction.AddActivityReq{}},
+	database.ContractRoleAdmin:    {rubix_interaction.AddAdminReq{}, rubix_interaction.RemoveAdminReq{}},
+	database.ContractRoleTransfer: {rubix_interaction.TransferSampleFTReq{}},
+}
+
+// contractFunctions returns the names of the functions a contract of role
+// must export; none for a contract without a role
+func contractFunctions(role string) []string {
+	var functions []string
+	for _, req := range roleFunctions[role] {
+		functions = append(functions, req.ContractFunction())
+	}
+	return functions
+}
+
+// artifactDir reads ARTIFACT_DIR
+func artifactDir() string {
+	if dir := strings.TrimSpace(config.GetEnvConfig().ArtifactDir); dir != "" {
+		return dir
+	}
+	return defaultArtifactDir
+}
+
+// deployUpload is a contract file field of the multipart deploy form
+type deployUpload struct {
+	field string
+	ext   string
+	path  *string
+	check func(data []byte) error
+}
+
+// bindDeployUpload reads the multipart form of a deploy into req. It takes
+// the fields of DeployRequest by their JSON names, callback_nodes repeated,
+// and the contract's files as wasm_file, lib_file and state_file in place of
+// their paths. The wasm must parse and export the functions of the
+// contract's role. The files are stored under ARTIFACT_DIR by digest, so an
+// upload of the same file is stored once, and req's paths point at them. On
+// failure the error response has been written and it returns false.
+func bindDeployUpload(c *gin.Context, req *DeployRequest) bool {
+	form, ok := parseUploadForm(c)
+	if !ok {
+		return false
+	}
+	defer form.RemoveAll()
+
+	value := func(field string) string {
+		if values := form.Value[field]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	req.DeployerDid = value("deployer_did")
+	req.Name = value("name")
+	req.Role = value("role")
+	req.CallbackEndpoint = value("callback_endpoint")
+	req.CallbackNodes = form.Value["callback_nodes"]
+	problems := FieldErrors{}
+	req.validateContract(problems)
+
+	uploads := []deployUpload{
+		{"wasm_file", ".wasm", &req.WasmPath, func(data []byte) error {
+			return rubix_interaction.ValidateContractWasm(data, contractFunctions(req.Role))
+		}},
+		{"lib_file", ".rs", &req.LibPath, nil},
+		{"state_file", ".json", &req.StatePath, func(data []byte) error {
+			if !json.Valid(data) {
+				return errors.New("must be a JSON file")
+			}
+			return nil
+		}},
+	}
+	contents := make([][]byte, len(uploads))
+	for i, upload := range uploads {
+		files := form.File[upload.field]
+		if len(files) == 0 {
+			problems.add(upload.field, "is required")
+			continue
+		}
+		file, err := files[0].Open()
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to read upload", err)
+			return false
+		}
+		contents[i], err = io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to read upload", err)
+			return false
+		}
+		switch {
+		case len(contents[i]) == 0:
+			problems.add(upload.field, "is empty")
+		case upload.check != nil:
+			if err := upload.check(contents[i]); err != nil {
+				problems.add(upload.field, err.Error())
+			}
+		}
+		if upload.field == "wasm_file" && req.Name == "" {
+			req.Name = contractName(files[0].Filename)
+		}
+	}
+	if len(problems) > 0 {
+		respondError(c, http.StatusBadRequest, CodeValidationFailed, "Invalid request", nil, gin.H{"fields": problems})
+		return false
+	}
+
+	for i, upload := range uploads {
+		path, err := storeArtifact(contents[i], upload.ext)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to store contract files", err)
+			return false
+		}
+		*upload.path = path
+	}
+	return true
+}
+
+// parseUploadForm reads the multipart form of a request, at most
+// maxDeployUpload of it. A form already read is returned as it is. On
+// failure the error response has been written and it returns false.
+func parseUploadForm(c *gin.Context) (*multipart.Form, bool) {
+	if c.Request.MultipartForm != nil {
+		return c.Request.MultipartForm, true
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxDeployUpload)
+	form, err := c.MultipartForm()
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondError(c, http.StatusRequestEntityTooLarge, CodeUploadTooLarge,
+				fmt.Sprintf("Upload is larger than %d MiB", maxDeployUpload>>20), err)
+			return nil, false
+		}
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid multipart form", err)
+		return nil, false
+	}
+	return form, true
+}
+
+// storeArtifact writes data under ARTIFACT_DIR as <digest><ext>, in a
+// directory named by the digest's first two characters, and returns its
+// path. A file already stored is left as it is.
+func storeArtifact(data []byte, ext string) (string, error) {
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+	dir := filepath.Join(artifactDir(), digest[:2])
+	path := filepath.Join(dir, digest+ext)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create artifact directory: %w", err)
+	}
+	// Write beside the final path and rename, so a reader never sees part
+	// of a file
+	tmp, err := os.CreateTemp(dir, digest+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create artifact: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write artifact: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write artifact: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to store artifact: %w", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dapp-server/database"
+	rubix_interaction "dapp-server/rubix-interaction"
+)
+
+// contractWasm builds a wasm module with the contract ABI that exports each
+// of functions, taking no arguments and doing nothing
+func contractWasm(functions ...string) []byte {
+	section := func(id byte, content ...byte) []byte {
+		return append([]byte{id, byte(len(content))}, content...)
+	}
+	exports := []string{"alloc", "dealloc"}
+	for _, function := range functions {
+		exports = append(exports, rubix_interaction.ContractFunctionExport(function))
+	}
+	funcs := []byte{byte(len(exports))}
+	code := []byte{byte(len(exports))}
+	exportSection := []byte{byte(len(exports) + 1), 6}
+	exportSection = append(append(exportSection, "memory"...), 2, 0)
+	for i, name := range exports {
+		funcs = append(funcs, 0)
+		code = append(code, 2, 0, 0x0b)
+		exportSection = append(append(append(exportSection, byte(len(name))), name...), 0, byte(i))
+	}
+	module := []byte("\x00asm\x01\x00\x00\x00")
+	module = append(module, section(1, 1, 0x60, 0, 0)...)
+	module = append(module, section(3, funcs...)...)
+	module = append(module, section(5, 1, 0, 1)...)
+	module = append(module, section(7, exportSection...)...)
+	return append(module, section(10, code...)...)
+}
+
+// postDeployUpload posts the multipart form of a deploy with fields and the
+// contract files by field
+func postDeployUpload(t *testing.T, fields map[string][]string, files map[string][]byte) (int, map[string]interface{}) {
+	t.Helper()
+	var payload bytes.Buffer
+	writer := multipart.NewWriter(&payload)
+	for field, values := range fields {
+		for _, value := range values {
+			writer.WriteField(field, value)
+		}
+	}
+	for field, content := range files {
+		part, err := writer.CreateFormFile(field, map[string]string{
+			"wasm_file": "add_admin_v2.wasm", "lib_file": "lib.rs", "state_file": "state.json",
+		}[field])
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(content)
+	}
+	writer.Close()
+	resp, err := apiClient.Post(apiServer.URL+"/api/deploy-contract", writer.FormDataContentType(), &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, decodeBody(t, resp)
+}
+
+func TestDeployContractUpload(t *testing.T) {
+	node := startNode(t)
+	fields := map[string][]string{
+		"deployer_did":      {testAdminDID},
+		"role":              {database.ContractRoleAdmin},
+		"callback_endpoint": {"api/callback/add-admin"},
+	}
+	wasm := contractWasm("add_admin", "remove_admin")
+	files := map[string][]byte{
+		"wasm_file":  wasm,
+		"lib_file":   []byte("pub fn add_admin() {}"),
+		"state_file": []byte("{}"),
+	}
+
+	status, body := postDeployUpload(t, fields, files)
+	registered, _ := body["contract"].(map[string]interface{})
+	hash, _ := registered["contract_hash"].(string)
+	if status != http.StatusOK || hash == "" {
+		t.Fatalf("upload deploy = %d, %v", status, body)
+	}
+	if !node.Deployed(hash) || node.CallbackURL(hash) == "" {
+		t.Fatalf("contract %s not deployed with its callback", hash)
+	}
+	digest := sha256.Sum256(wasm)
+	wasmDigest := fmt.Sprintf("%x", digest)
+	if registered["name"] != "add_admin_v2" || registered["role"] != database.ContractRoleAdmin || registered["wasm_digest"] != wasmDigest {
+		t.Fatalf("registered contract = %v", registered)
+	}
+	stored, err := os.ReadFile(filepath.Join(defaultArtifactDir, wasmDigest[:2], wasmDigest+".wasm"))
+	if err != nil || !bytes.Equal(stored, wasm) {
+		t.Fatalf("stored wasm = %d bytes, %v", len(stored), err)
+	}
+
+	// Uploading the same files again finds them stored
+	if status, body := postDeployUpload(t, fields, files); status != http.StatusOK {
+		t.Fatalf("second upload deploy = %d, %v", status, body)
+	}
+
+	for name, tc := range map[string]struct {
+		field   string
+		content []byte
+		want    string
+	}{
+		"not wasm":            {"wasm_file", []byte("pub fn add_admin() {}"), "not a valid wasm module"},
+		"missing function":    {"wasm_file", contractWasm("add_admin"), "remove_admin_"},
+		"state is not JSON":   {"state_file", []byte("state"), "JSON"},
+		"lib is not uploaded": {"lib_file", nil, "is required"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			upload := map[string][]byte{}
+			for field, content := range files {
+				upload[field] = content
+			}
+			if tc.content == nil {
+				delete(upload, tc.field)
+			} else {
+				upload[tc.field] = tc.content
+			}
+			status, body := postDeployUpload(t, fields, upload)
+			problems, _ := body["fields"].(map[string]interface{})
+			problem, _ := problems[tc.field].(string)
+			if status != http.StatusBadRequest || !strings.Contains(problem, tc.want) {
+				t.Fatalf("deploy = %d, %v, want %s %q", status, body, tc.field, tc.want)
+			}
+		})
+	}
+}
+
+func TestContractFunctionsFollowTheRole(t *testing.T) {
+	if functions := contractFunctions(database.ContractRoleAdmin); strings.Join(functions, ",") != "add_admin,remove_admin" {
+		t.Fatalf("admin functions = %v", functions)
+	}
+	if functions := contractFunctions(""); len(functions) != 0 {
+		t.Fatalf("functions of a contract without a role = %v", functions)
+	}
+	for role := range roleFunctions {
+		functions := contractFunctions(role)
+		if err := rubix_interaction.ValidateContractWasm(contractWasm(functions...), functions); err != nil {
+			t.Errorf("%s contract: %v", role, err)
+		}
+		if err := rubix_interaction.ValidateContractWasm(contractWasm(), functions); err == nil {
+			t.Errorf("%s contract without its functions passed", role)
+		}
+	}
+}
@@ -0,0 +1,460 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"dapp-server/config"
+	"dapp-server/database"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Roles a caller can hold. Staff tools get theirs from their API key; a DID
+// that logs in is a user, and an admin too while it is a current admin.
+const (
+	RoleAdmin = "admin"
+	RoleStaff = "staff"
+	RoleUser  = "user"
+)
+
+const (
+	apiKeyHeader       = "X-API-Key"
+	principalKey       = "principal"
+	loginChallengeTTL  = 5 * time.Minute
+	defaultSessionTTL  = 15 * time.Minute
+	apiKeyPrefix       = "dk_"
+	sessionTokenPrefix = "ds_"
+)
+
+// Challenges are issued to anyone, so how many can be outstanding is
+// bounded, in all and per DID
+const (
+	maxLoginChallenges       = 10000
+	maxLoginChallengesPerDID = 5
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	// Name is the API key name, or the DID of a session
+	Name string `json:"name"`
+	// DID is the DID the caller acts as: the session's, or the one its API
+	// key is bound to
+	DID   string   `json:"did,omitempty"`
+	Roles []string `json:"roles"`
+}
+
+// HasRole reports whether the principal holds any of roles
+func (p *Principal) HasRole(roles ...string) bool {
+	for _, held := range p.Roles {
+		for _, role := range roles {
+			if held == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// currentPrincipal returns the caller Authenticate identified, or nil
+func currentPrincipal(c *gin.Context) *Principal {
+	value, ok := c.Get(principalKey)
+	if !ok {
+		return nil
+	}
+	return value.(*Principal)
+}
+
+// hashToken is how API keys and session tokens are stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newToken(prefix string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// CreateAPIKey issues a key for a staff tool and returns it. Only its hash
+// is kept, so the key cannot be shown again. A key bound to did acts as
+// that DID; only a bound key can act as an admin, and only while its DID is
+// a current admin.
+func CreateAPIKey(name string, roles []string, did string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("api key needs a name")
+	}
+	if did != "" && !didPattern.MatchString(did) {
+		return "", fmt.Errorf("%q is not a Rubix DID", did)
+	}
+	if len(roles) == 0 {
+		return "", fmt.Errorf("api key needs at least one role")
+	}
+	for _, role := range roles {
+		if role != RoleAdmin && role != RoleStaff && role != RoleUser {
+			return "", fmt.Errorf("unknown role %q (want %s, %s or %s)", role, RoleAdmin, RoleStaff, RoleUser)
+		}
+	}
+	key, err := newToken(apiKeyPrefix)
+	if err != nil {
+		return "", err
+	}
+	if err := database.CreateAPIKey(name, hashToken(key), roles, did); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// Authenticate identifies the caller from an X-API-Key header or an
+// Authorization: Bearer session token. Requests without credentials go on
+// anonymously and are stopped by RequireRole where a role is needed;
+// requests with credentials that do not check out are rejected here.
+func Authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var principal *Principal
+		var err error
+		if key := c.GetHeader(apiKeyHeader); key != "" {
+			principal, err = apiKeyPrincipal(key)
+		} else if token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); found {
+			principal, err = sessionPrincipal(token)
+		} else {
+			c.Next()
+			return
+		}
+		if err != nil {
+			fmt.Printf("Failed to authenticate request: %v\n", err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to authenticate request", nil)
+			return
+		}
+		if principal == nil {
+			respondError(c, http.StatusUnauthorized, CodeUnauthenticated, "Invalid or expired credentials", nil)
+			return
+		}
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
+
+func apiKeyPrincipal(key string) (*Principal, error) {
+	apiKey, err := database.GetAPIKeyByHash(hashToken(key))
+	if err != nil || apiKey == nil {
+		return nil, err
+	}
+	roles, err := apiKeyRoles(apiKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{Name: apiKey.Name, DID: apiKey.DID, Roles: roles}, nil
+}
+
+// apiKeyRoles returns the roles of a key. Like a session's, its admin role
+// holds only while the DID it is bound to is a current admin, so a key
+// bound to no DID, or to one removed as admin, is not an admin.
+func apiKeyRoles(apiKey *database.APIKey) ([]string, error) {
+	roles := make([]string, 0, len(apiKey.Roles))
+	for _, role := range apiKey.Roles {
+		if role == RoleAdmin {
+			if apiKey.DID == "" {
+				continue
+			}
+			isAdmin, err := getAdminSet().IsAdmin(apiKey.DID)
+			if err != nil {
+				return nil, err
+			}
+			if !isAdmin {
+				continue
+			}
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+func sessionPrincipal(token string) (*Principal, error) {
+	session, err := database.GetSession(hashToken(token))
+	if err != nil || session == nil {
+		return nil, err
+	}
+	roles, err := didRoles(session.DID)
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{Name: session.DID, DID: session.DID, Roles: roles}, nil
+}
+
+// didRoles is evaluated on every request, so a DID removed as admin loses
+// the role without logging out
+func didRoles(did string) ([]string, error) {
+	isAdmin, err := getAdminSet().IsAdmin(did)
+	if err != nil {
+		return nil, err
+	}
+	if isAdmin {
+		return []string{RoleUser, RoleAdmin}, nil
+	}
+	return []string{RoleUser}, nil
+}
+
+// RequireRole lets a request through only if the caller holds one of roles
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal := currentPrincipal(c)
+		if principal == nil {
+			respondError(c, http.StatusUnauthorized, CodeUnauthenticated, "Authentication required",
+				fmt.Errorf("send an %s header or a Bearer session token", apiKeyHeader))
+			return
+		}
+		if !principal.HasRole(roles...) {
+			respondError(c, http.StatusForbidden, CodeForbidden, "Insufficient role",
+				fmt.Errorf("requires one of: %s", strings.Join(roles, ", ")))
+			return
+		}
+		c.Next()
+	}
+}
+
+// loginChallenge is a nonce handed to a DID to sign. It can be used once.
+type loginChallenge struct {
+	DID       string
+	Message   string
+	ExpiresAt time.Time
+}
+
+var loginChallenges = struct {
+	sync.Mutex
+	byNonce map[string]loginChallenge
+}{byNonce: make(map[string]loginChallenge)}
+
+type ChallengeRequest struct {
+	DID string `json:"did"`
+}
+
+func (r ChallengeRequest) Validate() FieldErrors {
+	problems := FieldErrors{}
+	problems.DID("did", r.DID)
+	return problems
+}
+
+type LoginRequest struct {
+	DID   string `json:"did"`
+	Nonce string `json:"nonce"`
+	// Signature is the base64 signature of the challenge message: plain
+	// Ed25519, or ASN.1 ECDSA P-256 over its SHA-256
+	Signature string `json:"signature"`
+}
+
+func (r LoginRequest) Validate() FieldErrors {
+	problems := FieldErrors{}
+	problems.DID("did", r.DID)
+	problems.Required("nonce", r.Nonce)
+	problems.Required("signature", r.Signature)
+	return problems
+}
+
+type DIDKeyRequest struct {
+	// PublicKey is a base64 PKIX DER Ed25519 or P-256 public key
+	PublicKey string `json:"public_key"`
+}
+
+// APIAuthChallenge issues a login challenge for a DID. Once a DID, or the
+// server, has too many unused challenges it answers 429 until some expire.
+func APIAuthChallenge(c *gin.Context) {
+	var req ChallengeRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	nonce, err := newToken("")
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to create challenge", err)
+		return
+	}
+	challenge := loginChallenge{
+		DID:       req.DID,
+		ExpiresAt: time.Now().Add(loginChallengeTTL),
+	}
+	challenge.Message = fmt.Sprintf("dapp-server login\ndid: %s\nnonce: %s\nexpires: %s",
+		req.DID, nonce, challenge.ExpiresAt.UTC().Format(time.RFC3339))
+
+	loginChallenges.Lock()
+	outstanding := 0
+	for key, issued := range loginChallenges.byNonce {
+		if time.Now().After(issued.ExpiresAt) {
+			delete(loginChallenges.byNonce, key)
+		} else if issued.DID == req.DID {
+			outstanding++
+		}
+	}
+	if outstanding >= maxLoginChallengesPerDID || len(loginChallenges.byNonce) >= maxLoginChallenges {
+		loginChallenges.Unlock()
+		c.Header("Retry-After", strconv.Itoa(int(loginChallengeTTL.Seconds())))
+		respondError(c, http.StatusTooManyRequests, CodeTooManyRequests, "Too many outstanding login challenges", nil)
+		return
+	}
+	loginChallenges.byNonce[nonce] = challenge
+	loginChallenges.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"nonce":      nonce,
+		"message":    challenge.Message,
+		"expires_at": challenge.ExpiresAt,
+	})
+}
+
+// APIAuthLogin exchanges a signed challenge for a session token
+func APIAuthLogin(c *gin.Context) {
+	var req LoginRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+
+	loginChallenges.Lock()
+	challenge, found := loginChallenges.byNonce[req.Nonce]
+	delete(loginChallenges.byNonce, req.Nonce)
+	loginChallenges.Unlock()
+	if !found || challenge.DID != req.DID || time.Now().After(challenge.ExpiresAt) {
+		respondError(c, http.StatusUnauthorized, CodeUnauthenticated, "Unknown or expired challenge", nil)
+		return
+	}
+
+	didKey, err := database.GetDIDKey(req.DID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to load DID key", err)
+		return
+	}
+	if didKey == nil {
+		respondError(c, http.StatusUnauthorized, CodeUnauthenticated, "No login key is registered for this DID", nil)
+		return
+	}
+	if err := verifyDIDSignature(didKey.PublicKey, []byte(challenge.Message), req.Signature); err != nil {
+		fmt.Printf("Login rejected for %s: %v\n", req.DID, err)
+		respondError(c, http.StatusUnauthorized, CodeUnauthenticated, "Invalid signature", nil)
+		return
+	}
+
+	token, err := newToken(sessionTokenPrefix)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to create session", err)
+		return
+	}
+	now := time.Now()
+	session := database.Session{
+		TokenHash: hashToken(token),
+		DID:       req.DID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(sessionTTL()),
+	}
+	if err := database.CreateSession(session); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to create session", err)
+		return
+	}
+	roles, err := didRoles(req.DID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to load roles", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"token":      token,
+		"did":        req.DID,
+		"roles":      roles,
+		"expires_at": session.ExpiresAt,
+	})
+}
+
+// APIAuthLogout ends the session the request was made with
+func APIAuthLogout(c *gin.Context) {
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !found {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "No session token", nil)
+		return
+	}
+	if err := database.DeleteSession(hashToken(token)); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to end session", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": true, "message": "Logged out"})
+}
+
+// APISetDIDKey registers the public key a DID logs in with
+func APISetDIDKey(c *gin.Context) {
+	did := c.Param("did")
+	if !validDIDParam(c, did) {
+		return
+	}
+	var req DIDKeyRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	if _, err := parseDIDPublicKey(req.PublicKey); err != nil {
+		respondError(c, http.StatusBadRequest, CodeValidationFailed, "Invalid public key", err, gin.H{"fields": FieldErrors{"public_key": err.Error()}})
+		return
+	}
+	if err := database.SetDIDKey(did, req.PublicKey); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to store DID key", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": true, "message": "DID key registered"})
+}
+
+// parseDIDPublicKey accepts Ed25519 and ECDSA P-256 keys
+func parseDIDPublicKey(encoded string) (interface{}, error) {
+	der, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("public key is not base64: %w", err)
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	switch key := key.(type) {
+	case ed25519.PublicKey:
+		return key, nil
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported ECDSA curve %s", key.Curve.Params().Name)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+func verifyDIDSignature(encodedKey string, message []byte, encodedSignature string) error {
+	key, err := parseDIDPublicKey(encodedKey)
+	if err != nil {
+		return err
+	}
+	signature, err := base64.StdEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return fmt.Errorf("signature is not base64: %w", err)
+	}
+	switch key := key.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, message, signature) {
+			return fmt.Errorf("ed25519 signature does not verify")
+		}
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(message)
+		if !ecdsa.VerifyASN1(key, digest[:], signature) {
+			return fmt.Errorf("ecdsa signature does not verify")
+		}
+	}
+	return nil
+}
+
+func sessionTTL() time.Duration {
+	return parseDurationOr("SESSION_TTL", config.GetEnvConfig().SessionTTL, defaultSessionTTL)
+}
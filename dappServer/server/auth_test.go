@@ -0,0 +1,263 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"dapp-server/database"
+)
+
+// loginAs registers a key for did and logs in with a signed challenge
+func loginAs(t *testing.T, did string, publicKey interface{}, sign func(message []byte) []byte) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, body := postJSON(t, "/api/auth/challenge", ChallengeRequest{DID: did}); status != http.StatusOK {
+		t.Fatalf("challenge before key = %d, %v", status, body)
+	}
+	status, body := callWith(t, http.MethodPut, "/api/auth/keys/"+did, "", "", DIDKeyRequest{PublicKey: base64.StdEncoding.EncodeToString(der)})
+	if status != http.StatusUnauthorized {
+		t.Fatalf("anonymous key registration = %d, %v", status, body)
+	}
+	req, _ := http.NewRequest(http.MethodPut, apiServer.URL+"/api/auth/keys/"+did,
+		strings.NewReader(`{"public_key":"`+base64.StdEncoding.EncodeToString(der)+`"}`))
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("register key = %d", resp.StatusCode)
+	}
+
+	_, challenge := postJSON(t, "/api/auth/challenge", ChallengeRequest{DID: did})
+	nonce, _ := challenge["nonce"].(string)
+	message, _ := challenge["message"].(string)
+	login := LoginRequest{DID: did, Nonce: nonce, Signature: base64.StdEncoding.EncodeToString(sign([]byte(message)))}
+	status, body = postJSON(t, "/api/auth/login", login)
+	if status != http.StatusOK {
+		t.Fatalf("login = %d, %v", status, body)
+	}
+	if status, _ := postJSON(t, "/api/auth/login", login); status != http.StatusUnauthorized {
+		t.Fatalf("replayed challenge = %d", status)
+	}
+	token, _ := body["token"].(string)
+	return token
+}
+
+func TestCallerAuthentication(t *testing.T) {
+	node := startNode(t)
+	registerCallback(t, node, testTransferContract, "api/call-back-trigger")
+	before := len(node.Blocks(testTransferContract))
+	transfer := TransferRewardRequest{ActivityID: []string{"open-gym"}, UserDID: testUserDID, AdminDID: testAdminDID}
+
+	if status, body := callWith(t, http.MethodPost, "/api/rewards/transfer", "", "", transfer); status != http.StatusUnauthorized {
+		t.Fatalf("anonymous transfer = %d, %v", status, body)
+	}
+	if status, body := callWith(t, http.MethodPost, "/api/rewards/transfer", apiKeyHeader, "dk_forged", transfer); status != http.StatusUnauthorized {
+		t.Fatalf("forged key = %d, %v", status, body)
+	}
+	if status, _ := callWith(t, http.MethodGet, "/api/activities", "", "", nil); status != http.StatusOK {
+		t.Fatalf("public catalog = %d", status)
+	}
+
+	staffKey, err := CreateAPIKey("front-desk", []string{RoleStaff}, testAdminDID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, body := callWith(t, http.MethodPost, "/api/execute-contract", apiKeyHeader, staffKey, ExecuteRequest{ContractHash: testTransferContract}); status != http.StatusForbidden {
+		t.Fatalf("staff execute = %d, %v", status, body)
+	}
+	if revoked, err := database.RevokeAPIKey("front-desk"); err != nil || !revoked {
+		t.Fatalf("revoke = %v, %v", revoked, err)
+	}
+	if status, _ := callWith(t, http.MethodPost, "/api/rewards/transfer", apiKeyHeader, staffKey, transfer); status != http.StatusUnauthorized {
+		t.Fatalf("revoked key = %d", status)
+	}
+	if after := len(node.Blocks(testTransferContract)); after != before {
+		t.Fatalf("an unauthorized call executed the contract: %d blocks, was %d", after, before)
+	}
+
+	// A user DID logs in with Ed25519 and can read but not transfer
+	userPublic, userPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	userToken := loginAs(t, testUserDID, userPublic, func(message []byte) []byte {
+		return ed25519.Sign(userPrivate, message)
+	})
+	bearer := func(token string) string { return "Bearer " + token }
+	if status, _ := callWith(t, http.MethodGet, "/api/rewards/status/no-such-transfer", "Authorization", bearer(userToken), nil); status != http.StatusNotFound {
+		t.Fatalf("user status lookup = %d", status)
+	}
+	if status, _ := callWith(t, http.MethodPost, "/api/rewards/transfer", "Authorization", bearer(userToken), transfer); status != http.StatusForbidden {
+		t.Fatalf("user transfer = %d", status)
+	}
+
+	// An admin DID logs in with P-256 and may only act as itself
+	adminKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	adminToken := loginAs(t, testAdminDID, &adminKey.PublicKey, func(message []byte) []byte {
+		digest := sha256.Sum256(message)
+		signature, _ := ecdsa.SignASN1(rand.Reader, adminKey, digest[:])
+		return signature
+	})
+	impersonation := AddAdminRequest{NewAdminDID: "bafybmiimpersonated", ExistingAdminDID: "bafybmireaderadmin"}
+	if status, body := callWith(t, http.MethodPost, "/api/admin/add", "Authorization", bearer(adminToken), impersonation); status != http.StatusForbidden {
+		t.Fatalf("admin acting as another DID = %d, %v", status, body)
+	}
+	if status, body := callWith(t, http.MethodPost, "/api/rewards/transfer", "Authorization", bearer(adminToken), transfer); status != http.StatusOK {
+		t.Fatalf("admin session transfer = %d, %v", status, body)
+	}
+
+	if status, _ := callWith(t, http.MethodPost, "/api/auth/logout", "Authorization", bearer(adminToken), nil); status != http.StatusOK {
+		t.Fatalf("logout = %d", status)
+	}
+	if status, _ := callWith(t, http.MethodGet, "/api/admins", "Authorization", bearer(adminToken), nil); status != http.StatusUnauthorized {
+		t.Fatalf("token after logout = %d", status)
+	}
+}
+
+func TestAPIKeyActsOnlyAsItsDID(t *testing.T) {
+	startNode(t)
+	unbound, err := CreateAPIKey("unbound-admin-tool", []string{RoleAdmin}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := CreateAPIKey("other-admin-tool", []string{RoleAdmin}, "bafybmiotheradmintool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateAPIKey("bad-did-tool", []string{RoleAdmin}, "not-a-did"); err == nil {
+		t.Fatal("key bound to a malformed DID was created")
+	}
+
+	calls := map[string]interface{}{
+		"/api/activity/add":     AddActivityRequest{ActivityID: "spoofed-run", RewardPoints: 10, AdminDID: testAdminDID},
+		"/api/execute-contract": ExecuteRequest{ContractHash: testTransferContract, ExecutorDid: testAdminDID, ContractInput: "{}"},
+		"/api/deploy-contract":  DeployRequest{WasmPath: "contract.wasm", LibPath: "lib.rs", StatePath: "state.json", DeployerDid: testAdminDID},
+	}
+	for name, key := range map[string]string{"unbound": unbound, "bound to another DID": other} {
+		for path, body := range calls {
+			if status, body := callWith(t, http.MethodPost, path, apiKeyHeader, key, body); status != http.StatusForbidden {
+				t.Fatalf("%s key acting as %s on %s = %d, %v", name, testAdminDID, path, status, body)
+			}
+		}
+	}
+
+	// Nor can an admin run the transfer contract around the claims ledger
+	if status, body := postJSON(t, "/api/execute-contract", ExecuteRequest{ContractHash: testTransferContract, ExecutorDid: testAdminDID, ContractInput: "{}"}); status != http.StatusForbidden {
+		t.Fatalf("direct transfer contract run = %d, %v", status, body)
+	}
+
+	// An admin's own key cannot act as another configured DID either
+	for path, body := range map[string]interface{}{
+		"/api/execute-contract": ExecuteRequest{ContractHash: testTransferContract, ExecutorDid: "bafybmiotheradmintool", ContractInput: "{}"},
+		"/api/deploy-contract":  DeployRequest{WasmPath: "contract.wasm", LibPath: "lib.rs", StatePath: "state.json", DeployerDid: "bafybmiotheradmintool"},
+	} {
+		if status, body := postJSON(t, path, body); status != http.StatusForbidden {
+			t.Fatalf("%s as another DID = %d, %v", path, status, body)
+		}
+	}
+}
+
+func TestAPIKeyAdminRoleFollowsTheAdminSet(t *testing.T) {
+	const did = "bafybmikeyadminlapses"
+	key, err := CreateAPIKey("lapsing-admin-tool", []string{RoleAdmin}, did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, body := callWith(t, http.MethodGet, "/api/admins", apiKeyHeader, key, nil); status != http.StatusForbidden {
+		t.Fatalf("key of a DID that is not an admin = %d, %v", status, body)
+	}
+	if _, err := database.AddAdmin(did); err != nil {
+		t.Fatal(err)
+	}
+	getAdminSet().Invalidate()
+	if status, body := callWith(t, http.MethodGet, "/api/admins", apiKeyHeader, key, nil); status != http.StatusOK {
+		t.Fatalf("key of an admin = %d, %v", status, body)
+	}
+	if _, err := database.RemoveAdmin(did); err != nil {
+		t.Fatal(err)
+	}
+	getAdminSet().Invalidate()
+	if status, body := callWith(t, http.MethodGet, "/api/admins", apiKeyHeader, key, nil); status != http.StatusForbidden {
+		t.Fatalf("key of a removed admin = %d, %v", status, body)
+	}
+}
+
+func TestTransferStatusIsScopedToItsParties(t *testing.T) {
+	const receiver, statusAdmin = "bafybmistatusreceiver", "bafybmistatusadmin"
+	requestID := createTransfer(t, receiver)
+	if _, err := database.AddAdmin(statusAdmin); err != nil {
+		t.Fatal(err)
+	}
+	getAdminSet().Invalidate()
+	t.Cleanup(func() {
+		database.RemoveAdmin(statusAdmin)
+		getAdminSet().Invalidate()
+	})
+	key := func(name string, role string, did string) string {
+		t.Helper()
+		key, err := CreateAPIKey(name, []string{role}, did)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	for name, tc := range map[string]struct {
+		key  string
+		want int
+	}{
+		"receiver":           {key("status-receiver", RoleUser, receiver), http.StatusOK},
+		"issuing admin":      {key("status-issuer", RoleStaff, testAdminDID), http.StatusOK},
+		"admin":              {key("status-admin", RoleAdmin, statusAdmin), http.StatusOK},
+		"another user":       {key("status-other", RoleUser, testUserDID), http.StatusNotFound},
+		"user bound to none": {key("status-unbound", RoleUser, ""), http.StatusNotFound},
+	} {
+		if status, body := callWith(t, http.MethodGet, "/api/rewards/status/"+requestID, apiKeyHeader, tc.key, nil); status != tc.want {
+			t.Errorf("%s reading the status = %d, %v, want %d", name, status, body, tc.want)
+		}
+	}
+}
+
+func TestLoginChallengesAreCapped(t *testing.T) {
+	const did = "bafybmichallengeflood"
+	for i := 0; i < maxLoginChallengesPerDID; i++ {
+		if status, body := postJSON(t, "/api/auth/challenge", ChallengeRequest{DID: did}); status != http.StatusOK {
+			t.Fatalf("challenge %d = %d, %v", i+1, status, body)
+		}
+	}
+	if status, body := postJSON(t, "/api/auth/challenge", ChallengeRequest{DID: did}); status != http.StatusTooManyRequests || body["code"] != string(CodeTooManyRequests) {
+		t.Fatalf("challenge past the DID's cap = %d, %v", status, body)
+	}
+
+	// Fill the rest of the table; no DID gets another one
+	loginChallenges.Lock()
+	expires := time.Now().Add(loginChallengeTTL)
+	for i := len(loginChallenges.byNonce); i < maxLoginChallenges; i++ {
+		loginChallenges.byNonce[fmt.Sprintf("flood-%d", i)] = loginChallenge{DID: fmt.Sprintf("bafybmiflood%d", i), ExpiresAt: expires}
+	}
+	loginChallenges.Unlock()
+	t.Cleanup(func() {
+		loginChallenges.Lock()
+		for nonce, challenge := range loginChallenges.byNonce {
+			if strings.HasPrefix(nonce, "flood-") || challenge.DID == did {
+				delete(loginChallenges.byNonce, nonce)
+			}
+		}
+		loginChallenges.Unlock()
+	})
+	if status, body := postJSON(t, "/api/auth/challenge", ChallengeRequest{DID: testUserDID}); status != http.StatusTooManyRequests {
+		t.Fatalf("challenge with a full table = %d, %v", status, body)
+	}
+}
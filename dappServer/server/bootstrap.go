@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"dapp-server/config"
+	"dapp-server/database"
+	rubix_interaction "dapp-server/rubix-interaction"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// BootstrapResult is what Bootstrap did for a contract of the manifest.
+// Callbacks holds the registrations it made; those already in place are
+// not repeated.
+type BootstrapResult struct {
+	Name         string
+	Role         string
+	ContractHash string
+	Version      int
+	Deployed     bool
+	Promoted     bool
+	Callbacks    []rubix_interaction.CallbackResult
+}
+
+// Bootstrap brings the contract registry in line with manifest. A contract
+// is deployed unless the registry has one of its role generated from the
+// same files, a contract with a role is made the role's active version, and
+// its callback URL is registered with each of its nodes that does not have
+// it at the current public base URL. Running it again changes nothing. The
+// whole manifest is checked before anything is deployed; the results cover
+// the contracts handled before an error.
+func Bootstrap(ctx context.Context, manifest *config.ContractManifest) ([]BootstrapResult, error) {
+	cfg, err := config.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err := validateManifest(cfg, manifest); err != nil {
+		return nil, err
+	}
+	results := make([]BootstrapResult, 0, len(manifest.Contracts))
+	for _, contract := range manifest.Contracts {
+		result, err := bootstrapContract(ctx, cfg, contract)
+		if result != nil {
+			results = append(results, *result)
+		}
+		if err != nil {
+			return results, fmt.Errorf("%s: %w", contract.Name, err)
+		}
+	}
+	return results, nil
+}
+
+// validateManifest checks every contract of manifest the way a deploy
+// request is checked, and that no two contracts share a name or role
+func validateManifest(cfg *config.Config, manifest *config.ContractManifest) error {
+	if len(manifest.Contracts) == 0 {
+		return fmt.Errorf("manifest lists no contracts")
+	}
+	names := map[string]bool{}
+	roles := map[string]string{}
+	for i, contract := range manifest.Contracts {
+		problems := FieldErrors{}
+		problems.ID("name", contract.Name)
+		if names[contract.Name] {
+			problems.add("name", "is given to another contract")
+		}
+		if other, taken := roles[contract.Role]; contract.Role != "" && taken {
+			problems.add("role", "is already given to "+other)
+		}
+		for field, path := range map[string]string{"wasm": contract.Wasm, "lib": contract.Lib, "state": contract.State} {
+			problems.Required(field, path)
+			if _, err := os.Stat(path); path != "" && err != nil {
+				problems.add(field, "is not a readable file")
+			}
+		}
+		DeployRequest{
+			DeployerDid:      contract.DeployerDID,
+			Role:             contract.Role,
+			CallbackEndpoint: contract.CallbackEndpoint,
+			CallbackNodes:    contract.CallbackNodes,
+		}.validateContract(problems)
+		if _, exists := config.GetPortByDid(cfg, contract.DeployerDID); !exists {
+			problems.add("deployer_did", "is not the DID of a configured node")
+		}
+		if _, unknown := nodePorts(cfg, contract.CallbackNodes); unknown != "" {
+			problems.add("callback_nodes", unknown+" is neither the name nor the port of a configured node")
+		}
+		if _, problem := problems["wasm"]; !problem {
+			wasm, err := os.ReadFile(contract.Wasm)
+			if err == nil {
+				err = rubix_interaction.ValidateContractWasm(wasm, contractFunctions(contract.Role))
+			}
+			if err != nil {
+				problems.add("wasm", err.Error())
+			}
+		}
+
+		if len(problems) > 0 {
+			fields := make([]string, 0, len(problems))
+			for field, problem := range problems {
+				fields = append(fields, field+" "+problem)
+			}
+			sort.Strings(fields)
+			return fmt.Errorf("contract %d (%s) of the manifest: %s", i+1, contract.Name, strings.Join(fields, "; "))
+		}
+		names[contract.Name] = true
+		if contract.Role != "" {
+			roles[contract.Role] = contract.Name
+		}
+	}
+	return nil
+}
+
+// bootstrapContract deploys, promotes and registers the callbacks of one
+// contract of the manifest, as far as each is still needed
+func bootstrapContract(ctx context.Context, cfg *config.Config, entry config.ManifestContract) (*BootstrapResult, error) {
+	contract := database.ContractRecord{Name: entry.Name, Role: entry.Role, DeployerDID: entry.DeployerDID}
+	for _, file := range []struct {
+		path   string
+		digest *string
+	}{
+		{entry.Wasm, &contract.WasmDigest},
+		{entry.Lib, &contract.LibDigest},
+		{entry.State, &contract.StateDigest},
+	} {
+		digest, err := fileDigest(file.path)
+		if err != nil {
+			return nil, err
+		}
+		*file.digest = digest
+	}
+	port, _ := config.GetPortByDid(cfg, entry.DeployerDID)
+
+	registered, err := registeredDeployment(contract)
+	if err != nil {
+		return nil, err
+	}
+	result := &BootstrapResult{Name: entry.Name, Role: entry.Role}
+	if registered == nil {
+		deployment, err := rubix_interaction.Deploy(ctx, newNodeClient(port), entry.Wasm, entry.Lib, entry.DeployerDID, entry.State)
+		if err != nil {
+			return nil, err
+		}
+		contract.ContractHash = deployment.ContractHash
+		contract.DeploymentBlock = deployment.DeploymentBlock
+		if registered, err = database.SaveContract(contract); err != nil {
+			return nil, fmt.Errorf("deployed %s but failed to register it: %w", deployment.ContractHash, err)
+		}
+		result.Deployed = true
+	}
+	result.ContractHash, result.Version = registered.ContractHash, registered.Version
+
+	if registered.Role != "" && !registered.Active {
+		if _, err := database.PromoteContract(registered.ContractHash); err != nil {
+			return result, err
+		}
+		result.Promoted = true
+	}
+
+	if entry.CallbackEndpoint == "" {
+		return result, nil
+	}
+	nodes := entry.CallbackNodes
+	if len(nodes) == 0 {
+		nodes = []string{port}
+	}
+	ports, _ := nodePorts(cfg, nodes)
+	endpoint := strings.TrimPrefix(entry.CallbackEndpoint, "/")
+	url := publicBaseURL() + "/" + endpoint
+	var targets []rubix_interaction.CallbackTarget
+	for _, callbackPort := range ports {
+		existing, err := database.GetCallbackRegistration(registered.ContractHash, callbackPort)
+		if err != nil {
+			return result, err
+		}
+		if existing != nil && existing.Endpoint == endpoint && existing.URL == url {
+			continue
+		}
+		targets = append(targets, callbackTarget(callbackPort, endpoint))
+	}
+	result.Callbacks = registerCallbacks(ctx, registered.ContractHash, targets)
+	if failed := rubix_interaction.FailedCallbacks(result.Callbacks); failed > 0 {
+		return result, fmt.Errorf("%d of %d node(s) refused the callback URL", failed, len(targets))
+	}
+	return result, nil
+}
+
+// registeredDeployment returns the registered contract of contract's role
+// generated from the same files, or nil if there is none
+func registeredDeployment(contract database.ContractRecord) (*database.ContractRecord, error) {
+	contracts, err := database.ListContracts()
+	if err != nil {
+		return nil, err
+	}
+	for _, registered := range contracts {
+		if registered.Role == contract.Role && registered.WasmDigest == contract.WasmDigest &&
+			registered.LibDigest == contract.LibDigest && registered.StateDigest == contract.StateDigest {
+			return &registered, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dapp-server/config"
+	"dapp-server/database"
+)
+
+func TestBootstrapFromManifest(t *testing.T) {
+	node := startNode(t)
+	t.Cleanup(func() {
+		if _, err := database.PromoteContract(testActivityContract); err != nil {
+			t.Error(err)
+		}
+	})
+	dir := t.TempDir()
+	for name, content := range map[string][]byte{
+		"activity.wasm": contractWasm("add_activity"),
+		"activity.rs":   []byte("pub fn add_activity() {}"),
+		"state.json":    []byte("{}"),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manifestPath := filepath.Join(dir, "contracts.toml")
+	writeManifest := func(role string) *config.ContractManifest {
+		t.Helper()
+		manifest := fmt.Sprintf(`[[contracts]]
+name = "activity_contract"
+role = "%s"
+wasm = "activity.wasm"
+lib = "activity.rs"
+state = "state.json"
+deployer_did = "%s"
+callback_endpoint = "api/callback/trigger"
+`, role, testAdminDID)
+		if err := os.WriteFile(manifestPath, []byte(manifest), 0644); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := config.LoadContractManifest(manifestPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return loaded
+	}
+
+	manifest := writeManifest(database.ContractRoleActivity)
+	results, err := Bootstrap(context.Background(), manifest)
+	if err != nil || len(results) != 1 || !results[0].Deployed || !results[0].Promoted || len(results[0].Callbacks) != 1 {
+		t.Fatalf("bootstrap = %+v, %v", results, err)
+	}
+	hash := results[0].ContractHash
+	if active, _ := database.ContractForRole(database.ContractRoleActivity); active == nil || active.ContractHash != hash {
+		t.Fatalf("active activity contract = %+v, want %s", active, hash)
+	}
+	if !strings.HasPrefix(node.CallbackURL(hash), apiServer.URL+"/api/callback/trigger?") {
+		t.Fatalf("callback URL = %q", node.CallbackURL(hash))
+	}
+	registration, _ := database.GetCallbackRegistration(hash, testPort)
+
+	// Running it again finds everything in place
+	results, err = Bootstrap(context.Background(), manifest)
+	if err != nil || len(results) != 1 || results[0].Deployed || results[0].Promoted || len(results[0].Callbacks) != 0 || results[0].ContractHash != hash {
+		t.Fatalf("second bootstrap = %+v, %v", results, err)
+	}
+	if again, _ := database.GetCallbackRegistration(hash, testPort); again == nil || again.Secret != registration.Secret {
+		t.Fatal("second bootstrap registered the callback again")
+	}
+
+	// A contract that cannot serve its role is rejected before deploying
+	if _, err := Bootstrap(context.Background(), writeManifest(database.ContractRoleTransfer)); err == nil || !strings.Contains(err.Error(), "transfer_sample_ft_") {
+		t.Fatalf("bootstrap with a wasm missing its role's function = %v", err)
+	}
+}
+
+func TestValidateManifestChecksEveryContract(t *testing.T) {
+	cfg := &config.Config{Nodes: map[string]config.Node{
+		"node1": {Name: "node1", Port: "20001", DID: testAdminDID},
+	}}
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"activity.wasm": contractWasm("add_activity"),
+		"activity.rs":   []byte("pub fn add_activity() {}"),
+		"state.json":    []byte("{}"),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	contract := func(name string, role string) config.ManifestContract {
+		return config.ManifestContract{
+			Name:        name,
+			Role:        role,
+			Wasm:        filepath.Join(dir, "activity.wasm"),
+			Lib:         filepath.Join(dir, "activity.rs"),
+			State:       filepath.Join(dir, "state.json"),
+			DeployerDID: testAdminDID,
+		}
+	}
+
+	valid := &config.ContractManifest{Contracts: []config.ManifestContract{contract("activity_contract", database.ContractRoleActivity)}}
+	if err := validateManifest(cfg, valid); err != nil {
+		t.Fatalf("valid manifest: %v", err)
+	}
+	if err := validateManifest(cfg, &config.ContractManifest{}); err == nil {
+		t.Fatal("empty manifest passed")
+	}
+
+	unknownNode := contract("activity_contract", database.ContractRoleActivity)
+	unknownNode.CallbackNodes = []string{"node9"}
+	missingLib := contract("activity_contract", database.ContractRoleActivity)
+	missingLib.Lib = filepath.Join(dir, "missing.rs")
+	for name, tc := range map[string]struct {
+		contracts []config.ManifestContract
+		want      string
+	}{
+		"same name":        {[]config.ManifestContract{contract("activity_contract", ""), contract("activity_contract", "")}, "contract 2 (activity_contract)"},
+		"same role":        {[]config.ManifestContract{contract("first", database.ContractRoleActivity), contract("second", database.ContractRoleActivity)}, "role is already given to first"},
+		"unknown node":     {[]config.ManifestContract{unknownNode}, "callback_nodes node9"},
+		"missing file":     {[]config.ManifestContract{missingLib}, "lib is not a readable file"},
+		"missing function": {[]config.ManifestContract{contract("admin_contract", database.ContractRoleAdmin)}, "add_admin_"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := validateManifest(cfg, &config.ContractManifest{Contracts: tc.contracts})
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("validate = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"dapp-server/config"
+	"dapp-server/database"
+	rubix_interaction "dapp-server/rubix-interaction"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Headers of a signed callback. The signature is the hex HMAC-SHA256, keyed
+// by the registration secret, of "<timestamp>.<nonce>.<body>".
+const (
+	callbackTimestampHeader = "X-Callback-Timestamp"
+	callbackNonceHeader     = "X-Callback-Nonce"
+	callbackSignatureHeader = "X-Callback-Signature"
+)
+
+// callbackMaxSkew is how far a signed callback's timestamp may be from now
+const callbackMaxSkew = 5 * time.Minute
+
+// VerifyCallback accepts a node callback only for a contract whose callback
+// was registered through registerCallbacks, on this endpoint, from a node
+// in the node config, and carrying the registration's secret: either as the
+// callback_token query parameter the registered URL contains, or as an HMAC
+// signature whose nonce has not been used before. The body is left intact
+// for the handler.
+func VerifyCallback() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body", err)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		var req ContractInputRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body", err)
+			return
+		}
+		reject := func(reason string) {
+			fmt.Printf("Rejected callback for %s from port %s: %s\n", req.SmartContractHash, req.Port, reason)
+			respondError(c, http.StatusForbidden, CodeCallbackRejected, "Callback rejected", errors.New(reason))
+		}
+
+		cfg, err := config.GetConfig()
+		if err != nil {
+			reject("node config is not loaded")
+			return
+		}
+		if _, known := config.GetNodeNameByPort(cfg, req.Port); !known {
+			reject("port is not a configured node")
+			return
+		}
+		registration, err := database.GetCallbackRegistration(req.SmartContractHash, req.Port)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to load callback registration", err)
+			return
+		}
+		if registration == nil {
+			reject("no callback is registered for this contract on this node")
+			return
+		}
+		if registration.Endpoint != strings.TrimPrefix(c.FullPath(), "/") {
+			reject("callback is registered for another endpoint")
+			return
+		}
+
+		if signature := c.GetHeader(callbackSignatureHeader); signature != "" {
+			if reason := verifyCallbackSignature(registration.Secret, c.Request.Header, body); reason != "" {
+				reject(reason)
+				return
+			}
+		} else if subtle.ConstantTimeCompare([]byte(c.Query(rubix_interaction.CallbackTokenParam)), []byte(registration.Secret)) != 1 {
+			reject("missing or wrong callback token")
+			return
+		}
+		c.Next()
+	}
+}
+
+// verifyCallbackSignature checks a signed callback and returns why it is
+// rejected, or "" if it is accepted
+func verifyCallbackSignature(secret string, header http.Header, body []byte) string {
+	timestamp := header.Get(callbackTimestampHeader)
+	nonce := header.Get(callbackNonceHeader)
+	if timestamp == "" || nonce == "" {
+		return "signed callback needs a timestamp and a nonce"
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "invalid callback timestamp"
+	}
+	skew := time.Since(time.Unix(seconds, 0))
+	if skew > callbackMaxSkew || skew < -callbackMaxSkew {
+		return "callback timestamp is out of range"
+	}
+
+	expected := SignCallback(secret, timestamp, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(header.Get(callbackSignatureHeader))) {
+		return "invalid callback signature"
+	}
+
+	fresh, err := database.UseCallbackNonce(nonce, time.Now().Add(-2*callbackMaxSkew))
+	if err != nil {
+		fmt.Println("Failed to record callback nonce:", err)
+		return "unable to check callback nonce"
+	}
+	if !fresh {
+		return "replayed callback"
+	}
+	return ""
+}
+
+// SignCallback returns the signature a node or proxy sends in
+// X-Callback-Signature
+func SignCallback(secret string, timestamp string, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + nonce + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// claimCallbackBlock lets one callback process a block. A replayed or
+// repeated callback for a block already handled is answered here and gets
+// false.
+func claimCallbackBlock(c *gin.Context, contractHash string, blockID string) bool {
+	claimed, err := database.MarkCallbackBlock(contractHash, blockID)
+	if err != nil {
+		fmt.Println("Failed to mark callback block:", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to record callback", err)
+		return false
+	}
+	if !claimed {
+		fmt.Printf("Block %s of %s was already processed\n", blockID, contractHash)
+		c.JSON(http.StatusOK, gin.H{"message": "Block already processed", "block_id": blockID})
+		return false
+	}
+	return true
+}
+
+// releaseCallbackBlock lets the next callback retry a block that failed
+func releaseCallbackBlock(contractHash string, blockID string) {
+	if err := database.UnmarkCallbackBlock(contractHash, blockID); err != nil {
+		fmt.Println("Failed to release callback block:", err)
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"dapp-server/database"
+	rubix_interaction "dapp-server/rubix-interaction"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCallbacksMustComeFromRegisteredNodes(t *testing.T) {
+	node := startNode(t)
+	registerCallback(t, node, testTransferContract, "api/call-back-trigger")
+	registration, err := database.GetCallbackRegistration(testTransferContract, testPort)
+	if err != nil || registration == nil {
+		t.Fatalf("registration = %v, %v", registration, err)
+	}
+	callback := func(path string, hash string, port string, header http.Header) (int, map[string]interface{}) {
+		t.Helper()
+		body, _ := json.Marshal(ContractInputRequest{Port: port, SmartContractHash: hash})
+		req, err := http.NewRequest(http.MethodPost, apiServer.URL+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode, decodeBody(t, resp)
+	}
+	token := "?" + rubix_interaction.CallbackTokenParam + "=" + registration.Secret
+
+	for name, call := range map[string]struct{ path, hash, port string }{
+		"no token":         {"/api/call-back-trigger", testTransferContract, testPort},
+		"wrong token":      {"/api/call-back-trigger?callback_token=guess", testTransferContract, testPort},
+		"unknown port":     {"/api/call-back-trigger" + token, testTransferContract, "20999"},
+		"unregistered":     {"/api/call-back-trigger" + token, "QmNeverRegistered", testPort},
+		"another endpoint": {"/api/callback/trigger" + token, testTransferContract, testPort},
+	} {
+		if status, body := callback(call.path, call.hash, call.port, nil); status != http.StatusForbidden {
+			t.Fatalf("%s: status = %d, %v", name, status, body)
+		}
+	}
+	if status, body := callback("/api/call-back-trigger"+token, testTransferContract, testPort, nil); status != http.StatusOK {
+		t.Fatalf("registered callback = %d, %v", status, body)
+	}
+
+	// A signed callback is accepted once
+	body, _ := json.Marshal(ContractInputRequest{Port: testPort, SmartContractHash: testTransferContract})
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signed := http.Header{}
+	signed.Set(callbackTimestampHeader, timestamp)
+	signed.Set(callbackNonceHeader, "nonce-1")
+	signed.Set(callbackSignatureHeader, SignCallback(registration.Secret, timestamp, "nonce-1", body))
+	if status, body := callback("/api/call-back-trigger", testTransferContract, testPort, signed); status != http.StatusOK {
+		t.Fatalf("signed callback = %d, %v", status, body)
+	}
+	if status, body := callback("/api/call-back-trigger", testTransferContract, testPort, signed); status != http.StatusForbidden {
+		t.Fatalf("replayed signed callback = %d, %v", status, body)
+	}
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	signed.Set(callbackTimestampHeader, stale)
+	signed.Set(callbackNonceHeader, "nonce-2")
+	signed.Set(callbackSignatureHeader, SignCallback(registration.Secret, stale, "nonce-2", body))
+	if status, body := callback("/api/call-back-trigger", testTransferContract, testPort, signed); status != http.StatusForbidden {
+		t.Fatalf("stale signed callback = %d, %v", status, body)
+	}
+
+	// Replaying a genuine activity callback does not run the contract again
+	registerCallback(t, node, testActivityContract, "api/callback/trigger")
+	if status, body := postJSON(t, "/api/activity/add", AddActivityRequest{ActivityID: "replayed-run", RewardPoints: 10, AdminDID: testAdminDID}); status != http.StatusOK {
+		t.Fatalf("add activity = %d, %v", status, body)
+	}
+	runs := len(contractCallsMatching(testActivityContract, "replayed-run"))
+	registration, _ = database.GetCallbackRegistration(testActivityContract, testPort)
+	status, reply := callback("/api/callback/trigger?callback_token="+registration.Secret, testActivityContract, testPort, nil)
+	if status != http.StatusOK || reply["message"] != "Block already processed" {
+		t.Fatalf("replayed activity callback = %d, %v", status, reply)
+	}
+	if again := len(contractCallsMatching(testActivityContract, "replayed-run")); runs != 1 || again != runs {
+		t.Fatalf("contract ran %d time(s), then %d", runs, again)
+	}
+}
+
+func TestCallbackTokenIsNotLogged(t *testing.T) {
+	var logged bytes.Buffer
+	previous := gin.DefaultWriter
+	gin.DefaultWriter = &logged
+	router := NewRouter()
+	gin.DefaultWriter = previous
+
+	const secret = "not-for-the-log"
+	req := httptest.NewRequest(http.MethodPost, "/api/call-back-trigger?"+rubix_interaction.CallbackTokenParam+"="+secret,
+		strings.NewReader(`{"smart_contract_hash":"QmUnregistered","port":"`+testPort+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !strings.Contains(logged.String(), "/api/call-back-trigger") {
+		t.Fatalf("callback was not logged: %q", logged.String())
+	}
+	if strings.Contains(logged.String(), secret) {
+		t.Fatalf("callback token logged: %q", logged.String())
+	}
+}
@@ -1,106 +1,95 @@
 package server
 
 import (
+	"dapp-server/database"
 	rubix_interaction "dapp-server/rubix-interaction"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	wasmbridge "github.com/rubixchain/rubix-wasm/go-wasm-bridge"
 )
 
 // http://localhost:9000/api/callback/add-admin
-type AddAdmin struct {
-	AdminDID string `json:"admin_did"`
-}
-
+// Payload is the input carried by a block of the add_admin contract, which
+// either adds or removes an admin
 type Payload struct {
-	AddAdmin AddAdmin `json:"add_admin"`
+	AddAdmin    *rubix_interaction.AddAdminReq    `json:"add_admin,omitempty"`
+	RemoveAdmin *rubix_interaction.RemoveAdminReq `json:"remove_admin,omitempty"`
 }
 
 func APIAddAdminCallBackTrigger(c *gin.Context) {
 	var req ContractInputRequest
-	err := json.NewDecoder(c.Request.Body).Decode(&req)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
-		fmt.Printf("Error reading response body: %s\n", err)
+	if !bindRequest(c, &req) {
 		return
 	}
 	fmt.Println("The request body is:", req)
-	url := fmt.Sprintf("http://localhost:%s", req.Port)
-	fmt.Println("The url is :", url)
-
-	// // config := GetConfig()
 	smartContractHash := req.SmartContractHash
 	fmt.Println("Received Smart Contract hash: ", smartContractHash)
 
-	smartContractTokenData := rubix_interaction.GetSmartContractData(smartContractHash, url) //config.NodeAddress)
-	if smartContractTokenData == nil {
-		fmt.Println("Unable to fetch latest smart contract data")
+	relevantBlock, ok := latestCallbackBlock(c, req)
+	if !ok {
 		return
 	}
-
-	fmt.Println("Smart Contract Token Data :", string(smartContractTokenData))
-
-	var dataReply SmartContractDataReply
-
-	if err := json.Unmarshal(smartContractTokenData, &dataReply); err != nil {
-		fmt.Println("Error:", err)
+	fmt.Println("Smart Contract Data:", relevantBlock.SmartContractData)
+	var payload Payload
+	err := json.Unmarshal([]byte(relevantBlock.SmartContractData), &payload)
+	if err != nil {
+		fmt.Println("Error unmarshaling JSON:", err)
+		respondError(c, http.StatusUnprocessableEntity, CodeUnexpectedBlock, "Block does not carry an admin change", err)
 		return
 	}
-	fmt.Println("Data reply in APICallBackTrigger", dataReply)
-	smartContractData := dataReply.SCTDataReply
-	var relevantBlock *SCTDataReply
-
-	// var blockId string
-	var blockNo uint64
-	for _, data := range smartContractData {
-		relevantBlock = &data // Assuming you want the last block
-		fmt.Println("The relevant block is :", relevantBlock)
-		// blockId = data.BlockId
-		blockNo = data.BlockNo
-	}
-	if blockNo == 0 {
-		fmt.Println("The block number is zero which is the genesis block")
+	// Stamp the change with the block that carried it and who executed it,
+	// so the admin history can say who added or removed whom
+	switch {
+	case payload.AddAdmin != nil:
+		payload.AddAdmin.BlockID = relevantBlock.BlockId
+		payload.AddAdmin.ActorDID = relevantBlock.ExecutorDID
+	case payload.RemoveAdmin != nil:
+		payload.RemoveAdmin.BlockID = relevantBlock.BlockId
+		payload.RemoveAdmin.ActorDID = relevantBlock.ExecutorDID
+	default:
+		fmt.Println("Block carries neither add_admin nor remove_admin:", relevantBlock.SmartContractData)
+		respondError(c, http.StatusUnprocessableEntity, CodeUnexpectedBlock, "Block does not carry an admin change", nil)
 		return
 	}
-	// var payload Payload
-	// err = json.Unmarshal([]byte(relevantBlock.SmartContractData), &payload)
-	// if err != nil {
-	// 	fmt.Println("Error unmarshaling JSON:", err)
-	// 	return
-	// }
-	fmt.Println("Smart Contract Data:", relevantBlock.SmartContractData)
-	registry := wasmbridge.NewHostFunctionRegistry()
-
-	// Create your custom host function
-	registry.Register(rubix_interaction.NewWriteToJsonFile())
-	hostFunction := registry.GetHostFunctions()
-	fmt.Println("Host function is :", hostFunction)
-	wasmPath, err := getWasmContractPath(smartContractHash, req.Port)
+	contractInput, err := json.Marshal(payload)
 	if err != nil {
-		fmt.Println("Failed to get wasm path")
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to build contract input", err)
+		return
 	}
-	fmt.Println("The wasm path is :", wasmPath)
-	wasmModule, err := wasmbridge.NewWasmModule(
-		wasmPath,
-		registry,
-		// wasmbridge.WithRubixNodeAddress("http://localhost:20002"), //config.NodeAddress),
-		// wasmbridge.WithQuorumType(2),
-	)
-	if err != nil {
-		log.Printf("Failed to initialize WASM module: %v", err)
+	fmt.Println("The contract input is :", string(contractInput))
+	if !claimCallbackBlock(c, smartContractHash, relevantBlock.BlockId) {
 		return
 	}
-	// contractInput := fmt.Sprintf(`{"add_activity": {"activity_id":"%s","reward_points":%d,"block_hash":"%s"}}`, parsedData.ActivityID, parsedData.RewardPoints, relevantBlock.BlockId)
-	// fmt.Println("The contract input is :", contractInput)
-	fmt.Println("The smart contract data is :", relevantBlock.SmartContractData)
-	result, err := wasmModule.CallFunction(relevantBlock.SmartContractData)
+	var failures recordFailures
+	registry := newContractRegistry(failures.watch(AdminRecordKind, AdminRemovalRecordKind)...)
+	result, err := runContract(smartContractHash, req.Port, "", registry, string(contractInput))
 	if err != nil {
 		log.Printf("Failed to call WASM function: %v", err)
+		releaseCallbackBlock(smartContractHash, relevantBlock.BlockId)
+		respondError(c, http.StatusInternalServerError, CodeContractFailed, "Failed to run the admin contract", err)
+		return
+	}
+	getAdminSet().Invalidate()
+	if err := failures.err(); errors.Is(err, database.ErrLastAdmin) {
+		// The chain has removed the admin but the store keeps its last one;
+		// running the block again would not change that
+		log.Printf("Block %s removes the last recorded admin: %v", relevantBlock.BlockId, err)
+		respondError(c, http.StatusConflict, CodeLastAdmin, "Block removes the last recorded admin; the removal was not recorded", err)
+		return
+	} else if err != nil {
+		log.Printf("Failed to record the admin change of block %s: %v", relevantBlock.BlockId, err)
+		releaseCallbackBlock(smartContractHash, relevantBlock.BlockId)
+		respondError(c, http.StatusInternalServerError, CodeContractFailed, "Failed to record the admin change", err)
 		return
 	}
 	fmt.Println("The result is :", result)
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Admin change recorded",
+		"block_id": relevantBlock.BlockId,
+		"result":   result,
+	})
 }
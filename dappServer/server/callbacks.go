@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"dapp-server/config"
+	"dapp-server/database"
+	rubix_interaction "dapp-server/rubix-interaction"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// callbackEndpoints are the routes a node can be asked to call back. Keep
+// them in step with the callback routes mounted in NewRouter.
+var callbackEndpoints = []string{
+	"api/call-back-trigger",
+	"api/callback/trigger",
+	"api/callback/add-admin",
+}
+
+// defaultPublicBaseURL is where nodes reach the server when PUBLIC_BASE_URL
+// is not set: the port NewRouter is served on, on the same host
+const defaultPublicBaseURL = "http://localhost:9000"
+
+// publicBaseURL reads PUBLIC_BASE_URL, the address nodes use to reach the
+// server, e.g. https://wellness.example.org
+func publicBaseURL() string {
+	if baseURL := strings.TrimSpace(config.GetEnvConfig().PublicBaseURL); baseURL != "" {
+		return strings.TrimRight(baseURL, "/")
+	}
+	return defaultPublicBaseURL
+}
+
+// CallbackEndpoint records a problem with field unless value is one of the
+// mounted callback routes
+func (f FieldErrors) CallbackEndpoint(field string, value string) {
+	value = strings.TrimPrefix(value, "/")
+	for _, endpoint := range callbackEndpoints {
+		if value == endpoint {
+			return
+		}
+	}
+	f.add(field, "must be one of "+strings.Join(callbackEndpoints, ", "))
+}
+
+// RegisterCallbacksRequest registers endpoint for a contract with nodes,
+// given by name or port, or with every configured node if none are given
+type RegisterCallbacksRequest struct {
+	Endpoint string   `json:"endpoint"`
+	Nodes    []string `json:"nodes"`
+}
+
+func (r RegisterCallbacksRequest) Validate() FieldErrors {
+	problems := FieldErrors{}
+	problems.Required("endpoint", r.Endpoint)
+	problems.CallbackEndpoint("endpoint", r.Endpoint)
+	return problems
+}
+
+// ReregisterCallbacksRequest optionally moves every registration of a
+// contract to another endpoint
+type ReregisterCallbacksRequest struct {
+	Endpoint string `json:"endpoint"`
+}
+
+func (r ReregisterCallbacksRequest) Validate() FieldErrors {
+	problems := FieldErrors{}
+	if r.Endpoint != "" {
+		problems.CallbackEndpoint("endpoint", r.Endpoint)
+	}
+	return problems
+}
+
+// callbackRegistration is a stored registration with the name of its node
+type callbackRegistration struct {
+	Node string `json:"node,omitempty"`
+	database.CallbackRegistration
+}
+
+// APIListCallbacks returns the callback URLs registered for a contract on
+// each node
+func APIListCallbacks(c *gin.Context) {
+	contractHash := c.Param("hash")
+	if !validIDParam(c, "hash", contractHash) {
+		return
+	}
+	registrations, err := database.ListCallbackRegistrations(contractHash)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to load callback registrations", err)
+		return
+	}
+	cfg, _ := config.GetConfig()
+	data := make([]callbackRegistration, 0, len(registrations))
+	for _, registration := range registrations {
+		view := callbackRegistration{CallbackRegistration: registration}
+		if cfg != nil {
+			view.Node, _ = config.GetNodeNameByPort(cfg, registration.NodePort)
+		}
+		data = append(data, view)
+	}
+	c.JSON(http.StatusOK, gin.H{"status": true, "contract_hash": contractHash, "data": data})
+}
+
+// APIRegisterCallbacks registers a callback URL for an existing contract
+func APIRegisterCallbacks(c *gin.Context) {
+	contractHash := c.Param("hash")
+	if !validIDParam(c, "hash", contractHash) {
+		return
+	}
+	var req RegisterCallbacksRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	ports, ok := callbackNodePorts(c, "nodes", req.Nodes)
+	if !ok {
+		return
+	}
+	targets := make([]rubix_interaction.CallbackTarget, 0, len(ports))
+	for _, port := range ports {
+		targets = append(targets, callbackTarget(port, req.Endpoint))
+	}
+	respondCallbackResults(c, contractHash, registerCallbacks(c.Request.Context(), contractHash, targets))
+}
+
+// APIReregisterCallbacks registers a contract's callback URL again with
+// every configured node, with fresh secrets and the current public base
+// URL. Each node keeps the endpoint it has unless the request names one;
+// nodes without a registration get the contract's latest endpoint.
+func APIReregisterCallbacks(c *gin.Context) {
+	contractHash := c.Param("hash")
+	if !validIDParam(c, "hash", contractHash) {
+		return
+	}
+	var req ReregisterCallbacksRequest
+	if c.Request.ContentLength != 0 && !bindRequest(c, &req) {
+		return
+	}
+	registrations, err := database.ListCallbackRegistrations(contractHash)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to load callback registrations", err)
+		return
+	}
+	endpoints := make(map[string]string, len(registrations))
+	var latest *database.CallbackRegistration
+	for i, registration := range registrations {
+		endpoints[registration.NodePort] = registration.Endpoint
+		if latest == nil || registration.CreatedAt.After(latest.CreatedAt) {
+			latest = &registrations[i]
+		}
+	}
+	if req.Endpoint == "" && latest == nil {
+		respondError(c, http.StatusNotFound, CodeNotFound, "No callback registered for contract",
+			fmt.Errorf("register an endpoint for %s first, or name one", contractHash))
+		return
+	}
+
+	ports, ok := callbackNodePorts(c, "nodes", nil)
+	if !ok {
+		return
+	}
+	targets := make([]rubix_interaction.CallbackTarget, 0, len(ports))
+	for _, port := range ports {
+		endpoint := req.Endpoint
+		if endpoint == "" {
+			if endpoint = endpoints[port]; endpoint == "" {
+				endpoint = latest.Endpoint
+			}
+		}
+		targets = append(targets, callbackTarget(port, endpoint))
+	}
+	respondCallbackResults(c, contractHash, registerCallbacks(c.Request.Context(), contractHash, targets))
+}
+
+// registerCallbacks registers the contract's callback URL with every target
+// and keeps the secrets of the registrations that succeeded
+func registerCallbacks(ctx context.Context, contractHash string, targets []rubix_interaction.CallbackTarget) []rubix_interaction.CallbackResult {
+	return saveCallbackRegistrations(contractHash, rubix_interaction.RegisterCallbacks(ctx, contractHash, targets))
+}
+
+// saveCallbackRegistrations stores the registrations that succeeded, so
+// callbacks presenting their secrets are accepted. One that cannot be
+// stored is reported as failed: the node's callbacks would be rejected.
+func saveCallbackRegistrations(contractHash string, results []rubix_interaction.CallbackResult) []rubix_interaction.CallbackResult {
+	for i, result := range results {
+		if result.Error != "" {
+			continue
+		}
+		err := database.SaveCallbackRegistration(database.CallbackRegistration{
+			ContractHash: contractHash,
+			NodePort:     result.NodePort,
+			Endpoint:     result.Endpoint,
+			URL:          result.URL,
+			Secret:       result.Secret,
+		})
+		if err != nil {
+			fmt.Printf("Failed to store callback registration for node %s: %v\n", result.NodePort, err)
+			results[i].Error = err.Error()
+		}
+	}
+	return results
+}
+
+// callbackTarget is the node on port, told to call endpoint on this server
+func callbackTarget(port string, endpoint string) rubix_interaction.CallbackTarget {
+	return rubix_interaction.CallbackTarget{
+		Client:   newNodeClient(port),
+		NodePort: port,
+		BaseURL:  publicBaseURL(),
+		Endpoint: endpoint,
+	}
+}
+
+// callbackNodePorts resolves nodes with nodePorts. On failure the error
+// response has been written and ok is false.
+func callbackNodePorts(c *gin.Context, field string, nodes []string) (ports []string, ok bool) {
+	cfg, err := config.GetConfig()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeNotConfigured, "Node config is not loaded", err)
+		return nil, false
+	}
+	ports, unknown := nodePorts(cfg, nodes)
+	if unknown != "" {
+		respondError(c, http.StatusBadRequest, CodeUnknownNode, "Unknown node",
+			fmt.Errorf("%s: %s is neither the name nor the port of a configured node", field, unknown))
+		return nil, false
+	}
+	return ports, true
+}
+
+// nodePorts resolves nodes, given by name or port, to the ports of
+// configured nodes. No nodes means every configured node. unknown is the
+// first node that is not configured.
+func nodePorts(cfg *config.Config, nodes []string) (ports []string, unknown string) {
+	if len(nodes) == 0 {
+		for _, node := range cfg.Nodes {
+			ports = append(ports, node.Port)
+		}
+		sort.Strings(ports)
+		return ports, ""
+	}
+	seen := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		port, exists := config.GetPortByNodeName(cfg, node)
+		if !exists {
+			if _, exists = config.GetNodeNameByPort(cfg, node); exists {
+				port = node
+			}
+		}
+		if !exists {
+			return nil, node
+		}
+		if !seen[port] {
+			seen[port] = true
+			ports = append(ports, port)
+		}
+	}
+	return ports, ""
+}
+
+// respondCallbackResults reports the registration with each node. If any
+// node failed the response is a 502 that still lists every result.
+func respondCallbackResults(c *gin.Context, contractHash string, results []rubix_interaction.CallbackResult) {
+	if failed := rubix_interaction.FailedCallbacks(results); failed > 0 {
+		respondError(c, http.StatusBadGateway, CodeCallbackRegistration, "Callback registration failed",
+			fmt.Errorf("%d of %d node(s) refused the callback URL", failed, len(results)),
+			gin.H{"contract_hash": contractHash, "data": results})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":        true,
+		"message":       "Callback registered",
+		"contract_hash": contractHash,
+		"data":          results,
+	})
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dapp-server/config"
+	"dapp-server/database"
+	rubix_interaction "dapp-server/rubix-interaction"
+)
+
+func TestCallbackRegistrationEndpoints(t *testing.T) {
+	node := startNode(t)
+	const hash = "QmCallbackManagedContract"
+	node.AddContract(hash, testAdminDID)
+	path := "/api/contracts/" + hash + "/callbacks"
+	adminKey := apiClient.Transport.(*apiKeyTransport).key
+
+	if status, body := callWith(t, http.MethodPut, path, apiKeyHeader, adminKey, nil); status != http.StatusNotFound {
+		t.Fatalf("re-register before any registration = %d, %v", status, body)
+	}
+	if status, body := postJSON(t, path, RegisterCallbacksRequest{Endpoint: "api/nowhere"}); status != http.StatusBadRequest {
+		t.Fatalf("register an unmounted route = %d, %v", status, body)
+	}
+
+	// No nodes means every configured node
+	status, body := postJSON(t, path, RegisterCallbacksRequest{Endpoint: "api/call-back-trigger"})
+	if status != http.StatusOK {
+		t.Fatalf("register = %d, %v", status, body)
+	}
+	first := node.CallbackURL(hash)
+	if !strings.HasPrefix(first, apiServer.URL+"/api/call-back-trigger?") {
+		t.Fatalf("callback URL = %q", first)
+	}
+	status, body = getJSON(t, path)
+	data, _ := body["data"].([]interface{})
+	if status != http.StatusOK || len(data) != 1 {
+		t.Fatalf("list = %d, %v", status, body)
+	}
+	if listed, _ := data[0].(map[string]interface{}); listed["node"] != "node2" || listed["endpoint"] != "api/call-back-trigger" || listed["secret"] != nil {
+		t.Fatalf("listed registration = %v", listed)
+	}
+
+	// Re-registering keeps the endpoint and rotates the secret
+	if status, body := callWith(t, http.MethodPut, path, apiKeyHeader, adminKey, nil); status != http.StatusOK {
+		t.Fatalf("re-register = %d, %v", status, body)
+	}
+	second := node.CallbackURL(hash)
+	if second == first || !strings.HasPrefix(second, apiServer.URL+"/api/call-back-trigger?") {
+		t.Fatalf("callback URL after re-registering = %q, was %q", second, first)
+	}
+	if status, body := callWith(t, http.MethodPut, path, apiKeyHeader, adminKey, ReregisterCallbacksRequest{Endpoint: "api/callback/trigger"}); status != http.StatusOK {
+		t.Fatalf("move endpoint = %d, %v", status, body)
+	}
+	if registration, _ := database.GetCallbackRegistration(hash, testPort); registration.Endpoint != "api/callback/trigger" {
+		t.Fatalf("registration after moving = %+v", registration)
+	}
+
+	// A node that cannot be reached is reported, not skipped
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	previous := newNodeClient
+	newNodeClient = func(string) rubix_interaction.NodeClient { return rubix_interaction.NewRubixClient(down.URL) }
+	defer func() { newNodeClient = previous }()
+	status, body = postJSON(t, path, RegisterCallbacksRequest{Endpoint: "api/callback/trigger", Nodes: []string{testPort}})
+	if status != http.StatusBadGateway || body["code"] != string(CodeCallbackRegistration) {
+		t.Fatalf("register with a node down = %d, %v", status, body)
+	}
+}
+
+func TestNodePortsResolveNamesAndPorts(t *testing.T) {
+	cfg := &config.Config{Nodes: map[string]config.Node{
+		"node2": {Name: "node2", Port: "20002"},
+		"node1": {Name: "node1", Port: "20001"},
+	}}
+	for _, tc := range []struct {
+		nodes       []string
+		wantPorts   string
+		wantUnknown string
+	}{
+		{nil, "20001,20002", ""},
+		{[]string{"node2", "20001", "20002"}, "20002,20001", ""},
+		{[]string{"node1", "node9"}, "", "node9"},
+		{[]string{"20009"}, "", "20009"},
+	} {
+		ports, unknown := nodePorts(cfg, tc.nodes)
+		if strings.Join(ports, ",") != tc.wantPorts || unknown != tc.wantUnknown {
+			t.Errorf("nodePorts(%v) = %v, %q, want %s, %q", tc.nodes, ports, unknown, tc.wantPorts, tc.wantUnknown)
+		}
+	}
+}
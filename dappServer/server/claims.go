@@ -0,0 +1,110 @@
+package server
+
+import (
+	"dapp-server/config"
+	"dapp-server/database"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClaimPolicy says how often a user may be rewarded for an activity
+type ClaimPolicy string
+
+const (
+	// ClaimOneTime activities are rewarded once per user
+	ClaimOneTime ClaimPolicy = "one_time"
+	// ClaimDaily activities are rewarded once per user per day (server time)
+	ClaimDaily ClaimPolicy = "daily"
+	// ClaimUnlimited activities are rewarded every time they are claimed
+	ClaimUnlimited ClaimPolicy = "unlimited"
+)
+
+func parseClaimPolicy(value string) (ClaimPolicy, error) {
+	switch policy := ClaimPolicy(strings.TrimSpace(value)); policy {
+	case ClaimOneTime, ClaimDaily, ClaimUnlimited:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown claim policy %q", value)
+	}
+}
+
+// claimPolicies maps activity IDs to their policy. It is read from
+// CLAIM_POLICIES ("5=daily,7=unlimited"); activities not listed there use
+// CLAIM_POLICY_DEFAULT, which itself defaults to one_time.
+type claimPolicies struct {
+	defaultPolicy ClaimPolicy
+	byActivity    map[string]ClaimPolicy
+}
+
+var (
+	loadedClaimPolicies *claimPolicies
+	claimPoliciesOnce   sync.Once
+)
+
+func getClaimPolicies() *claimPolicies {
+	claimPoliciesOnce.Do(func() {
+		envConfig := config.GetEnvConfig()
+		policies := &claimPolicies{
+			defaultPolicy: ClaimOneTime,
+			byActivity:    make(map[string]ClaimPolicy),
+		}
+		if envConfig.ClaimPolicyDefault != "" {
+			policy, err := parseClaimPolicy(envConfig.ClaimPolicyDefault)
+			if err != nil {
+				fmt.Printf("Invalid CLAIM_POLICY_DEFAULT, using %s: %v\n", ClaimOneTime, err)
+			} else {
+				policies.defaultPolicy = policy
+			}
+		}
+		for _, entry := range strings.Split(envConfig.ClaimPolicies, ",") {
+			if strings.TrimSpace(entry) == "" {
+				continue
+			}
+			activityID, value, found := strings.Cut(entry, "=")
+			policy, err := parseClaimPolicy(value)
+			if !found || err != nil {
+				fmt.Printf("Ignoring invalid CLAIM_POLICIES entry %q\n", entry)
+				continue
+			}
+			policies.byActivity[strings.TrimSpace(activityID)] = policy
+		}
+		loadedClaimPolicies = policies
+	})
+	return loadedClaimPolicies
+}
+
+// ClaimPolicyFor returns the claim policy of an activity
+func ClaimPolicyFor(activityID string) ClaimPolicy {
+	policies := getClaimPolicies()
+	if policy, ok := policies.byActivity[activityID]; ok {
+		return policy
+	}
+	return policies.defaultPolicy
+}
+
+// activityClaims returns the claims a transfer makes for its activities
+func activityClaims(requestID string, userDID string, activityIDs []string, now time.Time) []database.ActivityClaim {
+	claims := make([]database.ActivityClaim, 0, len(activityIDs))
+	for i, activityID := range activityIDs {
+		var occurrence string
+		switch ClaimPolicyFor(activityID) {
+		case ClaimDaily:
+			occurrence = now.Format("2006-01-02")
+		case ClaimUnlimited:
+			// Unique to this claim, so it never collides
+			occurrence = fmt.Sprintf("%s#%d", requestID, i)
+		default:
+			occurrence = "once"
+		}
+		claims = append(claims, database.ActivityClaim{
+			UserDID:    userDID,
+			ActivityID: activityID,
+			Occurrence: occurrence,
+			RequestID:  requestID,
+			CreatedAt:  now,
+		})
+	}
+	return claims
+}
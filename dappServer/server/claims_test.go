@@ -0,0 +1,67 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"dapp-server/database"
+)
+
+func TestDuplicateActivityClaimsAreRejected(t *testing.T) {
+	node := startNode(t)
+	registerCallback(t, node, testTransferContract, "api/call-back-trigger")
+
+	const receiver = "bafybmiclaimingreceiver"
+	status, body := postJSON(t, "/api/rewards/transfer", TransferRewardRequest{
+		ActivityID: []string{"yoga-once", "swim-daily", "open-gym"},
+		UserDID:    receiver,
+		AdminDID:   testAdminDID,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("first claim = %d, %v", status, body)
+	}
+
+	status, body = postJSON(t, "/api/rewards/transfer", TransferRewardRequest{
+		ActivityID: []string{"open-gym", "swim-daily", "yoga-once"},
+		UserDID:    receiver,
+		AdminDID:   testAdminDID,
+	})
+	if status != http.StatusConflict {
+		t.Fatalf("repeat claim = %d, %v", status, body)
+	}
+	if got := fmt.Sprint(body["duplicate_activity_ids"]); got != "[swim-daily yoga-once]" {
+		t.Fatalf("duplicate_activity_ids = %s", got)
+	}
+
+	status, body = postJSON(t, "/api/rewards/transfer", TransferRewardRequest{
+		ActivityID: []string{"yoga-once", "yoga-once"},
+		UserDID:    "bafybmidoubleclaimreceiver",
+		AdminDID:   testAdminDID,
+	})
+	if status != http.StatusConflict {
+		t.Fatalf("claim repeated within a request = %d, %v", status, body)
+	}
+}
+
+func TestFailedTransferReleasesClaims(t *testing.T) {
+	node := startNode(t)
+	registerCallback(t, node, testTransferContract, "api/call-back-trigger")
+
+	receiver := "bafybmi" + failingReceiverMarker + "claimreceiver"
+	status, body := postJSON(t, "/api/rewards/transfer", TransferRewardRequest{
+		ActivityID: []string{"yoga-once"},
+		UserDID:    receiver,
+		AdminDID:   testAdminDID,
+	})
+	if status != http.StatusInternalServerError {
+		t.Fatalf("status = %d, body = %v", status, body)
+	}
+	claims, err := database.GetActivityClaims(receiver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claims) != 0 {
+		t.Fatalf("claims still held after a failed transfer: %+v", claims)
+	}
+}
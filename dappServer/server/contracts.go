@@ -0,0 +1,186 @@
+package server
+
+import (
+	"crypto/sha256"
+	"dapp-server/config"
+	"dapp-server/database"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// envContracts are the .config/.env settings contract hashes used to be read
+// from, by role, with the name a contract seeded from them gets
+var envContracts = map[string]struct {
+	name string
+	hash func(*config.EnvConfig) string
+}{
+	database.ContractRoleActivity: {"activity_contract", func(env *config.EnvConfig) string { return env.AddActivityContract }},
+	database.ContractRoleAdmin:    {"add_admin_contract", func(env *config.EnvConfig) string { return env.AddAdminContract }},
+	database.ContractRoleTransfer: {"transfer_contract", func(env *config.EnvConfig) string { return env.TransferContract }},
+}
+
+// SeedContractRegistry registers the contract hashes configured in
+// .config/.env for each role the registry has no contract for yet, so an
+// existing deployment keeps working. Once a role is in the registry the
+// setting is no longer read.
+func SeedContractRegistry() error {
+	env := config.GetEnvConfig()
+	for _, role := range database.ContractRoles {
+		seed := envContracts[role]
+		hash := strings.TrimSpace(seed.hash(env))
+		if hash == "" {
+			continue
+		}
+		existing, err := database.ContractForRole(role)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			continue
+		}
+		contract, err := database.GetContract(hash)
+		if err != nil {
+			return err
+		}
+		if contract == nil {
+			contract = &database.ContractRecord{ContractHash: hash, Name: seed.name}
+		}
+		contract.Role = role
+		if _, err := database.SaveContract(*contract); err != nil {
+			return err
+		}
+		fmt.Printf("Registered %s as the %s contract from .config/.env\n", hash, role)
+	}
+	return nil
+}
+
+// registeredContractHash returns the hash of the contract registered for role
+func registeredContractHash(role string) (string, error) {
+	contract, err := database.ContractForRole(role)
+	if err != nil {
+		return "", err
+	}
+	if contract == nil {
+		return "", fmt.Errorf("no %s contract is registered", role)
+	}
+	return contract.ContractHash, nil
+}
+
+// contractHashFor returns the hash of the contract registered for role. On
+// failure the error response has been written and ok is false.
+func contractHashFor(c *gin.Context, role string) (hash string, ok bool) {
+	contract, err := database.ContractForRole(role)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to look up contract", err)
+		return "", false
+	}
+	if contract == nil {
+		fmt.Printf("No %s contract is registered\n", role)
+		respondError(c, http.StatusInternalServerError, CodeNotConfigured,
+			fmt.Sprintf("No %s contract is registered", role), nil)
+		return "", false
+	}
+	return contract.ContractHash, true
+}
+
+// contractView is a registered contract with the callbacks registered for it
+type contractView struct {
+	database.ContractRecord
+	Callbacks []database.CallbackRegistration `json:"callbacks"`
+}
+
+// APIListContracts lists the registered contracts, optionally only those
+// with ?role=
+func APIListContracts(c *gin.Context) {
+	contracts, err := database.ListContracts()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to load contracts", err)
+		return
+	}
+	role := c.Query("role")
+	data := make([]contractView, 0, len(contracts))
+	for _, contract := range contracts {
+		if role != "" && contract.Role != role {
+			continue
+		}
+		callbacks, err := database.ListCallbackRegistrations(contract.ContractHash)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to load callback registrations", err)
+			return
+		}
+		data = append(data, contractView{ContractRecord: contract, Callbacks: callbacks})
+	}
+	c.JSON(http.StatusOK, gin.H{"status": true, "data": data})
+}
+
+// APIPromoteContract makes a contract the active version of its role. New
+// work uses it from then on; transfers started under the version it
+// replaces keep their contract hash and still settle against that version.
+func APIPromoteContract(c *gin.Context) {
+	contractHash := c.Param("hash")
+	if !validIDParam(c, "hash", contractHash) {
+		return
+	}
+	previous, err := database.PromoteContract(contractHash)
+	switch {
+	case errors.Is(err, database.ErrContractNotFound):
+		respondError(c, http.StatusNotFound, CodeNotFound, "Contract not found", err)
+		return
+	case errors.Is(err, database.ErrContractHasNoRole):
+		respondError(c, http.StatusConflict, CodeContractHasNoRole, "Only a contract with a role can be promoted", err)
+		return
+	case err != nil:
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to promote contract", err)
+		return
+	}
+	contract, err := database.GetContract(contractHash)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to load contract", err)
+		return
+	}
+	response := gin.H{"status": true, "message": "Contract promoted", "data": contract, "previous": nil}
+	if previous != nil {
+		fmt.Printf("Promoted %s to active %s contract (version %d), replacing %s (version %d)\n",
+			contractHash, contract.Role, contract.Version, previous.ContractHash, previous.Version)
+		response["previous"] = previous
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ContractRole records a problem with field unless value is a contract role
+func (f FieldErrors) ContractRole(field string, value string) {
+	for _, role := range database.ContractRoles {
+		if value == role {
+			return
+		}
+	}
+	f.add(field, "must be one of "+strings.Join(database.ContractRoles, ", "))
+}
+
+// contractName is the name a deployed contract gets when none is given: its
+// wasm file's, without the extension
+func contractName(wasmPath string) string {
+	return strings.TrimSuffix(filepath.Base(wasmPath), filepath.Ext(wasmPath))
+}
+
+// fileDigest returns the hex SHA-256 of a file
+func fileDigest(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"dapp-server/config"
+	"dapp-server/database"
+	rubix_interaction "dapp-server/rubix-interaction"
+)
+
+func TestContractRegistry(t *testing.T) {
+	startNode(t)
+	contracts := func(query string) map[string]map[string]interface{} {
+		t.Helper()
+		status, body := getJSON(t, "/api/contracts"+query)
+		if status != http.StatusOK {
+			t.Fatalf("list contracts = %d, %v", status, body)
+		}
+		byHash := map[string]map[string]interface{}{}
+		data, _ := body["data"].([]interface{})
+		for _, entry := range data {
+			contract, _ := entry.(map[string]interface{})
+			hash, _ := contract["contract_hash"].(string)
+			byHash[hash] = contract
+		}
+		return byHash
+	}
+
+	// The hashes in .config/.env were seeded, one per role
+	seeded := contracts("")
+	for hash, role := range map[string]string{
+		testActivityContract: database.ContractRoleActivity,
+		testAdminContract:    database.ContractRoleAdmin,
+		testTransferContract: database.ContractRoleTransfer,
+	} {
+		if seeded[hash]["role"] != role {
+			t.Fatalf("%s = %v, want role %s", hash, seeded[hash], role)
+		}
+	}
+	if transfers := contracts("?role=transfer"); len(transfers) != 1 || transfers[testTransferContract] == nil {
+		t.Fatalf("transfer contracts = %v", transfers)
+	}
+
+	// Roles resolve through the registry, not the environment
+	env := config.GetEnvConfig()
+	activityContract := env.AddActivityContract
+	env.AddActivityContract = ""
+	defer func() { env.AddActivityContract = activityContract }()
+	if status, body := postJSON(t, "/api/activity/add", AddActivityRequest{ActivityID: "registry-run", RewardPoints: 10, AdminDID: testAdminDID}); status != http.StatusOK {
+		t.Fatalf("add activity without ADD_ACTIVITY_CONTRACT = %d, %v", status, body)
+	}
+
+	// A deployment is recorded with its files, block and callbacks
+	paths := writeContractFiles(t)
+	status, body := postJSON(t, "/api/deploy-contract", DeployRequest{
+		WasmPath:         paths["contract.wasm"],
+		LibPath:          paths["lib.rs"],
+		StatePath:        paths["state.json"],
+		DeployerDid:      testAdminDID,
+		CallbackEndpoint: "api/call-back-trigger",
+	})
+	data, _ := body["data"].(map[string]interface{})
+	hash, _ := data["ContractHash"].(string)
+	if status != http.StatusOK || hash == "" {
+		t.Fatalf("deploy = %d, %v", status, body)
+	}
+	deployed := contracts("")[hash]
+	wasmDigest, _ := fileDigest(paths["contract.wasm"])
+	if deployed["name"] != "contract" || deployed["role"] != nil || deployed["deployer_did"] != testAdminDID ||
+		deployed["wasm_digest"] != wasmDigest || deployed["deployment_block"] != data["DeploymentBlock"] || deployed["deployment_block"] == "" {
+		t.Fatalf("registered contract = %v, deployment = %v", deployed, data)
+	}
+	callbacks, _ := deployed["callbacks"].([]interface{})
+	if len(callbacks) != 1 || callbacks[0].(map[string]interface{})["url"] != apiServer.URL+"/api/call-back-trigger" {
+		t.Fatalf("callbacks = %v", deployed["callbacks"])
+	}
+	if status, body := postJSON(t, "/api/deploy-contract", DeployRequest{
+		WasmPath: paths["contract.wasm"], LibPath: paths["lib.rs"], StatePath: paths["state.json"],
+		DeployerDid: testAdminDID, Role: "payroll",
+	}); status != http.StatusBadRequest {
+		t.Fatalf("deploy with an unknown role = %d, %v", status, body)
+	}
+}
+
+func TestTransferContractSwitchOver(t *testing.T) {
+	node := startNode(t)
+	registerCallback(t, node, testTransferContract, "api/call-back-trigger")
+	t.Cleanup(func() {
+		if _, err := database.PromoteContract(testTransferContract); err != nil {
+			t.Error(err)
+		}
+	})
+	transfer := func(wantContract string) {
+		t.Helper()
+		status, body := postJSON(t, "/api/rewards/transfer", TransferRewardRequest{ActivityID: []string{"2"}, UserDID: testUserDID, AdminDID: testAdminDID})
+		requestID, _ := body["transaction_id"].(string)
+		if status != http.StatusOK || transferStatus(t, requestID).ContractHash != wantContract {
+			t.Fatalf("transfer = %d, %v, want it on %s", status, body, wantContract)
+		}
+	}
+
+	// A transfer is left in flight on the current version
+	inFlight := createTransfer(t, "bafybmiswitchoverreceiver")
+
+	// The next version is registered but only used once promoted
+	paths := writeContractFiles(t)
+	status, body := postJSON(t, "/api/deploy-contract", DeployRequest{
+		WasmPath:         paths["contract.wasm"],
+		LibPath:          paths["lib.rs"],
+		StatePath:        paths["state.json"],
+		DeployerDid:      testAdminDID,
+		Name:             "transfer_contract",
+		Role:             database.ContractRoleTransfer,
+		CallbackEndpoint: "api/call-back-trigger",
+	})
+	registered, _ := body["contract"].(map[string]interface{})
+	next, _ := registered["contract_hash"].(string)
+	if status != http.StatusOK || registered["version"] != float64(2) || registered["active"] != false {
+		t.Fatalf("deploy version 2 = %d, %v", status, body)
+	}
+	transfer(testTransferContract)
+
+	status, body = postJSON(t, "/api/contracts/"+next+"/promote", nil)
+	previous, _ := body["previous"].(map[string]interface{})
+	if status != http.StatusOK || previous["contract_hash"] != testTransferContract {
+		t.Fatalf("promote = %d, %v", status, body)
+	}
+	transfer(next)
+	if active, _ := database.ContractForRole(database.ContractRoleTransfer); active.ContractHash != next || active.Version != 2 {
+		t.Fatalf("active transfer contract = %+v", active)
+	}
+
+	// The transfer started under version 1 still settles on version 1
+	ctx := context.Background()
+	client := newNodeClient(testPort)
+	message, err := transferContractMessage(inFlight, testAdminDID, "bafybmiswitchoverreceiver", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	executeID, err := client.ExecuteSmartContract(ctx, rubix_interaction.NewExecuteSmartContractRequest(testTransferContract, testAdminDID, message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rubix_interaction.Sign(ctx, client, testAdminDID, executeID); err != nil {
+		t.Fatal(err)
+	}
+	if got := transferStatus(t, inFlight); got.Status != "success" || got.ContractHash != testTransferContract {
+		t.Fatalf("in-flight transfer = %s on %s", got.Status, got.ContractHash)
+	}
+
+	if status, body := postJSON(t, "/api/contracts/QmNeverDeployed/promote", nil); status != http.StatusNotFound {
+		t.Fatalf("promote an unknown contract = %d, %v", status, body)
+	}
+}
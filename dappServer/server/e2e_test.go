@@ -0,0 +1,780 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"dapp-server/config"
+	"dapp-server/database"
+	"dapp-server/fakenode"
+	rubix_interaction "dapp-server/rubix-interaction"
+
+	"github.com/gin-gonic/gin"
+	wasmbridge "github.com/rubixchain/rubix-wasm/go-wasm-bridge"
+)
+
+const (
+	testPort             = "20002"
+	testAdminDID         = "bafybmiadminadminadminadminadminadminadminadminadminadmin"
+	testUserDID          = "bafybmiuseruseruseruseruseruseruseruseruseruseruseruseru"
+	testActivityContract = "QmTestActivityContract"
+	testAdminContract    = "QmTestAdminContract"
+	testTransferContract = "QmTestTransferContract"
+
+	testSignaturePassword = "test-signing-password"
+)
+
+// failingReceiverMarker in a receiver DID makes its FT transfer fail
+const failingReceiverMarker = "failing"
+
+var apiServer *httptest.Server
+
+// apiClient calls apiServer as a staff tool holding an admin API key
+var apiClient = &http.Client{Transport: &apiKeyTransport{}}
+
+type apiKeyTransport struct {
+	key string
+}
+
+func (t *apiKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get(apiKeyHeader) == "" && req.Header.Get("Authorization") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set(apiKeyHeader, t.key)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// contractCall records one WASM invocation made by a callback handler
+type contractCall struct {
+	ContractHash string
+	Port         string
+	Input        string
+}
+
+var contractCalls struct {
+	sync.Mutex
+	calls []contractCall
+}
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "dapp-server-test")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	code := runTests(m, dir)
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func runTests(m *testing.M, dir string) int {
+	if err := os.MkdirAll(filepath.Join(dir, ".config"), 0755); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	configTOML := fmt.Sprintf(`[nodes.node2]
+name = "node2"
+port = "%s"
+did = "%s"
+path = "%s"
+`, testPort, testAdminDID, dir)
+	env := fmt.Sprintf(`ADD_ACTIVITY_CONTRACT=%s
+ADD_ADMIN_CONTRACT=%s
+TRANSFER_CONTRACT=%s
+ACTIVITY_UPDATE_PATH=%s
+ADD_ADMIN_PATH=%s
+CLAIM_POLICY_DEFAULT=unlimited
+CLAIM_POLICIES=yoga-once=one_time,swim-daily=daily
+REWARD_POINTS_PER_TOKEN=10
+BOOTSTRAP_ADMIN_DIDS=%s
+RUBIX_SIGNATURE_PASSWORD=%s
+`, testActivityContract, testAdminContract, testTransferContract,
+		filepath.Join(dir, "activities.json"), filepath.Join(dir, "admins.json"), testAdminDID, testSignaturePassword)
+	if err := os.WriteFile(filepath.Join(dir, ".config", "config.toml"), []byte(configTOML), 0644); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".config", ".env"), []byte(env), 0644); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if err := os.Chdir(dir); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	config.LoadConfig(filepath.Join(".config", "config.toml"))
+	config.LoadEnvConfig()
+	if err := database.InitDB(filepath.Join(dir, "transfer_status.db")); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer database.CloseDB()
+	if err := SeedContractRegistry(); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	// Activity "1" was re-registered; the later entry is the one that counts
+	for _, activity := range []database.ActivityRecord{
+		{ActivityID: "1", BlockHash: "1-old", RewardPoints: 50},
+		{ActivityID: "1", BlockHash: "2-new", RewardPoints: 10},
+		{ActivityID: "2", BlockHash: "3-two", RewardPoints: 20},
+		{ActivityID: "3", BlockHash: "4-three", RewardPoints: 10},
+		{ActivityID: "yoga-once", BlockHash: "5-yoga", RewardPoints: 30},
+		{ActivityID: "swim-daily", BlockHash: "6-swim", RewardPoints: 10},
+		{ActivityID: "open-gym", BlockHash: "7-gym", RewardPoints: 10},
+		{ActivityID: "stretch", BlockHash: "8-stretch", RewardPoints: 5},
+	} {
+		if err := database.UpsertActivity(activity); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+
+	// Contracts are "run" by recording the call. FT transfers succeed unless
+	// the receiver DID is marked as failing.
+	runContract = func(contractHash string, port string, nodeURL string, registry *wasmbridge.HostFunctionRegistry, contractInput string) (string, error) {
+		contractCalls.Lock()
+		contractCalls.calls = append(contractCalls.calls, contractCall{contractHash, port, contractInput})
+		contractCalls.Unlock()
+		if strings.Contains(contractInput, failingReceiverMarker) {
+			return `{"status":false,"message":"receiver rejected the transfer"}`, nil
+		}
+		return "success", nil
+	}
+
+	key, err := CreateAPIKey("e2e-tests", []string{RoleAdmin}, testAdminDID)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	apiClient.Transport.(*apiKeyTransport).key = key
+
+	gin.SetMode(gin.TestMode)
+	apiServer = httptest.NewServer(NewRouter())
+	defer apiServer.Close()
+	config.GetEnvConfig().PublicBaseURL = apiServer.URL
+
+	return m.Run()
+}
+
+// startNode starts a fake node with the three dApp contracts deployed and
+// routes the configured node port to it for the duration of the test
+func startNode(t *testing.T, opts ...fakenode.Option) *fakenode.Node {
+	t.Helper()
+	node := fakenode.New(testPort, append([]fakenode.Option{fakenode.WithPassword(testSignaturePassword)}, opts...)...)
+	node.AddContract(testActivityContract, testAdminDID)
+	node.AddContract(testAdminContract, testAdminDID)
+	node.AddContract(testTransferContract, testAdminDID)
+	nodeServer := httptest.NewServer(node)
+
+	previous := newNodeClient
+	newNodeClient = func(port string) rubix_interaction.NodeClient {
+		if port == testPort {
+			return rubix_interaction.NewRubixClient(nodeServer.URL)
+		}
+		return previous(port)
+	}
+	t.Cleanup(func() {
+		node.Wait()
+		newNodeClient = previous
+		nodeServer.Close()
+	})
+	return node
+}
+
+// registerCallback registers a server endpoint for a contract on the test
+// node the way deployment does, so callbacks carry the registration secret
+func registerCallback(t *testing.T, node *fakenode.Node, contractHash string, endpoint string) {
+	t.Helper()
+	results := saveCallbackRegistrations(contractHash, rubix_interaction.RegisterCallbacks(context.Background(), contractHash,
+		[]rubix_interaction.CallbackTarget{{NodePort: testPort, Client: newNodeClient(testPort), BaseURL: apiServer.URL, Endpoint: endpoint}}))
+	if results[0].Error != "" {
+		t.Fatal(results[0].Error)
+	}
+}
+
+func contractCallsMatching(contractHash string, substr string) []contractCall {
+	contractCalls.Lock()
+	defer contractCalls.Unlock()
+	var matched []contractCall
+	for _, call := range contractCalls.calls {
+		if call.ContractHash == contractHash && strings.Contains(call.Input, substr) {
+			matched = append(matched, call)
+		}
+	}
+	return matched
+}
+
+func lastContractCall(t *testing.T, contractHash string) contractCall {
+	t.Helper()
+	contractCalls.Lock()
+	defer contractCalls.Unlock()
+	for i := len(contractCalls.calls) - 1; i >= 0; i-- {
+		if contractCalls.calls[i].ContractHash == contractHash {
+			return contractCalls.calls[i]
+		}
+	}
+	t.Fatalf("contract %s was never run", contractHash)
+	return contractCall{}
+}
+
+func postJSON(t *testing.T, path string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := apiClient.Post(apiServer.URL+path, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, decodeBody(t, resp)
+}
+
+func deleteJSON(t *testing.T, path string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodDelete, apiServer.URL+path, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, decodeBody(t, resp)
+}
+
+func getJSON(t *testing.T, path string) (int, map[string]interface{}) {
+	t.Helper()
+	resp, err := apiClient.Get(apiServer.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, decodeBody(t, resp)
+}
+
+func decodeBody(t *testing.T, resp *http.Response) map[string]interface{} {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode %d response: %v", resp.StatusCode, err)
+	}
+	if resp.StatusCode >= 400 && (body["code"] == nil || body["code"] == "" || body["error"] == nil || body["error"] == "") {
+		t.Errorf("%d response is not an error envelope: %v", resp.StatusCode, body)
+	}
+	return body
+}
+
+// writeContractFiles writes the files of a minimal contract and returns
+// their paths by file name
+func writeContractFiles(t *testing.T) map[string]string {
+	t.Helper()
+	dir := t.TempDir()
+	paths := map[string]string{}
+	for name, content := range map[string]string{
+		"contract.wasm": "\x00asm\x01\x00\x00\x00",
+		"lib.rs":        "pub fn noop() {}",
+		"state.json":    "{}",
+	} {
+		paths[name] = filepath.Join(dir, name)
+		if err := os.WriteFile(paths[name], []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return paths
+}
+
+func TestDeployContract(t *testing.T) {
+	node := startNode(t)
+	paths := writeContractFiles(t)
+
+	status, body := postJSON(t, "/api/deploy-contract", DeployRequest{
+		WasmPath:    paths["contract.wasm"],
+		LibPath:     paths["lib.rs"],
+		StatePath:   paths["state.json"],
+		DeployerDid: testAdminDID,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", status, body)
+	}
+	data, _ := body["data"].(map[string]interface{})
+	hash, _ := data["ContractHash"].(string)
+	if hash == "" {
+		t.Fatalf("no contract hash in %v", body)
+	}
+	if !node.Deployed(hash) {
+		t.Fatalf("contract %s not deployed on the node", hash)
+	}
+	if url := node.CallbackURL(hash); url != "" {
+		t.Fatalf("callback registered without being asked for: %s", url)
+	}
+
+	// The callback route and its nodes come with the request
+	deploy := DeployRequest{
+		WasmPath:         paths["contract.wasm"],
+		LibPath:          paths["lib.rs"],
+		StatePath:        paths["state.json"],
+		DeployerDid:      testAdminDID,
+		CallbackEndpoint: "/api/callback/trigger",
+		CallbackNodes:    []string{"node2"},
+	}
+	status, body = postJSON(t, "/api/deploy-contract", deploy)
+	data, _ = body["data"].(map[string]interface{})
+	hash, _ = data["ContractHash"].(string)
+	if status != http.StatusOK || hash == "" {
+		t.Fatalf("deploy with a callback = %d, %v", status, body)
+	}
+	if url := node.CallbackURL(hash); !strings.HasPrefix(url, apiServer.URL+"/api/callback/trigger?") {
+		t.Fatalf("callback URL = %q", url)
+	}
+	if registration, _ := database.GetCallbackRegistration(hash, testPort); registration == nil || registration.Endpoint != "api/callback/trigger" {
+		t.Fatalf("registration = %+v", registration)
+	}
+
+	deploy.CallbackEndpoint = "api/trigger-contract-2"
+	if status, body := postJSON(t, "/api/deploy-contract", deploy); status != http.StatusBadRequest {
+		t.Fatalf("deploy with an unmounted callback route = %d, %v", status, body)
+	}
+	deploy.CallbackEndpoint, deploy.CallbackNodes = "api/callback/trigger", []string{"node9"}
+	if status, body := postJSON(t, "/api/deploy-contract", deploy); status != http.StatusBadRequest || body["code"] != string(CodeUnknownNode) {
+		t.Fatalf("deploy with an unknown callback node = %d, %v", status, body)
+	}
+}
+
+func TestAddActivity(t *testing.T) {
+	node := startNode(t)
+	registerCallback(t, node, testActivityContract, "api/callback/trigger")
+
+	status, body := postJSON(t, "/api/activity/add", AddActivityRequest{
+		ActivityID:   "yoga-101",
+		RewardPoints: 25,
+		AdminDID:     testAdminDID,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", status, body)
+	}
+
+	blocks := node.Blocks(testActivityContract)
+	latest := blocks[len(blocks)-1]
+	if !strings.Contains(latest.SmartContractData, `"activity_id":"yoga-101"`) {
+		t.Fatalf("latest block data = %s", latest.SmartContractData)
+	}
+	call := lastContractCall(t, testActivityContract)
+	want := fmt.Sprintf(`{"add_activity":{"activity_id":"yoga-101","reward_points":25,"block_hash":"%s"}}`, latest.BlockId)
+	if call.Input != want {
+		t.Fatalf("contract input = %s, want %s", call.Input, want)
+	}
+
+	// Quotes in an activity ID stay inside the ID
+	injected := `yoga","reward_points":9999,"x":"`
+	if status, body := postJSON(t, "/api/activity/add", AddActivityRequest{ActivityID: injected, RewardPoints: 5, AdminDID: testAdminDID}); status != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", status, body)
+	}
+	var payload AddActivityPayload
+	if err := json.Unmarshal([]byte(lastContractCall(t, testActivityContract).Input), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.AddActivity.ActivityID != injected || payload.AddActivity.RewardPoints != 5 {
+		t.Fatalf("contract input = %+v", payload.AddActivity)
+	}
+}
+
+func TestAddAdmin(t *testing.T) {
+	node := startNode(t)
+	registerCallback(t, node, testAdminContract, "api/callback/add-admin")
+
+	status, body := postJSON(t, "/api/admin/add", AddAdminRequest{
+		NewAdminDID:      testUserDID,
+		ExistingAdminDID: testAdminDID,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", status, body)
+	}
+
+	blocks := node.Blocks(testAdminContract)
+	latest := blocks[len(blocks)-1]
+	if latest.ExecutorDID != testAdminDID {
+		t.Fatalf("executor = %s, want %s", latest.ExecutorDID, testAdminDID)
+	}
+	// The callback stamps the record with the block and its executor
+	want := fmt.Sprintf(`{"add_admin":{"admin_did":"%s","block_id":"%s","actor_did":"%s"}}`, testUserDID, latest.BlockId, testAdminDID)
+	if call := lastContractCall(t, testAdminContract); call.Input != want {
+		t.Fatalf("contract input = %s, want %s", call.Input, want)
+	}
+}
+
+func TestTransferReward(t *testing.T) {
+	node := startNode(t)
+	registerCallback(t, node, testTransferContract, "api/call-back-trigger")
+
+	status, body := postJSON(t, "/api/rewards/transfer", TransferRewardRequest{
+		ActivityID: []string{"1", "2"},
+		UserDID:    testUserDID,
+		AdminDID:   testAdminDID,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", status, body)
+	}
+	blocks := node.Blocks(testTransferContract)
+	if got, want := body["block_id"], blocks[len(blocks)-1].BlockId; got != want {
+		t.Fatalf("block_id = %v, want %s", got, want)
+	}
+
+	transactionID, _ := body["transaction_id"].(string)
+	status, body = getJSON(t, "/api/rewards/status/"+transactionID)
+	if status != http.StatusOK {
+		t.Fatalf("status lookup = %d, body = %v", status, body)
+	}
+	data, _ := body["data"].(map[string]interface{})
+	if data["status"] != "success" {
+		t.Fatalf("stored status = %v", data["status"])
+	}
+}
+
+func TestTransferRewardAsyncCallback(t *testing.T) {
+	node := startNode(t, fakenode.WithAsyncCallbacks())
+	registerCallback(t, node, testTransferContract, "api/call-back-trigger")
+
+	status, body := postJSON(t, "/api/rewards/transfer", TransferRewardRequest{
+		ActivityID: []string{"1"},
+		UserDID:    testUserDID,
+		AdminDID:   testAdminDID,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", status, body)
+	}
+}
+
+func TestConcurrentTransfersGetTheirOwnResult(t *testing.T) {
+	node := startNode(t)
+	registerCallback(t, node, testTransferContract, "api/call-back-trigger")
+
+	const transfers = 50
+	type result struct {
+		receiver string
+		status   int
+		body     map[string]interface{}
+	}
+	results := make([]result, transfers)
+	var wg sync.WaitGroup
+	for i := 0; i < transfers; i++ {
+		receiver := fmt.Sprintf("bafybmireceiver%02d", i)
+		if i%5 == 0 {
+			receiver = fmt.Sprintf("bafybmi%sreceiver%02d", failingReceiverMarker, i)
+		}
+		wg.Add(1)
+		go func(i int, receiver string) {
+			defer wg.Done()
+			status, body := postJSON(t, "/api/rewards/transfer", TransferRewardRequest{
+				ActivityID: []string{"1"},
+				UserDID:    receiver,
+				AdminDID:   testAdminDID,
+			})
+			results[i] = result{receiver, status, body}
+		}(i, receiver)
+	}
+	wg.Wait()
+
+	blocksByID := map[string]rubix_interaction.SmartContractBlock{}
+	for _, block := range node.Blocks(testTransferContract) {
+		blocksByID[block.BlockId] = block
+	}
+	seenBlocks := map[string]bool{}
+	for _, r := range results {
+		wantStatus := http.StatusOK
+		if strings.Contains(r.receiver, failingReceiverMarker) {
+			wantStatus = http.StatusInternalServerError
+		}
+		if r.status != wantStatus {
+			t.Errorf("%s: status = %d, want %d, body = %v", r.receiver, r.status, wantStatus, r.body)
+			continue
+		}
+
+		blockID, _ := r.body["block_id"].(string)
+		block, ok := blocksByID[blockID]
+		if !ok {
+			t.Errorf("%s: block %q is not on the chain", r.receiver, blockID)
+			continue
+		}
+		if seenBlocks[blockID] {
+			t.Errorf("%s: block %s was handed to two transfers", r.receiver, blockID)
+		}
+		seenBlocks[blockID] = true
+		if !strings.Contains(block.SmartContractData, r.receiver) {
+			t.Errorf("%s: got block for another transfer: %s", r.receiver, block.SmartContractData)
+		}
+		transactionID, _ := r.body["transaction_id"].(string)
+		if !strings.Contains(block.SmartContractData, "[ref:"+transactionID+"]") {
+			t.Errorf("%s: block does not carry ref %s", r.receiver, transactionID)
+		}
+		if calls := contractCallsMatching(testTransferContract, r.receiver); len(calls) != 1 {
+			t.Errorf("%s: contract ran %d times, want 1", r.receiver, len(calls))
+		}
+	}
+}
+
+func createTransfer(t *testing.T, receiver string) string {
+	t.Helper()
+	requestID, err := NewTransferRequestID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = GetTransferManager().CreateTransfer(requestID, "", testTransferContract, []string{"1"}, receiver, testAdminDID, Reward{Points: 10, Tokens: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return requestID
+}
+
+func transferStatus(t *testing.T, requestID string) *database.TransferStatus {
+	t.Helper()
+	status, err := database.GetTransferStatus(requestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestAsyncTransferStatusStream(t *testing.T) {
+	node := startNode(t, fakenode.WithAsyncCallbacks())
+	registerCallback(t, node, testTransferContract, "api/call-back-trigger")
+
+	status, body := postJSON(t, "/api/rewards/transfer?async=true", TransferRewardRequest{
+		ActivityID: []string{"1"},
+		UserDID:    "bafybmiasyncreceiver",
+		AdminDID:   testAdminDID,
+	})
+	if status != http.StatusAccepted {
+		t.Fatalf("status = %d, body = %v", status, body)
+	}
+	transactionID, _ := body["transaction_id"].(string)
+
+	req, err := http.NewRequest(http.MethodGet, apiServer.URL+"/api/rewards/status/"+transactionID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); !strings.HasPrefix(got, "text/event-stream") {
+		t.Fatalf("Content-Type = %q", got)
+	}
+
+	// The stream ends once the transfer reaches a final status
+	var statuses []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var event database.TransferStatus
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("bad event %q: %v", data, err)
+		}
+		statuses = append(statuses, event.Status)
+	}
+	if len(statuses) == 0 || statuses[len(statuses)-1] != "success" {
+		t.Fatalf("streamed statuses = %v", statuses)
+	}
+
+	status, body = getJSON(t, "/api/rewards/status/"+transactionID+"?wait=5s")
+	data, _ := body["data"].(map[string]interface{})
+	if status != http.StatusOK || data["status"] != "success" {
+		t.Fatalf("long-poll = %d, %v", status, body)
+	}
+}
+
+func TestLongPollReturnsOnTransition(t *testing.T) {
+	requestID := createTransfer(t, "bafybmilongpollreceiver")
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		GetTransferManager().MarkFailed(requestID, "Failed to execute smart contract", fmt.Errorf("node unavailable"))
+	}()
+
+	started := time.Now()
+	status, body := getJSON(t, "/api/rewards/status/"+requestID+"?wait=10s")
+	data, _ := body["data"].(map[string]interface{})
+	if status != http.StatusOK || data["status"] != "failed" {
+		t.Fatalf("long-poll = %d, %v", status, body)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("long-poll took %s", elapsed)
+	}
+}
+
+func postTransferWithKey(t *testing.T, key string, req TransferRewardRequest) (*http.Response, map[string]interface{}) {
+	t.Helper()
+	payload, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, apiServer.URL+"/api/rewards/transfer", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Idempotency-Key", key)
+	resp, err := apiClient.Do(httpReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	return resp, decodeBody(t, resp)
+}
+
+func TestIdempotentTransferRetry(t *testing.T) {
+	node := startNode(t)
+	registerCallback(t, node, testTransferContract, "api/call-back-trigger")
+
+	req := TransferRewardRequest{
+		ActivityID: []string{"1", "2"},
+		UserDID:    "bafybmiidempotentreceiver",
+		AdminDID:   testAdminDID,
+	}
+	first, firstBody := postTransferWithKey(t, "kiosk-retry-1", req)
+	if first.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", first.StatusCode, firstBody)
+	}
+
+	// The body field works as well as the header, in any activity order
+	req.ActivityID = []string{"2", "1"}
+	req.IdempotencyKey = "kiosk-retry-1"
+	status, retryBody := postJSON(t, "/api/rewards/transfer", req)
+	if status != http.StatusOK {
+		t.Fatalf("retry status = %d, body = %v", status, retryBody)
+	}
+	if retryBody["transaction_id"] != firstBody["transaction_id"] || retryBody["block_id"] != firstBody["block_id"] {
+		t.Fatalf("retry = %v, first = %v", retryBody, firstBody)
+	}
+	if calls := contractCallsMatching(testTransferContract, req.UserDID); len(calls) != 1 {
+		t.Fatalf("contract ran %d times, want 1", len(calls))
+	}
+
+	req.UserDID = "bafybmisomeoneelse"
+	mismatch, body := postTransferWithKey(t, "kiosk-retry-1", req)
+	if mismatch.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("reused key status = %d, body = %v", mismatch.StatusCode, body)
+	}
+}
+
+func TestIdempotentTransferConcurrentRetries(t *testing.T) {
+	node := startNode(t)
+	registerCallback(t, node, testTransferContract, "api/call-back-trigger")
+
+	req := TransferRewardRequest{
+		ActivityID: []string{"3"},
+		UserDID:    "bafybmiconcurrentidempotentreceiver",
+		AdminDID:   testAdminDID,
+	}
+	const attempts = 5
+	transactionIDs := make([]interface{}, attempts)
+	statuses := make([]int, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, body := postTransferWithKey(t, "kiosk-retry-2", req)
+			statuses[i] = resp.StatusCode
+			transactionIDs[i] = body["transaction_id"]
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range statuses {
+		if statuses[i] != http.StatusOK || transactionIDs[i] != transactionIDs[0] {
+			t.Fatalf("attempt %d: status = %d, transaction_id = %v, want %v", i, statuses[i], transactionIDs[i], transactionIDs[0])
+		}
+	}
+	if calls := contractCallsMatching(testTransferContract, req.UserDID); len(calls) != 1 {
+		t.Fatalf("contract ran %d times, want 1", len(calls))
+	}
+}
+
+// callWith makes a request with the given credential header instead of the
+// test API key
+func callWith(t *testing.T, method string, path string, header string, value string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(method, apiServer.URL+path, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, decodeBody(t, resp)
+}
+
+func TestSigningCredentialsComeFromKeystore(t *testing.T) {
+	const password = "keystore-password"
+	node := startNode(t, fakenode.WithPassword(password))
+	registerCallback(t, node, testActivityContract, "api/callback/trigger")
+	t.Cleanup(func() { rubix_interaction.SetCredentialProvider(rubix_interaction.EnvCredentials{}) })
+
+	// The environment's password is refused by this node
+	ctx := context.Background()
+	client := newNodeClient(testPort)
+	executeID, err := client.ExecuteSmartContract(ctx, rubix_interaction.NewExecuteSmartContractRequest(
+		testActivityContract, testAdminDID, `{"add_activity": {"activity_id":"env-signed","reward_points":10}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rubix_interaction.Sign(ctx, client, testAdminDID, executeID); err == nil {
+		t.Fatal("node accepted the env password")
+	}
+
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	keystore, err := rubix_interaction.OpenKeystore(path, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keystore.Set(testAdminDID, rubix_interaction.Credential{Mode: rubix_interaction.SignatureModeStandard, Password: password})
+	if err := keystore.Save(); err != nil {
+		t.Fatal(err)
+	}
+	credentials, err := rubix_interaction.LoadCredentialProvider(path, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rubix_interaction.SetCredentialProvider(credentials)
+	activity := AddActivityRequest{ActivityID: "keystore-signed", RewardPoints: 10, AdminDID: testAdminDID}
+	if status, body := postJSON(t, "/api/activity/add", activity); status != http.StatusOK {
+		t.Fatalf("add activity signed from the keystore = %d, %v", status, body)
+	}
+}
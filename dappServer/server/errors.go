@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	rubix_interaction "dapp-server/rubix-interaction"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorCode is the machine-readable "code" of an error response. Clients
+// branch on the code; the "error" message is for people and may change.
+type ErrorCode string
+
+const (
+	// The request itself is at fault (4xx)
+	CodeInvalidRequest       ErrorCode = "invalid_request"
+	CodeValidationFailed     ErrorCode = "validation_failed"
+	CodeUnknownNode          ErrorCode = "unknown_node"
+	CodeUnauthenticated      ErrorCode = "unauthenticated"
+	CodeForbidden            ErrorCode = "forbidden"
+	CodeNotAdmin             ErrorCode = "not_admin"
+	CodeCallbackRejected     ErrorCode = "callback_rejected"
+	CodeNotFound             ErrorCode = "not_found"
+	CodeUnknownActivities    ErrorCode = "unknown_activities"
+	CodeDuplicateClaims      ErrorCode = "duplicate_claims"
+	CodeLastAdmin            ErrorCode = "last_admin"
+	CodeRewardBelowOneToken  ErrorCode = "reward_below_one_token"
+	CodeRewardTooLarge       ErrorCode = "reward_too_large"
+	CodeIdempotencyKeyReused ErrorCode = "idempotency_key_reused"
+	CodeUnexpectedBlock      ErrorCode = "unexpected_block"
+	CodeContractHasNoRole    ErrorCode = "contract_has_no_role"
+	CodeUploadTooLarge       ErrorCode = "upload_too_large"
+	CodeTooManyRequests      ErrorCode = "too_many_requests"
+
+	// The server or a node it depends on is at fault (5xx)
+	CodeInternal             ErrorCode = "internal_error"
+	CodeNotConfigured        ErrorCode = "not_configured"
+	CodeSigningUnavailable   ErrorCode = "signing_unavailable"
+	CodeNodeRejected         ErrorCode = "node_rejected"
+	CodeNodeUnavailable      ErrorCode = "node_unavailable"
+	CodeNodeTimeout          ErrorCode = "node_timeout"
+	CodeContractFailed       ErrorCode = "contract_failed"
+	CodeTransferFailed       ErrorCode = "transfer_failed"
+	CodeCallbackRegistration ErrorCode = "callback_registration_failed"
+)
+
+// ErrorResponse is the body of every error the API returns. Some errors add
+// fields of their own, such as the activity IDs that were not recognised.
+type ErrorResponse struct {
+	Code    ErrorCode   `json:"code"`
+	Error   string      `json:"error"`
+	Details string      `json:"details,omitempty"`
+	Fields  FieldErrors `json:"fields,omitempty"`
+}
+
+// respondError aborts the request with the error envelope. err, if not nil,
+// becomes the details; extra adds error-specific fields to the body.
+func respondError(c *gin.Context, status int, code ErrorCode, message string, err error, extra ...gin.H) {
+	body := gin.H{"code": code, "error": message}
+	if err != nil {
+		body["details"] = err.Error()
+	}
+	for _, fields := range extra {
+		for key, value := range fields {
+			body[key] = value
+		}
+	}
+	c.AbortWithStatusJSON(status, body)
+}
+
+// respondNodeError reports a failed call to a Rubix node: 504 if the node
+// did not answer in time, 502 if it could not be reached or rejected the
+// call, and 500 if the server could not produce a signature for it
+func respondNodeError(c *gin.Context, message string, err error) {
+	status, code := nodeErrorStatus(err)
+	fmt.Printf("%s: %v\n", message, err)
+	respondError(c, status, code, message, err)
+}
+
+func nodeErrorStatus(err error) (int, ErrorCode) {
+	var nodeErr *rubix_interaction.NodeError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, rubix_interaction.ErrNoCredential), errors.Is(err, rubix_interaction.ErrUnsupportedSignatureMode):
+		return http.StatusInternalServerError, CodeSigningUnavailable
+	case errors.As(err, &nodeErr):
+		return http.StatusBadGateway, CodeNodeRejected
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return http.StatusGatewayTimeout, CodeNodeTimeout
+	default:
+		return http.StatusBadGateway, CodeNodeUnavailable
+	}
+}
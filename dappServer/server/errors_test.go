@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"dapp-server/database"
+	rubix_interaction "dapp-server/rubix-interaction"
+)
+
+func TestFailurePathsReturnErrorEnvelope(t *testing.T) {
+	node := startNode(t)
+	activity := func(id string, points int, admin string) AddActivityRequest {
+		return AddActivityRequest{ActivityID: id, RewardPoints: points, AdminDID: admin}
+	}
+	expect := func(t *testing.T, status int, body map[string]interface{}, wantStatus int, wantCode ErrorCode, wantFields ...string) {
+		t.Helper()
+		if status != wantStatus || body["code"] != string(wantCode) {
+			t.Fatalf("got %d %v, want %d %s", status, body, wantStatus, wantCode)
+		}
+		fields, _ := body["fields"].(map[string]interface{})
+		for _, field := range wantFields {
+			if fields[field] == nil {
+				t.Fatalf("no problem reported for %s: %v", field, body)
+			}
+		}
+	}
+	routeNode := func(t *testing.T, url string, opts ...rubix_interaction.ClientOption) {
+		previous := newNodeClient
+		newNodeClient = func(string) rubix_interaction.NodeClient {
+			return rubix_interaction.NewRubixClient(url, opts...)
+		}
+		t.Cleanup(func() { newNodeClient = previous })
+	}
+
+	t.Run("malformed JSON", func(t *testing.T) {
+		resp, err := apiClient.Post(apiServer.URL+"/api/rewards/transfer", "application/json", strings.NewReader("{not json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		expect(t, resp.StatusCode, decodeBody(t, resp), http.StatusBadRequest, CodeInvalidRequest)
+	})
+	t.Run("invalid fields", func(t *testing.T) {
+		status, body := postJSON(t, "/api/activity/add", activity(" padded ", 0, testAdminDID))
+		expect(t, status, body, http.StatusBadRequest, CodeValidationFailed, "activity_id", "reward_points")
+		// The contract records points as a uint32; 2^32 would wrap to 0
+		before := len(node.Blocks(testActivityContract))
+		status, body = postJSON(t, "/api/activity/add", activity("overflow-run", 1<<32, testAdminDID))
+		expect(t, status, body, http.StatusBadRequest, CodeValidationFailed, "reward_points")
+		if after := len(node.Blocks(testActivityContract)); after != before {
+			t.Fatalf("an overflowing reward reached the contract: %d blocks, was %d", after, before)
+		}
+		status, body = postJSON(t, "/api/rewards/transfer", TransferRewardRequest{ActivityID: []string{}, UserDID: "alice", AdminDID: testAdminDID})
+		expect(t, status, body, http.StatusBadRequest, CodeValidationFailed, "activity_id", "user_did")
+		status, body = postJSON(t, "/api/execute-contract", ExecuteRequest{ContractHash: testActivityContract, ExecutorDid: testAdminDID})
+		expect(t, status, body, http.StatusBadRequest, CodeValidationFailed, "contract_input")
+		status, body = postJSON(t, "/api/deploy-contract", DeployRequest{DeployerDid: testAdminDID, WasmPath: "missing.wasm"})
+		expect(t, status, body, http.StatusBadRequest, CodeValidationFailed, "wasm_path", "lib_path", "state_path")
+		status, body = deleteJSON(t, "/api/admins/not-a-did", RemoveAdminRequest{ExistingAdminDID: testAdminDID})
+		expect(t, status, body, http.StatusBadRequest, CodeValidationFailed, "did")
+	})
+	t.Run("admin without a node", func(t *testing.T) {
+		const nodeless = "bafybmiadminwithoutanodeadminwithoutanode"
+		if _, err := database.AddAdmin(nodeless); err != nil {
+			t.Fatal(err)
+		}
+		getAdminSet().Invalidate()
+		t.Cleanup(func() {
+			database.RemoveAdmin(nodeless)
+			getAdminSet().Invalidate()
+		})
+		key, err := CreateAPIKey("nodeless-admin", []string{RoleAdmin}, nodeless)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, body := callWith(t, http.MethodPost, "/api/activity/add", apiKeyHeader, key, activity("nodeless-run", 10, nodeless))
+		expect(t, status, body, http.StatusBadRequest, CodeUnknownNode)
+	})
+	t.Run("unknown activity", func(t *testing.T) {
+		status, body := getJSON(t, "/api/activities/never-registered")
+		expect(t, status, body, http.StatusNotFound, CodeNotFound)
+	})
+	t.Run("node rejects the signature", func(t *testing.T) {
+		t.Setenv("RUBIX_SIGNATURE_PASSWORD", "not-the-node-password")
+		status, body := postJSON(t, "/api/activity/add", activity("rejected-run", 10, testAdminDID))
+		expect(t, status, body, http.StatusBadGateway, CodeNodeRejected)
+	})
+	t.Run("no signing credential", func(t *testing.T) {
+		rubix_interaction.SetCredentialProvider(rubix_interaction.ChainCredentials{})
+		t.Cleanup(func() { rubix_interaction.SetCredentialProvider(rubix_interaction.EnvCredentials{}) })
+		status, body := postJSON(t, "/api/activity/add", activity("unsigned-run", 10, testAdminDID))
+		expect(t, status, body, http.StatusInternalServerError, CodeSigningUnavailable)
+	})
+	t.Run("node down", func(t *testing.T) {
+		down := httptest.NewServer(http.NotFoundHandler())
+		down.Close()
+		routeNode(t, down.URL)
+		status, body := postJSON(t, "/api/activity/add", activity("unreachable-run", 10, testAdminDID))
+		expect(t, status, body, http.StatusBadGateway, CodeNodeUnavailable)
+	})
+	t.Run("node too slow", func(t *testing.T) {
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(io.Discard, r.Body) // so the server notices the client giving up
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}))
+		t.Cleanup(slow.Close)
+		routeNode(t, slow.URL, rubix_interaction.WithTimeout(50*time.Millisecond))
+		status, body := postJSON(t, "/api/activity/add", activity("slow-run", 10, testAdminDID))
+		expect(t, status, body, http.StatusGatewayTimeout, CodeNodeTimeout)
+	})
+	t.Run("callback for a block without an activity", func(t *testing.T) {
+		registerCallback(t, node, testAdminContract, "api/callback/trigger")
+		client := newNodeClient(testPort)
+		executeID, err := client.ExecuteSmartContract(context.Background(), rubix_interaction.NewExecuteSmartContractRequest(
+			testAdminContract, testAdminDID, `{"add_admin":{"admin_did":"`+testUserDID+`"}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rubix_interaction.Sign(context.Background(), client, testAdminDID, executeID); err != nil {
+			t.Fatal(err)
+		}
+		node.Wait()
+		registration, _ := database.GetCallbackRegistration(testAdminContract, testPort)
+		status, body := postJSON(t, "/api/callback/trigger?"+rubix_interaction.CallbackTokenParam+"="+registration.Secret,
+			ContractInputRequest{Port: testPort, SmartContractHash: testAdminContract})
+		expect(t, status, body, http.StatusUnprocessableEntity, CodeUnexpectedBlock)
+	})
+}
+
+func TestNodeErrorStatus(t *testing.T) {
+	for name, tc := range map[string]struct {
+		err        error
+		wantStatus int
+		wantCode   ErrorCode
+	}{
+		"no credential":     {fmt.Errorf("sign: %w", rubix_interaction.ErrNoCredential), http.StatusInternalServerError, CodeSigningUnavailable},
+		"wallet mode":       {rubix_interaction.ErrUnsupportedSignatureMode, http.StatusInternalServerError, CodeSigningUnavailable},
+		"node rejected":     {fmt.Errorf("deploy: %w", &rubix_interaction.NodeError{Endpoint: "/api/deploy", Message: "bad DID"}), http.StatusBadGateway, CodeNodeRejected},
+		"deadline":          {fmt.Errorf("execute: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, CodeNodeTimeout},
+		"connection failed": {errors.New("connection refused"), http.StatusBadGateway, CodeNodeUnavailable},
+	} {
+		if status, code := nodeErrorStatus(tc.err); status != tc.wantStatus || code != tc.wantCode {
+			t.Errorf("%s: nodeErrorStatus = %d %s, want %d %s", name, status, code, tc.wantStatus, tc.wantCode)
+		}
+	}
+}
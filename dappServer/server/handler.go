@@ -1,11 +1,11 @@
 package server
 
 import (
-	"dapp-server/config"
+	"dapp-server/database"
 	rubix_interaction "dapp-server/rubix-interaction"
-	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 )
@@ -15,63 +15,192 @@ type AddAdminRequest struct {
 	ExistingAdminDID string `json:"existing_admin_did"`
 }
 
+func (r AddAdminRequest) Validate() FieldErrors {
+	problems := FieldErrors{}
+	problems.DID("new_admin_did", r.NewAdminDID)
+	problems.DID("existing_admin_did", r.ExistingAdminDID)
+	return problems
+}
+
 func APIAddAdmin(c *gin.Context) {
 	fmt.Println("APIAddAdmin triggered")
 	var req AddAdminRequest
-	err := json.NewDecoder(c.Request.Body).Decode(&req)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
-		fmt.Printf("Error reading response body: %s\n", err)
+	if !bindRequest(c, &req) {
 		return
 	}
 	fmt.Println("The request body is:", req)
-	cfg, err := config.GetConfig()
+	contractMsg, err := rubix_interaction.ContractMessage(rubix_interaction.AddAdminReq{AdminDID: req.NewAdminDID})
 	if err != nil {
-		fmt.Println("failed to load config: %w", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to build contract message", err)
+		return
 	}
-	nodePort, exists := config.GetPortByDid(cfg, req.ExistingAdminDID)
-	if !exists {
-		fmt.Println("failed to get node port: not found")
+	block, ok := executeAdminContract(c, req.ExistingAdminDID, contractMsg)
+	if !ok {
 		return
 	}
-	fmt.Println("The node port is:", nodePort)
-	url := fmt.Sprintf("http://localhost:%s", nodePort)
-	fmt.Println("The url is :", url)
-	contractMsg := fmt.Sprintf(`{"add_admin": {"admin_did":"%s"}}`, req.NewAdminDID)
-	fmt.Println("The contract message is:", contractMsg)
-	smartContractHash := config.GetEnvConfig().AddAdminContract //Loading the smart contract hash from config
-	if smartContractHash == "" {
-		fmt.Println("Smart contract hash is not set in the config")
+	resultFinal := gin.H{
+		"message": "Admin added to smart contract tokenchain",
+		"data":    block,
+	}
+
+	// Return a response
+	c.JSON(http.StatusOK, resultFinal)
+
+}
+
+type RemoveAdminRequest struct {
+	ExistingAdminDID string `json:"existing_admin_did"`
+}
+
+func (r RemoveAdminRequest) Validate() FieldErrors {
+	problems := FieldErrors{}
+	problems.DID("existing_admin_did", r.ExistingAdminDID)
+	return problems
+}
+
+// adminRemovals serializes admin removals. A removal is pending from its
+// submission until its callback has recorded it, and the last-admin check
+// counts pending removals as done, so two removals cannot both pass it.
+var adminRemovals = struct {
+	sync.Mutex
+	pending map[string]bool
+}{pending: make(map[string]bool)}
+
+// APIRemoveAdmin removes the admin named in the path through the
+// remove_admin function of the add_admin contract. Admins from
+// BOOTSTRAP_ADMIN_DIDS are not recorded on chain and cannot be removed this
+// way, and the last recorded admin is never removed.
+func APIRemoveAdmin(c *gin.Context) {
+	fmt.Println("APIRemoveAdmin triggered")
+	adminDID := c.Param("did")
+	if !validDIDParam(c, adminDID) {
+		return
+	}
+	var req RemoveAdminRequest
+	if !bindRequest(c, &req) {
 		return
 	}
-	smartContractResponse, err := rubix_interaction.ExecuteSmartContract(url, smartContractHash, req.ExistingAdminDID, contractMsg)
+
+	// Held until the removal is on chain, so a concurrent removal sees it
+	adminRemovals.Lock()
+	defer adminRemovals.Unlock()
+	admins, err := database.ListAdmins()
 	if err != nil {
-		fmt.Println("failed to execute smart contract:", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to load admins", err)
 		return
 	}
-	fmt.Println("Smart contract response:", smartContractResponse)
-	_, err = rubix_interaction.SignatureResponse(url, smartContractResponse)
+	found := false
+	recorded := make(map[string]bool, len(admins))
+	remaining := 0
+	for _, admin := range admins {
+		recorded[admin.AdminDID] = true
+		found = found || admin.AdminDID == adminDID
+		if admin.AdminDID != adminDID && !adminRemovals.pending[admin.AdminDID] {
+			remaining++
+		}
+	}
+	// A removal stops being pending once its callback has recorded it
+	for did := range adminRemovals.pending {
+		if !recorded[did] {
+			delete(adminRemovals.pending, did)
+		}
+	}
+	if !found {
+		respondError(c, http.StatusNotFound, CodeNotFound, "Admin not found", fmt.Errorf("%s is not a recorded admin", adminDID))
+		return
+	}
+	if remaining == 0 {
+		respondError(c, http.StatusConflict, CodeLastAdmin, database.ErrLastAdmin.Error(), nil)
+		return
+	}
+
+	contractMsg, err := rubix_interaction.ContractMessage(rubix_interaction.RemoveAdminReq{AdminDID: adminDID})
 	if err != nil {
-		fmt.Println("failed to send signature response:", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to build contract message", err)
 		return
 	}
-	fmt.Println("Signature response sent successfully")
-	addAdminContractHash := config.GetEnvConfig().AddAdminContract //Loading the smart contract hash from config
-	if addAdminContractHash == "" {
-		fmt.Println("addAdminContractHash is not set in the config")
+	adminRemovals.pending[adminDID] = true
+	block, ok := executeAdminContract(c, req.ExistingAdminDID, contractMsg)
+	if !ok {
+		delete(adminRemovals.pending, adminDID)
 		return
 	}
-	block := rubix_interaction.GetSmartContractData(addAdminContractHash, url) //config.NodeAddress)
-	if block == nil {
-		fmt.Println("Unable to fetch latest smart contract data")
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Admin removed from smart contract tokenchain",
+		"data":    block,
+	})
+}
+
+// executeAdminContract runs contractMsg on the add_admin contract as
+// actingDID and returns the block it produced. On failure the response has
+// been written and ok is false.
+func executeAdminContract(c *gin.Context, actingDID string, contractMsg string) (block *rubix_interaction.SmartContractBlock, ok bool) {
+	nodePort, ok := nodePortFor(c, "existing_admin_did", actingDID)
+	if !ok {
+		return nil, false
+	}
+	fmt.Println("The node port is:", nodePort)
+	fmt.Println("The contract message is:", contractMsg)
+	smartContractHash, ok := contractHashFor(c, database.ContractRoleAdmin)
+	if !ok {
+		return nil, false
+	}
+	return executeContractBlock(c, nodePort, smartContractHash, actingDID, contractMsg)
+}
+
+// APIListAdmins lists the admins recorded by the add_admin contract, and
+// separately the bootstrap admins configured in BOOTSTRAP_ADMIN_DIDS
+func APIListAdmins(c *gin.Context) {
+	admins, err := database.ListAdmins()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to load admins", err)
 		return
 	}
-	resultFinal := gin.H{
-		"message": "Admin added to smart contract tokenchain",
-		"data":    string(block),
+	if admins == nil {
+		admins = []database.AdminRecord{}
 	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":           true,
+		"data":             admins,
+		"bootstrap_admins": getAdminSet().Bootstrap(),
+	})
+}
 
-	// Return a response
-	c.JSON(http.StatusOK, resultFinal)
+// APIListAdminEvents returns the history of admin additions and removals,
+// optionally only for ?admin_did=
+func APIListAdminEvents(c *gin.Context) {
+	events, err := database.ListAdminEvents(c.Query("admin_did"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to load admin history", err)
+		return
+	}
+	if events == nil {
+		events = []database.AdminEvent{}
+	}
+	c.JSON(http.StatusOK, gin.H{"status": true, "data": events})
+}
 
+// executeContractBlock executes contractMsg on a contract as did, signs it
+// and returns the block it produced. On failure the error response has been
+// written and ok is false.
+func executeContractBlock(c *gin.Context, nodePort string, contractHash string, did string, contractMsg string) (block *rubix_interaction.SmartContractBlock, ok bool) {
+	ctx := c.Request.Context()
+	client := newNodeClient(nodePort)
+	smartContractResponse, err := client.ExecuteSmartContract(ctx, rubix_interaction.NewExecuteSmartContractRequest(contractHash, did, contractMsg))
+	if err != nil {
+		respondNodeError(c, "Failed to execute smart contract", err)
+		return nil, false
+	}
+	fmt.Println("Smart contract response:", smartContractResponse)
+	if _, err := rubix_interaction.Sign(ctx, client, did, smartContractResponse); err != nil {
+		respondNodeError(c, "Failed to sign contract execution", err)
+		return nil, false
+	}
+	fmt.Println("Signature response sent successfully")
+	block, err = rubix_interaction.GetLatestBlock(ctx, client, contractHash)
+	if err != nil {
+		respondNodeError(c, "Unable to fetch latest smart contract data", err)
+		return nil, false
+	}
+	return block, true
 }
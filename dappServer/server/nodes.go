@@ -0,0 +1,34 @@
+package server
+
+import (
+	"dapp-server/config"
+	rubix_interaction "dapp-server/rubix-interaction"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newNodeClient returns the client for the Rubix node listening on port.
+// Handlers only reach nodes through it, so tests can point them at a fake node.
+var newNodeClient = func(port string) rubix_interaction.NodeClient {
+	return rubix_interaction.NewRubixClient(rubix_interaction.LocalNodeURL(port))
+}
+
+// nodePortFor returns the port of the node that holds did, the DID sent in
+// field. On failure the error response has been written and ok is false.
+func nodePortFor(c *gin.Context, field string, did string) (port string, ok bool) {
+	cfg, err := config.GetConfig()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeNotConfigured, "Node config is not loaded", err)
+		return "", false
+	}
+	port, exists := config.GetPortByDid(cfg, did)
+	if !exists {
+		fmt.Printf("No node is configured for %s\n", did)
+		respondError(c, http.StatusBadRequest, CodeUnknownNode, "Node port not found for DID",
+			fmt.Errorf("%s is not the DID of a configured node", field))
+		return "", false
+	}
+	return port, true
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"dapp-server/config"
+	"dapp-server/database"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultReconcileInterval = time.Minute
+	defaultReconcileMaxAge   = 24 * time.Hour
+)
+
+// ReconcilerStats describes the reconciler's most recent pass and its totals
+// since the server started
+type ReconcilerStats struct {
+	Running         bool      `json:"running"`
+	Interval        string    `json:"interval"`
+	MaxAge          string    `json:"max_age"`
+	Runs            int       `json:"runs"`
+	LastRunStarted  time.Time `json:"last_run_started"`
+	LastRunFinished time.Time `json:"last_run_finished"`
+	LastRunDuration string    `json:"last_run_duration"`
+	LastChecked     int       `json:"last_checked"`
+	LastSettled     int       `json:"last_settled"`
+	LastExpired     int       `json:"last_expired"`
+	LastErrors      []string  `json:"last_errors"`
+	TotalSettled    int       `json:"total_settled"`
+	TotalExpired    int       `json:"total_expired"`
+	TotalErrors     int       `json:"total_errors"`
+}
+
+// Reconciler periodically re-checks transfers that are still "pending" or
+// "timeout" against the token chain. A transfer whose block has been
+// committed is settled as if its callback had arrived; one that still has
+// no block after maxAge is given up on and marked failed, but only once its
+// chain has been read.
+type Reconciler struct {
+	manager  *TransferManager
+	interval time.Duration
+	maxAge   time.Duration
+
+	// runMu keeps passes from overlapping
+	runMu   sync.Mutex
+	statsMu sync.RWMutex
+	stats   ReconcilerStats
+}
+
+var (
+	reconciler     *Reconciler
+	reconcilerOnce sync.Once
+)
+
+// GetReconciler returns the singleton instance
+func GetReconciler() *Reconciler {
+	reconcilerOnce.Do(func() {
+		envConfig := config.GetEnvConfig()
+		reconciler = &Reconciler{
+			manager:  GetTransferManager(),
+			interval: parseDurationOr("RECONCILE_INTERVAL", envConfig.ReconcileInterval, defaultReconcileInterval),
+			maxAge:   parseDurationOr("RECONCILE_MAX_AGE", envConfig.ReconcileMaxAge, defaultReconcileMaxAge),
+		}
+		reconciler.stats.Interval = reconciler.interval.String()
+		reconciler.stats.MaxAge = reconciler.maxAge.String()
+	})
+	return reconciler
+}
+
+func parseDurationOr(name string, value string, fallback time.Duration) time.Duration {
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		fmt.Printf("Invalid %s %q, using %s\n", name, value, fallback)
+		return fallback
+	}
+	return duration
+}
+
+// Start runs a pass every interval until ctx is cancelled
+func (r *Reconciler) Start(ctx context.Context) {
+	r.statsMu.Lock()
+	r.stats.Running = true
+	r.statsMu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		defer func() {
+			r.statsMu.Lock()
+			r.stats.Running = false
+			r.statsMu.Unlock()
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.RunOnce(ctx)
+			}
+		}
+	}()
+}
+
+// RunOnce makes a single reconciliation pass and returns the updated stats
+func (r *Reconciler) RunOnce(ctx context.Context) ReconcilerStats {
+	r.runMu.Lock()
+	defer r.runMu.Unlock()
+
+	started := time.Now()
+	checked, settled, expired, errs := r.reconcile(ctx, started)
+	finished := time.Now()
+
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	r.stats.Runs++
+	r.stats.LastRunStarted = started
+	r.stats.LastRunFinished = finished
+	r.stats.LastRunDuration = finished.Sub(started).String()
+	r.stats.LastChecked = checked
+	r.stats.LastSettled = settled
+	r.stats.LastExpired = expired
+	r.stats.LastErrors = errs
+	r.stats.TotalSettled += settled
+	r.stats.TotalExpired += expired
+	r.stats.TotalErrors += len(errs)
+	if len(errs) > 0 || settled > 0 || expired > 0 {
+		fmt.Printf("Reconciler pass: checked=%d settled=%d expired=%d errors=%d\n", checked, settled, expired, len(errs))
+	}
+	return r.copyStatsLocked()
+}
+
+// Stats returns a snapshot of the reconciler's stats
+func (r *Reconciler) Stats() ReconcilerStats {
+	r.statsMu.RLock()
+	defer r.statsMu.RUnlock()
+	return r.copyStatsLocked()
+}
+
+func (r *Reconciler) copyStatsLocked() ReconcilerStats {
+	stats := r.stats
+	stats.LastErrors = append([]string(nil), r.stats.LastErrors...)
+	return stats
+}
+
+func (r *Reconciler) reconcile(ctx context.Context, now time.Time) (checked int, settled int, expired int, errs []string) {
+	unsettled, err := database.ListUnsettledTransfers(now)
+	if err != nil {
+		return 0, 0, 0, []string{err.Error()}
+	}
+
+	// "processing" transfers have a block and a contract run in progress
+	var open []*database.TransferStatus
+	for _, transfer := range unsettled {
+		if transfer.Status == "pending" || transfer.Status == "timeout" {
+			open = append(open, transfer)
+		}
+	}
+	if len(open) == 0 {
+		return 0, 0, 0, nil
+	}
+
+	responses, unchecked, errs := r.manager.settleOpenTransfers(ctx, open)
+	settledIDs := make(map[string]bool, len(responses))
+	for _, response := range responses {
+		settledIDs[response.RequestID] = true
+	}
+
+	for _, transfer := range open {
+		// A transfer whose chain could not be read may have been committed;
+		// failing it would free its claims to be paid again
+		if settledIDs[transfer.RequestID] || unchecked[transfer.RequestID] || now.Sub(transfer.CreatedAt) < r.maxAge {
+			continue
+		}
+		gaveUp, err := database.UpdateTransferStatusIf(transfer.RequestID, []string{"pending", "timeout"}, map[string]interface{}{
+			"status":        "failed",
+			"message":       "Transfer was never confirmed on the blockchain",
+			"error_details": fmt.Sprintf("no block found for the transfer within %s", r.maxAge),
+		})
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", transfer.RequestID, err))
+			continue
+		}
+		if gaveUp {
+			expired++
+			if err := database.ReleaseActivityClaims(transfer.RequestID); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", transfer.RequestID, err))
+			}
+		}
+	}
+	return len(open), len(responses), expired, errs
+}
+
+// APIGetReconcilerStatus reports the transfer reconciler's last run
+func APIGetReconcilerStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": true,
+		"data":   GetReconciler().Stats(),
+	})
+}
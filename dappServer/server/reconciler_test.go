@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dapp-server/database"
+	rubix_interaction "dapp-server/rubix-interaction"
+)
+
+func TestReconcilerSettlesTimedOutTransfers(t *testing.T) {
+	startNode(t)
+
+	requestID := commitTransfer(t, "bafybmireconciledreceiver")
+	if err := GetTransferManager().MarkTimeout(requestID); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := GetReconciler().RunOnce(context.Background())
+	if stats.LastSettled < 1 || len(stats.LastErrors) != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if got := transferStatus(t, requestID); got.Status != "success" || got.BlockId == "" {
+		t.Fatalf("transfer = %s with block %q", got.Status, got.BlockId)
+	}
+
+	status, body := getJSON(t, "/api/admin/reconciler")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", status, body)
+	}
+	data, _ := body["data"].(map[string]interface{})
+	if runs, _ := data["runs"].(float64); runs < 1 {
+		t.Fatalf("runs = %v", data["runs"])
+	}
+}
+
+func TestReconcilerKeepsTransfersItCannotCheck(t *testing.T) {
+	requestID := createTransfer(t, "bafybmiunreachablereceiver")
+
+	// The node cannot serve the token chain
+	nodeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "node unavailable", http.StatusServiceUnavailable)
+	}))
+	defer nodeServer.Close()
+	previous := newNodeClient
+	newNodeClient = func(port string) rubix_interaction.NodeClient {
+		return rubix_interaction.NewRubixClient(nodeServer.URL)
+	}
+	defer func() { newNodeClient = previous }()
+
+	r := &Reconciler{manager: GetTransferManager(), interval: time.Minute, maxAge: time.Nanosecond}
+	stats := r.RunOnce(context.Background())
+	if stats.LastExpired != 0 || len(stats.LastErrors) == 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if got := transferStatus(t, requestID); got.Status != "pending" {
+		t.Fatalf("transfer = %s, want it left pending", got.Status)
+	}
+	claims, err := database.GetActivityClaims("bafybmiunreachablereceiver")
+	if err != nil || len(claims) == 0 {
+		t.Fatalf("claims = %+v, %v, want them kept", claims, err)
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"dapp-server/database"
+	rubix_interaction "dapp-server/rubix-interaction"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ActivityRecordKind is written by the add_activity contract
+var ActivityRecordKind = rubix_interaction.NewRecordKind("activity", func(activity rubix_interaction.Activity) error {
+	if activity.ActivityID == "" || activity.BlockHash == "" {
+		return fmt.Errorf("activity record needs activity_id and block_hash")
+	}
+	return database.UpsertActivity(database.ActivityRecord{
+		ActivityID:   activity.ActivityID,
+		BlockHash:    activity.BlockHash,
+		RewardPoints: activity.RewardPoints,
+	})
+})
+
+// AdminRecordKind is written by the add_admin contract function
+var AdminRecordKind = rubix_interaction.NewRecordKind("admin", func(addAdmin rubix_interaction.AddAdminReq) error {
+	if addAdmin.AdminDID == "" {
+		return fmt.Errorf("admin record needs admin_did")
+	}
+	added, err := database.ApplyAdminEvent(database.AdminEvent{
+		Action:   database.AdminAdded,
+		AdminDID: addAdmin.AdminDID,
+		ActorDID: addAdmin.ActorDID,
+		BlockID:  addAdmin.BlockID,
+	})
+	if err != nil {
+		return err
+	}
+	if !added {
+		fmt.Println("Admin already registered:", addAdmin.AdminDID)
+	}
+	return nil
+})
+
+// AdminRemovalRecordKind is written by the remove_admin contract function
+var AdminRemovalRecordKind = rubix_interaction.NewRecordKind("admin_removal", func(removeAdmin rubix_interaction.RemoveAdminReq) error {
+	if removeAdmin.AdminDID == "" {
+		return fmt.Errorf("admin removal record needs admin_did")
+	}
+	removed, err := database.ApplyAdminEvent(database.AdminEvent{
+		Action:   database.AdminRemoved,
+		AdminDID: removeAdmin.AdminDID,
+		ActorDID: removeAdmin.ActorDID,
+		BlockID:  removeAdmin.BlockID,
+	})
+	if err != nil {
+		return err
+	}
+	if !removed {
+		fmt.Println("Admin was not registered:", removeAdmin.AdminDID)
+	}
+	return nil
+})
+
+// recordFailures collects the errors the record kinds of one contract run
+// fail with. The contract only learns that its write failed and may finish
+// anyway, so the callback that ran it checks here.
+type recordFailures struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+// watch returns kinds whose failures are collected
+func (f *recordFailures) watch(kinds ...rubix_interaction.RecordKind) []rubix_interaction.RecordKind {
+	watched := make([]rubix_interaction.RecordKind, len(kinds))
+	for i, kind := range kinds {
+		store := kind.Store
+		watched[i] = rubix_interaction.RecordKind{Name: kind.Name, Store: func(data []byte) error {
+			err := store(data)
+			if err != nil {
+				f.mu.Lock()
+				f.errs = append(f.errs, err)
+				f.mu.Unlock()
+			}
+			return err
+		}}
+	}
+	return watched
+}
+
+// err returns the collected failures, or nil if there were none
+func (f *recordFailures) err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return errors.Join(f.errs...)
+}
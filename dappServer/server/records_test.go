@@ -0,0 +1,29 @@
+package server
+
+import (
+	"dapp-server/database"
+	rubix_interaction "dapp-server/rubix-interaction"
+	"testing"
+)
+
+func TestStoreRecordAcceptsBareAdminRecord(t *testing.T) {
+	kinds := []rubix_interaction.RecordKind{AdminRecordKind, AdminRemovalRecordKind}
+
+	// The deployed add_admin contract writes the bare request
+	if err := rubix_interaction.StoreRecord(kinds, []byte(`{"admin_did":"bafybmibareadmin"}`)); err != nil {
+		t.Fatalf("bare admin record: %v", err)
+	}
+	if admin, err := database.IsAdmin("bafybmibareadmin"); err != nil || !admin {
+		t.Fatalf("bare admin record stored = %v, %v", admin, err)
+	}
+
+	if err := rubix_interaction.StoreRecord(kinds, []byte(`{"kind":"admin_removal","record":{"admin_did":"bafybmibareadmin"}}`)); err != nil {
+		t.Fatalf("admin removal record: %v", err)
+	}
+	if admin, err := database.IsAdmin("bafybmibareadmin"); err != nil || admin {
+		t.Fatalf("admin still registered after removal = %v, %v", admin, err)
+	}
+	if err := rubix_interaction.StoreRecord(kinds, []byte(`{"kind":"activity","record":{}}`)); err == nil {
+		t.Fatal("undeclared kind was stored")
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"dapp-server/config"
+	"dapp-server/database"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// defaultPointsPerToken is used when REWARD_POINTS_PER_TOKEN is not set
+const defaultPointsPerToken = 1.0
+
+// LoadActivityRewardPoints returns each registered activity's reward points
+func LoadActivityRewardPoints() (map[string]int, error) {
+	activities, err := database.ListActivities()
+	if err != nil {
+		return nil, err
+	}
+	points := make(map[string]int, len(activities))
+	for _, activity := range activities {
+		points[activity.ActivityID] = activity.RewardPoints
+	}
+	return points, nil
+}
+
+// pointsPerToken returns how many reward points buy one token
+func pointsPerToken() (float64, error) {
+	value := config.GetEnvConfig().PointsPerToken
+	if value == "" {
+		return defaultPointsPerToken, nil
+	}
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate <= 0 || math.IsInf(rate, 0) {
+		return 0, fmt.Errorf("REWARD_POINTS_PER_TOKEN must be a positive number, got %q", value)
+	}
+	return rate, nil
+}
+
+// Reward is what a transfer pays out for a set of activities
+type Reward struct {
+	Points int
+	// Tokens is the number of whole tokens transferred for Points
+	Tokens float64
+}
+
+// UnknownActivitiesError is returned when a reward is asked for activities
+// that were never registered
+type UnknownActivitiesError struct {
+	ActivityIDs []string
+}
+
+func (e *UnknownActivitiesError) Error() string {
+	return fmt.Sprintf("unknown activity IDs: %v", e.ActivityIDs)
+}
+
+// ComputeReward sums the registered reward points of the given activities
+// (an ID listed twice counts twice) and converts them to tokens. Unregistered
+// activities are reported with an *UnknownActivitiesError.
+func ComputeReward(activityIDs []string) (Reward, error) {
+	registered, err := LoadActivityRewardPoints()
+	if err != nil {
+		return Reward{}, err
+	}
+	rate, err := pointsPerToken()
+	if err != nil {
+		return Reward{}, err
+	}
+
+	var reward Reward
+	var unknown []string
+	for _, activityID := range activityIDs {
+		points, ok := registered[activityID]
+		if !ok {
+			unknown = append(unknown, activityID)
+			continue
+		}
+		reward.Points += points
+	}
+	if len(unknown) > 0 {
+		return Reward{}, &UnknownActivitiesError{ActivityIDs: unknown}
+	}
+
+	// Tokens are indivisible; leftover points are not paid out. The epsilon
+	// keeps rates such as 0.1 from losing a token to rounding.
+	reward.Tokens = math.Floor(float64(reward.Points)/rate + 1e-9)
+	return reward, nil
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+
+	"dapp-server/config"
+)
+
+func TestComputeReward(t *testing.T) {
+	// Activity 1 was re-registered at 10 points; ten points buy a token
+	for _, tc := range []struct {
+		activities []string
+		want       Reward
+	}{
+		{[]string{"1", "2"}, Reward{Points: 30, Tokens: 3}},
+		{[]string{"2", "2"}, Reward{Points: 40, Tokens: 4}},
+		{[]string{"stretch", "3"}, Reward{Points: 15, Tokens: 1}},
+		{[]string{"stretch"}, Reward{Points: 5, Tokens: 0}},
+	} {
+		if got, err := ComputeReward(tc.activities); err != nil || got != tc.want {
+			t.Errorf("ComputeReward(%v) = %+v, %v, want %+v", tc.activities, got, err, tc.want)
+		}
+	}
+
+	var unknown *UnknownActivitiesError
+	if _, err := ComputeReward([]string{"1", "not-registered"}); !errors.As(err, &unknown) || fmt.Sprint(unknown.ActivityIDs) != "[not-registered]" {
+		t.Fatalf("unregistered activity = %v", err)
+	}
+}
+
+func TestTransferRewardUsesRegisteredPoints(t *testing.T) {
+	node := startNode(t)
+	registerCallback(t, node, testTransferContract, "api/call-back-trigger")
+
+	const receiver = "bafybmipointsreceiver"
+	status, body := postJSON(t, "/api/rewards/transfer", TransferRewardRequest{
+		ActivityID: []string{"1", "2"},
+		UserDID:    receiver,
+		AdminDID:   testAdminDID,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", status, body)
+	}
+	data, _ := body["data"].(map[string]interface{})
+	if data["reward_points"] != float64(30) || data["rewards_awarded"] != float64(3) {
+		t.Fatalf("reward = %v points, %v tokens", data["reward_points"], data["rewards_awarded"])
+	}
+	if call := contractCallsMatching(testTransferContract, receiver); len(call) != 1 || !strings.Contains(call[0].Input, `"ft_count":3,`) {
+		t.Fatalf("contract input = %v", call)
+	}
+
+	status, body = postJSON(t, "/api/rewards/transfer", TransferRewardRequest{
+		ActivityID: []string{"1", "not-registered"},
+		UserDID:    receiver,
+		AdminDID:   testAdminDID,
+	})
+	if status != http.StatusBadRequest || fmt.Sprint(body["unknown_activity_ids"]) != "[not-registered]" {
+		t.Fatalf("unknown activity = %d, %v", status, body)
+	}
+
+	status, body = postJSON(t, "/api/rewards/transfer", TransferRewardRequest{
+		ActivityID: []string{"stretch"},
+		UserDID:    receiver,
+		AdminDID:   testAdminDID,
+	})
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("less than a token = %d, %v", status, body)
+	}
+
+	// More tokens than the contract's FT count holds is refused, not wrapped
+	envConfig := config.GetEnvConfig()
+	rate := envConfig.PointsPerToken
+	envConfig.PointsPerToken = "1e-9"
+	t.Cleanup(func() { envConfig.PointsPerToken = rate })
+	status, body = postJSON(t, "/api/rewards/transfer", TransferRewardRequest{
+		ActivityID: []string{"2"},
+		UserDID:    receiver,
+		AdminDID:   testAdminDID,
+	})
+	if status != http.StatusUnprocessableEntity || body["code"] != string(CodeRewardTooLarge) {
+		t.Fatalf("more tokens than a transfer holds = %d, %v", status, body)
+	}
+	if calls := contractCallsMatching(testTransferContract, receiver); len(calls) != 1 {
+		t.Fatalf("oversized reward reached the contract: %v", calls)
+	}
+}
+
+func TestTransferContractMessageFitsTheFTCount(t *testing.T) {
+	if _, err := transferContractMessage("request", testAdminDID, testUserDID, math.MaxInt32); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transferContractMessage("request", testAdminDID, testUserDID, math.MaxInt32+1); err == nil {
+		t.Fatal("a token count past the FT count was accepted")
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"dapp-server/config"
 	"dapp-server/database"
 	rubix_interaction "dapp-server/rubix-interaction"
@@ -9,9 +10,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -35,28 +38,8 @@ type RubixResponse struct {
 	Result  interface{} `json:"result"`
 }
 
-type SmartContractDataReply struct {
-	RubixResponse
-	SCTDataReply []SCTDataReply `json:"SCTDataReply"`
-}
-
-type SCTDataReply struct {
-	BlockNo            uint64 `json:"BlockNo"`
-	BlockId            string `json:"BlockId"`
-	SmartContractData  string `json:"SmartContractData"`
-	Epoch              uint64 `json:"Epoch"`
-	InitiatorSignature string `json:"InitiatorSignature"`
-	ExecutorDID        string `json:"ExecutorDID"`
-	InitiatorSignData  string `json:"InitiatorSignData"`
-}
-
-type AddActivity struct {
-	ActivityID   string `json:"activity_id"`
-	RewardPoints int    `json:"reward_points"`
-}
-
 type AddActivityPayload struct {
-	AddActivity AddActivity `json:"add_activity"`
+	AddActivity rubix_interaction.AddActivityReq `json:"add_activity"`
 }
 
 type AddActivityRequest struct {
@@ -65,10 +48,35 @@ type AddActivityRequest struct {
 	AdminDID     string `json:"admin_did"`
 }
 
+func (r AddActivityRequest) Validate() FieldErrors {
+	problems := FieldErrors{}
+	problems.ID("activity_id", r.ActivityID)
+	problems.Positive("reward_points", r.RewardPoints)
+	// The add_activity contract records the points as a uint32
+	problems.AtMost("reward_points", r.RewardPoints, math.MaxUint32)
+	problems.DID("admin_did", r.AdminDID)
+	return problems
+}
+
 type TransferRewardRequest struct {
 	ActivityID []string `json:"activity_id"`
 	UserDID    string   `json:"user_did"`
 	AdminDID   string   `json:"admin_did"`
+	// IdempotencyKey may be sent here or as an Idempotency-Key header
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+func (r TransferRewardRequest) Validate() FieldErrors {
+	problems := FieldErrors{}
+	if len(r.ActivityID) == 0 {
+		problems.add("activity_id", "at least one activity ID is required")
+	}
+	for i, activityID := range r.ActivityID {
+		problems.ID(fmt.Sprintf("activity_id[%d]", i), activityID)
+	}
+	problems.DID("user_did", r.UserDID)
+	problems.DID("admin_did", r.AdminDID)
+	return problems
 }
 
 type Activity struct {
@@ -81,168 +89,278 @@ func BootupServer() {
 	gin.SetMode(gin.ReleaseMode) //
 	log.Println("Current Gin Mode:", gin.Mode())
 
+	log.SetFlags(log.LstdFlags)
+	router := NewRouter()
+
+	// Settle whatever the previous run left in flight. Transfers started from
+	// here on are not touched, so this does not hold up the listener.
+	startedAt := time.Now()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		GetTransferManager().Recover(ctx, startedAt)
+	}()
+	GetReconciler().Start(context.Background())
+
+	// Start the server on port 9000
+	router.Run(":9000")
+}
+
+// NewRouter builds the gin router with every dApp endpoint mounted
+func NewRouter() *gin.Engine {
 	// Initialize a Gin router
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.LoggerWithFormatter(requestLogFormatter), gin.Recovery())
 	log.Println("Current Gin Mode:", gin.Mode())
 
 	// config := GetConfig()
 
-	log.SetFlags(log.LstdFlags)
-
 	// Configure CORS middleware
 	router.Use(cors.New(cors.Config{
-		AllowOrigins:  []string{"*"},
-		AllowMethods:  []string{"GET", "POST", "OPTIONS"},
-		AllowHeaders:  []string{"Origin", "Content-Type", "Accept"},
+		AllowOrigins:  corsAllowOrigins(),
+		AllowMethods:  []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowHeaders:  []string{"Origin", "Content-Type", "Accept", "Prefer", "Idempotency-Key", "Authorization", apiKeyHeader},
 		ExposeHeaders: []string{"Content-Length"},
 	}))
+	router.Use(Authenticate())
+
+	// Roles per route. Callbacks come from the Rubix nodes and are checked
+	// by VerifyCallback instead; everything that executes a contract on a
+	// node is behind a role.
+	adminOnly := RequireRole(RoleAdmin)
+	staff := RequireRole(RoleAdmin, RoleStaff)
+	signedIn := RequireRole(RoleAdmin, RoleStaff, RoleUser)
 
 	// nftDappCallbackHandler := config.ContractsInfo["nft"].CallBackUrl
 	// ftDappCallbackHandler := config.ContractsInfo["ft"].CallBackUrl
 
 	// Define endpoints
 	// router.POST(nftDappCallbackHandler, nftDappHandler) // NFT
-	router.POST("/api/call-back-trigger", ftDappHandler) // FT
+	router.POST("/api/call-back-trigger", VerifyCallback(), ftDappHandler) // FT
 	// router.POST("/api/trigger-contract-2", ftContract2Handler)
-	router.POST("/api/deploy-contract", APIDeployContract)
-	router.POST("/api/execute-contract", APIExecuteContract)
-	router.POST("/api/activity/add", APIAddActivity)
-	router.POST("/api/callback/trigger", APICallBackTrigger)
-	router.POST("/api/rewards/transfer", APITransferReward)
-	router.GET("/api/rewards/status/:transactionID", APIGetTransferStatus)
-	router.POST("/api/admin/add", APIAddAdmin)
-	router.POST("/api/callback/add-admin", APIAddAdminCallBackTrigger)
+	router.POST("/api/auth/challenge", APIAuthChallenge)
+	router.POST("/api/auth/login", APIAuthLogin)
+	router.POST("/api/auth/logout", signedIn, APIAuthLogout)
+	router.PUT("/api/auth/keys/:did", adminOnly, APISetDIDKey)
+	router.POST("/api/deploy-contract", adminOnly, RequireAdmin("deployer_did"), APIDeployContract)
+	router.POST("/api/execute-contract", adminOnly, RequireAdmin("executor_did"), APIExecuteContract)
+	router.POST("/api/activity/add", staff, RequireAdmin("admin_did"), APIAddActivity)
+	router.GET("/api/activities", APIListActivities)
+	router.GET("/api/activities/:id", APIGetActivity)
+	router.POST("/api/callback/trigger", VerifyCallback(), APICallBackTrigger)
+	router.POST("/api/rewards/transfer", staff, RequireAdmin("admin_did"), APITransferReward)
+	router.GET("/api/rewards/status/:transactionID", signedIn, APIGetTransferStatus)
+	router.POST("/api/admin/add", adminOnly, RequireAdmin("existing_admin_did"), APIAddAdmin)
+	router.GET("/api/admins", adminOnly, APIListAdmins)
+	router.GET("/api/admins/history", adminOnly, APIListAdminEvents)
+	router.DELETE("/api/admins/:did", adminOnly, RequireAdmin("existing_admin_did"), APIRemoveAdmin)
+	router.POST("/api/callback/add-admin", VerifyCallback(), APIAddAdminCallBackTrigger)
+	router.GET("/api/admin/reconciler", adminOnly, APIGetReconcilerStatus)
+	router.GET("/api/contracts", adminOnly, APIListContracts)
+	router.POST("/api/contracts/:hash/promote", adminOnly, APIPromoteContract)
+	router.GET("/api/contracts/:hash/callbacks", adminOnly, APIListCallbacks)
+	router.POST("/api/contracts/:hash/callbacks", adminOnly, APIRegisterCallbacks)
+	router.PUT("/api/contracts/:hash/callbacks", adminOnly, APIReregisterCallbacks)
 
 	// router.GET("/request-status", getRequestStatusHandler)
 
-	// Start the server on port 9000
-	router.Run(":9000")
+	return router
 }
+
+// requestLogFormatter logs a request the way gin's default logger does,
+// but without its query string: callback URLs carry their registration's
+// secret in it
+func requestLogFormatter(param gin.LogFormatterParams) string {
+	path, _, _ := strings.Cut(param.Path, "?")
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		param.Method,
+		path,
+		param.ErrorMessage,
+	)
+}
+
+// corsAllowOrigins reads CORS_ALLOW_ORIGINS, a comma-separated list that
+// defaults to any origin
+func corsAllowOrigins() []string {
+	var origins []string
+	for _, origin := range strings.Split(config.GetEnvConfig().CORSAllowOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	if len(origins) == 0 {
+		return []string{"*"}
+	}
+	return origins
+}
+
 func APITransferReward(c *gin.Context) {
 	fmt.Println("APITransferReward triggered")
 	var req TransferRewardRequest
-	err := json.NewDecoder(c.Request.Body).Decode(&req)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
-		fmt.Printf("Error reading response body: %s\n", err)
+	if !bindRequest(c, &req) {
 		return
 	}
 	fmt.Println("The request body is:", req)
-	cfg, err := config.GetConfig()
-	if err != nil {
-		fmt.Println("failed to load config: %w", err)
-	}
-	nodePort, exists := config.GetPortByDid(cfg, req.AdminDID)
-	if !exists {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Node port not found for admin DID"})
-		fmt.Println("failed to get node port: not found")
+	nodePort, ok := nodePortFor(c, "admin_did", req.AdminDID)
+	if !ok {
 		return
 	}
 	fmt.Println("The node port is:", nodePort)
-	url := fmt.Sprintf("http://localhost:%s", nodePort)
-	fmt.Println("The url is :", url)
-
-	rewardPoints := len(req.ActivityID)
-	contractMsg := fmt.Sprintf(`{"transfer_sample_ft":{"name": "rubix1", "ft_info": {"comment":"Transfer of reward via contract","ft_count":%f,"ft_name":"ytoken","sender": "%s","creatorDID": "%s", "receiver": "%s"}}}`, float64(rewardPoints), req.AdminDID, req.AdminDID, req.UserDID)
-	fmt.Println("The contract message is:", contractMsg)
 
-	transferContractHash := config.GetEnvConfig().TransferContract
-	if transferContractHash == "" {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transfer contract hash not configured"})
-		fmt.Println("transferContractHash is not set in the config")
+	// The transfer is recorded with the version active now and settles
+	// against it, even if another version is promoted while it is in flight
+	transferContractHash, ok := contractHashFor(c, database.ContractRoleTransfer)
+	if !ok {
 		return
 	}
 
-	// Step 1: Execute smart contract
-	requestID, err := rubix_interaction.ExecuteSmartContract(url, transferContractHash, req.AdminDID, contractMsg)
+	// A retried request carries the key of the first attempt; answer it with
+	// that attempt's result instead of transferring again
+	idempotencyKey, err := transferIdempotencyKey(c, req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to execute smart contract", "details": err.Error()})
-		fmt.Println("failed to execute smart contract:", err)
+		respondError(c, http.StatusBadRequest, CodeValidationFailed, "Invalid idempotency key", err,
+			gin.H{"fields": FieldErrors{"idempotency_key": err.Error()}})
 		return
 	}
-	fmt.Println("Smart contract response (requestID):", requestID)
+	if idempotencyKey != "" {
+		if existing, err := database.GetTransferStatusByIdempotencyKey(idempotencyKey); err == nil {
+			replayTransfer(c, existing, req)
+			return
+		}
+	}
 
-	// Step 2: Sign the transaction (THIS CREATES THE BLOCK ON BLOCKCHAIN)
-	// NOTE: Blockchain triggers callback BEFORE returning response
-	signatureResponse, err := rubix_interaction.SignatureResponse(url, requestID)
+	// Price the transfer from the registered activities before touching the chain
+	reward, err := ComputeReward(req.ActivityID)
+	var unknownActivities *UnknownActivitiesError
+	if errors.As(err, &unknownActivities) {
+		respondError(c, http.StatusBadRequest, CodeUnknownActivities, "Unknown activity IDs", nil,
+			gin.H{"unknown_activity_ids": unknownActivities.ActivityIDs})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign transaction", "details": err.Error()})
-		fmt.Println("failed to send signature response:", err)
+		fmt.Println("failed to compute reward:", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to compute reward", err)
+		return
+	}
+	if reward.Tokens <= 0 {
+		respondError(c, http.StatusUnprocessableEntity, CodeRewardBelowOneToken, "Reward points do not add up to a whole token", nil,
+			gin.H{"reward_points": reward.Points})
+		return
+	}
+	if reward.Tokens > maxTransferTokens {
+		respondError(c, http.StatusUnprocessableEntity, CodeRewardTooLarge,
+			fmt.Sprintf("Reward is more than the %d tokens a transfer can carry", maxTransferTokens), nil,
+			gin.H{"reward_points": reward.Points})
 		return
 	}
 
-	// Extract the ACTUAL transaction ID from signature response
-	// This is the real transaction ID now that the block has been created
-	transactionID := signatureResponse.Result
-	fmt.Printf("✅ Transaction committed to blockchain! Transaction ID: %s\n", transactionID)
-	fmt.Printf("📋 SignatureResponse.Message: '%s'\n", signatureResponse.Message)
+	// Step 1: Allocate the request ID that ties this transfer to its block
+	requestID, err := NewTransferRequestID()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to start transfer", err)
+		return
+	}
+	contractMsg, err := transferContractMessage(requestID, req.AdminDID, req.UserDID, reward.Tokens)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to build contract message", err)
+		return
+	}
+	fmt.Println("The contract message is:", contractMsg)
 
-	// Step 3: Register pending request immediately with transactionID as temporary key
-	// (Callback has 5s delay, so we have time to update with real blockId)
+	// Step 2: Store the transfer before touching the chain
 	manager := GetTransferManager()
-	responseChan := manager.RegisterPendingRequest(transactionID, transactionID) // Use transactionID as temp blockId
-	fmt.Printf("⚡ Registered pending request with temporary key (transactionID): %s\n", transactionID)
-
-	// Step 4: Fetch BlockId and create DB record in BACKGROUND
-	// This runs in parallel with the callback's 5-second delay
-	go func() {
-		startTime := time.Now()
-		fmt.Printf("🚀 [%s] Background goroutine: Started\n", startTime.Format("15:04:05.000"))
-
-		// Fetch BlockId (block is already created)
-		blockId, err := ExtractLatestBlockId(transferContractHash, url)
-		extractTime := time.Now()
-		if err != nil {
-			fmt.Printf("⚠️  [%s] Background: Failed to extract BlockId: %v\n", extractTime.Format("15:04:05.000"), err)
-			return
-		}
-		fmt.Printf("📦 [%s] Background: Extracted BlockId: %s (took %v)\n",
-			extractTime.Format("15:04:05.000"), blockId, extractTime.Sub(startTime))
-
-		// Store in database with status "pending"
-		_, err = manager.CreateTransfer(
-			transactionID,
-			blockId,
-			transferContractHash,
-			req.ActivityID,
-			req.UserDID,
-			req.AdminDID,
-			rewardPoints,
-		)
-		dbTime := time.Now()
+	_, err = manager.CreateTransfer(
+		requestID,
+		idempotencyKey,
+		transferContractHash,
+		req.ActivityID,
+		req.UserDID,
+		req.AdminDID,
+		reward,
+	)
+	if err == database.ErrDuplicateIdempotencyKey {
+		// A concurrent attempt with the same key got in first
+		existing, err := database.GetTransferStatusByIdempotencyKey(idempotencyKey)
 		if err != nil {
-			fmt.Printf("⚠️  [%s] Background: Failed to create transfer in DB: %v\n", dbTime.Format("15:04:05.000"), err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to look up transfer", err)
 			return
 		}
-		fmt.Printf("✅ [%s] Background: Transfer stored in DB: transactionID=%s, blockId=%s (took %v)\n",
-			dbTime.Format("15:04:05.000"), transactionID, blockId, dbTime.Sub(extractTime))
-
-		// Update pending request mapping from transactionID to actual blockId
-		manager.UpdatePendingRequestBlockId(transactionID, blockId)
-		updateTime := time.Now()
-		fmt.Printf("🔄 [%s] Background: Updated pending request mapping: %s → %s (took %v)\n",
-			updateTime.Format("15:04:05.000"), transactionID, blockId, updateTime.Sub(dbTime))
-		fmt.Printf("✅ [%s] Background goroutine: COMPLETED (total time: %v)\n",
-			updateTime.Format("15:04:05.000"), updateTime.Sub(startTime))
-	}()
+		replayTransfer(c, existing, req)
+		return
+	}
+	var duplicateClaims *database.DuplicateClaimsError
+	if errors.As(err, &duplicateClaims) {
+		respondError(c, http.StatusConflict, CodeDuplicateClaims, "Some activities have already been claimed by this user", nil,
+			gin.H{"duplicate_activity_ids": duplicateClaims.ActivityIDs})
+		return
+	}
+	if err != nil {
+		fmt.Println("failed to create transfer:", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to record transfer", err)
+		return
+	}
+
+	// Asynchronous callers get the ID straight away and follow the transfer
+	// through the status endpoint
+	if wantsAsyncTransfer(c) {
+		client := newNodeClient(nodePort)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), transferSubmitTimeout)
+			defer cancel()
+			submitTransfer(ctx, client, requestID, transferContractHash, req.AdminDID, contractMsg)
+		}()
+		c.JSON(http.StatusAccepted, gin.H{
+			"status":         "pending",
+			"message":        "Transfer accepted. Follow its progress using transaction_id.",
+			"transaction_id": requestID,
+			"status_url":     "/api/rewards/status/" + requestID,
+			"data": gin.H{
+				"rewards_awarded": reward.Tokens,
+				"reward_points":   reward.Points,
+				"activity_ids":    req.ActivityID,
+				"user_did":        req.UserDID,
+				"admin_did":       req.AdminDID,
+			},
+		})
+		return
+	}
+
+	// Register the waiter BEFORE executing; the node calls back before the
+	// signature response returns
+	responseChan := manager.RegisterPendingRequest(requestID)
+
+	// Steps 3 and 4: Execute and sign (signing creates the block)
+	ctx := c.Request.Context()
+	if failedStep, err := submitTransfer(ctx, newNodeClient(nodePort), requestID, transferContractHash, req.AdminDID, contractMsg); err != nil {
+		manager.ForgetPendingRequest(requestID)
+		status, code := nodeErrorStatus(err)
+		respondError(c, status, code, failedStep, err, gin.H{"transaction_id": requestID})
+		return
+	}
 
 	// Step 5: Wait for callback with 3 minute timeout
-	// Callback will arrive after its 5s delay, by which time the background goroutine should have completed
-	fmt.Printf("⏳ [%s] Waiting for callback (timeout: 3 minutes)...\n", time.Now().Format("15:04:05.000"))
+	fmt.Printf("Waiting for callback for %s (timeout: 3 minutes)...\n", requestID)
 	select {
 	case callbackResult := <-responseChan:
 		// Success! Callback arrived in time
-		fmt.Printf("🎉 [%s] Received callback for transaction %s: success=%v\n",
-			time.Now().Format("15:04:05.000"), transactionID, callbackResult.Success)
+		fmt.Printf("Received callback for transfer %s: success=%v\n", requestID, callbackResult.Success)
 
 		if callbackResult.Success {
 			c.JSON(http.StatusOK, gin.H{
 				"status":         "success",
 				"message":        "Reward transfer completed successfully",
-				"transaction_id": transactionID,
+				"transaction_id": requestID,
 				"block_id":       callbackResult.BlockId,
 				"data": gin.H{
-					"rewards_awarded": float64(rewardPoints),
+					"rewards_awarded": reward.Tokens,
+					"reward_points":   reward.Points,
 					"activity_ids":    req.ActivityID,
 					"user_did":        req.UserDID,
 					"admin_did":       req.AdminDID,
@@ -250,72 +368,250 @@ func APITransferReward(c *gin.Context) {
 			})
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":           CodeTransferFailed,
 				"status":         "failed",
 				"message":        "Reward transfer failed",
-				"transaction_id": transactionID,
+				"transaction_id": requestID,
+				"block_id":       callbackResult.BlockId,
 				"error":          callbackResult.Error,
 			})
 		}
 
-	case <-time.After(3 * time.Minute):
-		// Timeout - callback didn't arrive in time
-		fmt.Printf("Timeout waiting for callback for transaction %s\n", transactionID)
+	case <-ctx.Done():
+		// The caller went away; the callback still settles the record in the DB
+		fmt.Printf("Caller disconnected while waiting for transfer %s: %v\n", requestID, ctx.Err())
+		manager.ForgetPendingRequest(requestID)
 
-		// Try to get blockId from database (background goroutine may or may not have completed)
-		var cleanupKey string
-		status, err := database.GetTransferStatus(transactionID)
-		if err == nil && status.BlockId != "" {
-			cleanupKey = status.BlockId
-		} else {
-			// Background goroutine hasn't completed yet, use transactionID as key
-			cleanupKey = transactionID
-		}
+	case <-time.After(transferCallbackTimeout):
+		// Timeout - callback didn't arrive in time
+		fmt.Printf("Timeout waiting for callback for transfer %s\n", requestID)
 
 		// Mark as timeout in database
-		err = manager.MarkTimeout(transactionID, cleanupKey)
-		if err != nil {
+		if err := manager.MarkTimeout(requestID); err != nil {
 			fmt.Printf("Failed to mark timeout: %v\n", err)
 		}
 
 		c.JSON(http.StatusAccepted, gin.H{
 			"status":         "timeout",
 			"message":        "Transfer initiated but confirmation timed out. Check status later using transaction_id.",
-			"transaction_id": transactionID,
+			"transaction_id": requestID,
 			"data": gin.H{
-				"rewards_awarded": float64(rewardPoints),
+				"rewards_awarded": reward.Tokens,
+				"reward_points":   reward.Points,
 				"activity_ids":    req.ActivityID,
 				"user_did":        req.UserDID,
 			},
-			"note": "Use GET /api/rewards/status/" + transactionID + " to check transfer status",
+			"note": "Use GET /api/rewards/status/" + requestID + " to check transfer status",
 		})
 	}
 }
 
-// APIGetTransferStatus retrieves the status of a reward transfer by transaction ID
+// transferCallbackTimeout is how long a synchronous transfer request waits
+// for the block to be confirmed before answering 202
+const transferCallbackTimeout = 3 * time.Minute
+
+// maxIdempotencyKeyLength bounds the idempotency keys accepted from clients
+const maxIdempotencyKeyLength = 255
+
+// transferIdempotencyKey returns the request's idempotency key, taken from
+// the Idempotency-Key header or the idempotency_key field
+func transferIdempotencyKey(c *gin.Context, req TransferRewardRequest) (string, error) {
+	key := strings.TrimSpace(c.GetHeader("Idempotency-Key"))
+	field := strings.TrimSpace(req.IdempotencyKey)
+	if key != "" && field != "" && key != field {
+		return "", fmt.Errorf("Idempotency-Key header and idempotency_key field differ")
+	}
+	if key == "" {
+		key = field
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return "", fmt.Errorf("idempotency key must be at most %d characters", maxIdempotencyKeyLength)
+	}
+	return key, nil
+}
+
+// replayTransfer answers a request whose idempotency key was already used
+// with the result of the transfer that key started. A synchronous caller
+// waits for a transfer still in flight, just as the first caller does.
+func replayTransfer(c *gin.Context, existing *database.TransferStatus, req TransferRewardRequest) {
+	if existing.RequestFingerprint != TransferFingerprint(req.ActivityID, req.UserDID, req.AdminDID) {
+		respondError(c, http.StatusUnprocessableEntity, CodeIdempotencyKeyReused, "Idempotency key was already used for a different transfer", nil,
+			gin.H{"transaction_id": existing.RequestID})
+		return
+	}
+	fmt.Printf("Replaying transfer %s for a repeated idempotency key\n", existing.RequestID)
+
+	status := existing
+	if !wantsAsyncTransfer(c) {
+		status = waitForFinalTransfer(c.Request.Context(), existing, transferCallbackTimeout)
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.JSON(transferStatusResponse(status))
+}
+
+// transferStatusResponse renders a stored transfer the way APITransferReward
+// reports one it has just made
+func transferStatusResponse(status *database.TransferStatus) (int, gin.H) {
+	switch status.Status {
+	case "success":
+		return http.StatusOK, gin.H{
+			"status":         "success",
+			"message":        "Reward transfer completed successfully",
+			"transaction_id": status.RequestID,
+			"block_id":       status.BlockId,
+			"data": gin.H{
+				"rewards_awarded": status.TokenCount,
+				"reward_points":   status.RewardPoints,
+				"activity_ids":    status.ActivityIDs,
+				"user_did":        status.UserDID,
+				"admin_did":       status.AdminDID,
+			},
+		}
+	case "failed":
+		return http.StatusInternalServerError, gin.H{
+			"code":           CodeTransferFailed,
+			"status":         "failed",
+			"message":        "Reward transfer failed",
+			"transaction_id": status.RequestID,
+			"block_id":       status.BlockId,
+			"error":          status.ErrorDetails,
+		}
+	default:
+		return http.StatusAccepted, gin.H{
+			"status":         status.Status,
+			"message":        status.Message,
+			"transaction_id": status.RequestID,
+			"status_url":     "/api/rewards/status/" + status.RequestID,
+			"data": gin.H{
+				"rewards_awarded": status.TokenCount,
+				"reward_points":   status.RewardPoints,
+				"activity_ids":    status.ActivityIDs,
+				"user_did":        status.UserDID,
+			},
+		}
+	}
+}
+
+// transferSubmitTimeout bounds executing and signing a transfer submitted
+// asynchronously, once the HTTP request that started it has returned
+const transferSubmitTimeout = 5 * time.Minute
+
+// wantsAsyncTransfer reports whether the caller asked for a 202 instead of
+// waiting for the transfer to be confirmed, either with ?async=true or a
+// "Prefer: respond-async" header
+func wantsAsyncTransfer(c *gin.Context) bool {
+	if async, err := strconv.ParseBool(c.Query("async")); err == nil && async {
+		return true
+	}
+	return strings.Contains(strings.ToLower(c.GetHeader("Prefer")), "respond-async")
+}
+
+// submitTransfer executes and signs a recorded transfer. A failure is
+// recorded against the transfer, and the returned message names the step
+// that failed.
+func submitTransfer(
+	ctx context.Context,
+	client rubix_interaction.NodeClient,
+	requestID string,
+	contractHash string,
+	adminDID string,
+	contractMsg string,
+) (string, error) {
+	manager := GetTransferManager()
+
+	executeRequestID, err := client.ExecuteSmartContract(ctx, rubix_interaction.NewExecuteSmartContractRequest(contractHash, adminDID, contractMsg))
+	if err != nil {
+		manager.MarkFailed(requestID, "Failed to execute smart contract", err)
+		fmt.Println("failed to execute smart contract:", err)
+		return "Failed to execute smart contract", err
+	}
+	fmt.Println("Smart contract response (requestID):", executeRequestID)
+
+	signatureResponse, err := rubix_interaction.Sign(ctx, client, adminDID, executeRequestID)
+	if err != nil {
+		var nodeErr *rubix_interaction.NodeError
+		if errors.As(err, &nodeErr) {
+			manager.MarkFailed(requestID, "Failed to sign transaction", err)
+		} else if err := manager.MarkTimeout(requestID); err != nil {
+			// The node may have committed the block before the call broke off,
+			// so leave the transfer open for a late callback
+			fmt.Printf("Failed to mark timeout: %v\n", err)
+		}
+		fmt.Println("failed to send signature response:", err)
+		return "Failed to sign transaction", err
+	}
+
+	// The Rubix transaction ID is kept alongside our request ID; either can
+	// be used to look the transfer up
+	fmt.Printf("Transaction committed to blockchain! Transaction ID: %s\n", signatureResponse.Result)
+	if err := manager.SetTransactionID(requestID, signatureResponse.Result); err != nil {
+		fmt.Printf("Failed to store transaction ID for %s: %v\n", requestID, err)
+	}
+	return "", nil
+}
+
+// maxTransferTokens is the most tokens the contract's 32-bit FT count holds
+const maxTransferTokens = math.MaxInt32
+
+// transferContractMessage builds the transfer_sample_ft input for a reward
+// transfer, tagged with the request ID it settles
+func transferContractMessage(requestID string, adminDID string, userDID string, tokens float64) (string, error) {
+	if tokens < 0 || tokens > maxTransferTokens {
+		return "", fmt.Errorf("%v tokens do not fit in a transfer", tokens)
+	}
+	return rubix_interaction.ContractMessage(rubix_interaction.TransferSampleFTReq{
+		Name: "rubix1",
+		FTInfo: rubix_interaction.TransferFT{
+			Comment:    TransferComment(requestID),
+			FTCount:    int32(tokens),
+			FTName:     "ytoken",
+			Sender:     adminDID,
+			CreatorDID: adminDID,
+			Receiver:   userDID,
+		},
+	})
+}
+
+// APIGetTransferStatus retrieves the status of a reward transfer by transaction ID.
+// With ?wait=30s it holds the request until the transfer moves on (long-poll);
+// with "Accept: text/event-stream" it streams every transition as it happens.
 func APIGetTransferStatus(c *gin.Context) {
 	transactionID := c.Param("transactionID")
 	fmt.Printf("APIGetTransferStatus called for transaction: %s\n", transactionID)
 
 	if transactionID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status":  false,
-			"message": "Transaction ID is required",
-		})
+		respondError(c, http.StatusBadRequest, CodeValidationFailed, "Transaction ID is required", nil)
 		return
 	}
 
 	// Retrieve status from database
 	status, err := database.GetTransferStatus(transactionID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"status":  false,
-			"message": "Transfer not found",
-			"error":   err.Error(),
-		})
 		fmt.Printf("Transfer not found: %v\n", err)
+		respondError(c, http.StatusNotFound, CodeNotFound, "Transfer not found", err)
+		return
+	}
+	// Only admins and the transfer's receiver and issuing admin may follow it;
+	// anyone else is told it does not exist
+	if principal := currentPrincipal(c); !principal.HasRole(RoleAdmin) &&
+		(principal.DID == "" || (principal.DID != status.UserDID && principal.DID != status.AdminDID)) {
+		respondError(c, http.StatusNotFound, CodeNotFound, "Transfer not found",
+			fmt.Errorf("%s is not a transfer of %s", transactionID, principal.Name))
 		return
 	}
 
+	if wantsEventStream(c) {
+		streamTransferStatus(c, status)
+		return
+	}
+
+	wait, err := parseStatusWait(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid query", err)
+		return
+	}
+	status = waitForTransferChange(c.Request.Context(), status, wait)
+
 	c.JSON(http.StatusOK, gin.H{
 		"status": true,
 		"data":   status,
@@ -325,57 +621,35 @@ func APIGetTransferStatus(c *gin.Context) {
 func APIAddActivity(c *gin.Context) {
 	fmt.Println("APIAddActivity triggered")
 	var req AddActivityRequest
-	err := json.NewDecoder(c.Request.Body).Decode(&req)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
-		fmt.Printf("Error reading response body: %s\n", err)
+	if !bindRequest(c, &req) {
 		return
 	}
 	fmt.Println("The request body is:", req)
-	cfg, err := config.GetConfig()
-	if err != nil {
-		fmt.Println("failed to load config: %w", err)
-	}
-	nodePort, exists := config.GetPortByDid(cfg, req.AdminDID)
-	if !exists {
-		fmt.Println("failed to get node port: not found")
+	nodePort, ok := nodePortFor(c, "admin_did", req.AdminDID)
+	if !ok {
 		return
 	}
 	fmt.Println("The node port is:", nodePort)
-	url := fmt.Sprintf("http://localhost:%s", nodePort)
-	fmt.Println("The url is :", url)
-	contractMsg := fmt.Sprintf(`{"add_activity": {"activity_id":"%s","reward_points":%d}}`, req.ActivityID, req.RewardPoints)
-	fmt.Println("The contract message is:", contractMsg)
-	smartContractHash := config.GetEnvConfig().AddActivityContract //Loading the smart contract hash from config
-	if smartContractHash == "" {
-		fmt.Println("Smart contract hash is not set in the config")
-		return
-	}
-	smartContractResponse, err := rubix_interaction.ExecuteSmartContract(url, smartContractHash, req.AdminDID, contractMsg)
-	if err != nil {
-		fmt.Println("failed to execute smart contract:", err)
-		return
-	}
-	fmt.Println("Smart contract response:", smartContractResponse)
-	_, err = rubix_interaction.SignatureResponse(url, smartContractResponse)
+	contractMsg, err := rubix_interaction.ContractMessage(rubix_interaction.AddActivityReq{
+		ActivityID:   req.ActivityID,
+		RewardPoints: uint32(req.RewardPoints),
+	})
 	if err != nil {
-		fmt.Println("failed to send signature response:", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to build contract message", err)
 		return
 	}
-	fmt.Println("Signature response sent successfully")
-	addActivityContractHash := config.GetEnvConfig().AddActivityContract //Loading the smart contract hash from config
-	if addActivityContractHash == "" {
-		fmt.Println("addActivityContractHash is not set in the config")
+	fmt.Println("The contract message is:", contractMsg)
+	smartContractHash, ok := contractHashFor(c, database.ContractRoleActivity)
+	if !ok {
 		return
 	}
-	block := rubix_interaction.GetSmartContractData(addActivityContractHash, url) //config.NodeAddress)
-	if block == nil {
-		fmt.Println("Unable to fetch latest smart contract data")
+	block, ok := executeContractBlock(c, nodePort, smartContractHash, req.AdminDID, contractMsg)
+	if !ok {
 		return
 	}
 	resultFinal := gin.H{
 		"message": "Activity added to smart contract tokenchain",
-		"data":    string(block),
+		"data":    block,
 	}
 
 	// Return a response
@@ -385,84 +659,81 @@ func APIAddActivity(c *gin.Context) {
 
 func APICallBackTrigger(c *gin.Context) {
 	var req ContractInputRequest
-	err := json.NewDecoder(c.Request.Body).Decode(&req)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
-		fmt.Printf("Error reading response body: %s\n", err)
+	if !bindRequest(c, &req) {
 		return
 	}
 	fmt.Println("The request body is:", req)
-	url := fmt.Sprintf("http://localhost:%s", req.Port)
-	fmt.Println("The url is :", url)
-
-	// // config := GetConfig()
 	smartContractHash := req.SmartContractHash
 	fmt.Println("Received Smart Contract hash: ", smartContractHash)
 
-	smartContractTokenData := rubix_interaction.GetSmartContractData(smartContractHash, url) //config.NodeAddress)
-	if smartContractTokenData == nil {
-		fmt.Println("Unable to fetch latest smart contract data")
+	relevantBlock, ok := latestCallbackBlock(c, req)
+	if !ok {
 		return
 	}
-
-	fmt.Println("Smart Contract Token Data :", string(smartContractTokenData))
-
-	var dataReply SmartContractDataReply
-
-	if err := json.Unmarshal(smartContractTokenData, &dataReply); err != nil {
-		fmt.Println("Error:", err)
+	var payload AddActivityPayload
+	err := json.Unmarshal([]byte(relevantBlock.SmartContractData), &payload)
+	if err != nil {
+		fmt.Println("Error unmarshaling JSON:", err)
+		respondError(c, http.StatusUnprocessableEntity, CodeUnexpectedBlock, "Block does not carry an add_activity input", err)
 		return
 	}
-	fmt.Println("Data reply in APICallBackTrigger", dataReply)
-	smartContractData := dataReply.SCTDataReply
-	var relevantBlock *SCTDataReply
-
-	// var blockId string
-	var blockNo uint64
-	for _, data := range smartContractData {
-		relevantBlock = &data // Assuming you want the last block
-		fmt.Println("The relevant block is :", relevantBlock)
-		// blockId = data.BlockId
-		blockNo = data.BlockNo
-	}
-	if blockNo == 0 {
-		fmt.Println("The block number is zero which is the genesis block")
+	if payload.AddActivity.ActivityID == "" {
+		fmt.Println("Block carries no add_activity input:", relevantBlock.SmartContractData)
+		respondError(c, http.StatusUnprocessableEntity, CodeUnexpectedBlock, "Block does not carry an add_activity input", nil)
 		return
 	}
-	var payload AddActivityPayload
-	err = json.Unmarshal([]byte(relevantBlock.SmartContractData), &payload)
-	if err != nil {
-		fmt.Println("Error unmarshaling JSON:", err)
+	if !claimCallbackBlock(c, smartContractHash, relevantBlock.BlockId) {
 		return
 	}
-	registry := wasmbridge.NewHostFunctionRegistry()
-
-	// Create your custom host function
-	registry.Register(rubix_interaction.NewWriteToJsonFile())
+	registry := newContractRegistry(ActivityRecordKind)
 	hostFunction := registry.GetHostFunctions()
 	fmt.Println("Host function is :", hostFunction)
-	wasmPath, err := getWasmContractPath(smartContractHash, req.Port)
+	payload.AddActivity.BlockHash = relevantBlock.BlockId
+	contractInput, err := rubix_interaction.ContractMessage(payload.AddActivity)
 	if err != nil {
-		fmt.Println("Failed to get wasm path")
-	}
-	wasmModule, err := wasmbridge.NewWasmModule(
-		wasmPath,
-		registry,
-		// wasmbridge.WithRubixNodeAddress("http://localhost:20002"), //config.NodeAddress),
-		// wasmbridge.WithQuorumType(2),
-	)
-	if err != nil {
-		log.Printf("Failed to initialize WASM module: %v", err)
+		releaseCallbackBlock(smartContractHash, relevantBlock.BlockId)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to build contract input", err)
 		return
 	}
-	contractInput := fmt.Sprintf(`{"add_activity": {"activity_id":"%s","reward_points":%d,"block_hash":"%s"}}`, payload.AddActivity.ActivityID, payload.AddActivity.RewardPoints, relevantBlock.BlockId)
 	fmt.Println("The contract input is :", contractInput)
-	result, err := wasmModule.CallFunction(contractInput)
+	result, err := runContract(smartContractHash, req.Port, "", registry, contractInput)
 	if err != nil {
 		log.Printf("Failed to call WASM function: %v", err)
+		releaseCallbackBlock(smartContractHash, relevantBlock.BlockId)
+		respondError(c, http.StatusInternalServerError, CodeContractFailed, "Failed to run the activity contract", err)
 		return
 	}
 	fmt.Println("The result is :", result)
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Activity recorded",
+		"block_id": relevantBlock.BlockId,
+		"result":   result,
+	})
+}
+
+// latestCallbackBlock fetches the block a callback is about: the latest on
+// the contract's token chain. The genesis block carries no input, so a
+// callback for it is answered here. When ok is false the response has been
+// written.
+func latestCallbackBlock(c *gin.Context, req ContractInputRequest) (block *rubix_interaction.SmartContractBlock, ok bool) {
+	client := newNodeClient(req.Port)
+	dataReply, err := client.GetSmartContractTokenChainData(c.Request.Context(), &rubix_interaction.TokenChainDataRequest{
+		Token:  req.SmartContractHash,
+		Latest: true,
+	})
+	if err != nil {
+		respondNodeError(c, "Unable to fetch latest smart contract data", err)
+		return nil, false
+	}
+	fmt.Println("Data reply in callback", dataReply)
+	block = dataReply.LatestBlock()
+	if block == nil || block.BlockNo == 0 {
+		fmt.Println("The block number is zero which is the genesis block")
+		c.JSON(http.StatusOK, gin.H{"message": "Genesis block, nothing to process"})
+		return nil, false
+	}
+	fmt.Println("The relevant block is :", block)
+	return block, true
 }
 
 // Function to read BlockId from a JSON file
@@ -492,8 +763,8 @@ func getBlockIDFromJSONFile(filePath string) (string, error) {
 	return data.BlockID, nil
 }
 
-// func getSCTDataAfterBlockID(sctDataReplies []SCTDataReply, blockID string) []SCTDataReply {
-// 	var result []SCTDataReply
+// func getSCTDataAfterBlockID(sctDataReplies []rubix_interaction.SmartContractBlock, blockID string) []rubix_interaction.SmartContractBlock {
+// 	var result []rubix_interaction.SmartContractBlock
 // 	found := false
 
 // 	for _, data := range sctDataReplies {
@@ -507,7 +778,7 @@ func getBlockIDFromJSONFile(filePath string) (string, error) {
 // 	return result
 // }
 
-func getNextSCTDataAfterBlockID(sctDataReplies []SCTDataReply, blockID string) *SCTDataReply {
+func getNextSCTDataAfterBlockID(sctDataReplies []rubix_interaction.SmartContractBlock, blockID string) *rubix_interaction.SmartContractBlock {
 	for i, data := range sctDataReplies {
 		if data.BlockId == blockID && i+1 < len(sctDataReplies) {
 			return &sctDataReplies[i+1] // Return the next entry
@@ -516,160 +787,40 @@ func getNextSCTDataAfterBlockID(sctDataReplies []SCTDataReply, blockID string) *
 	return nil // Return nil if no matching block or no next entry
 }
 
-// Handler function for /callback/nft
+// ftDappHandler is the transfer contract callback. It matches the blocks of
+// the token chain to open reward transfers and settles each one it finds.
 func ftDappHandler(c *gin.Context) {
 	var req ContractInputRequest
-	fmt.Println("==========================================")
-	fmt.Println("🔔 ftDappHandler TRIGGERED - Callback received!")
-	fmt.Println("==========================================")
-	fmt.Printf("Timestamp: %s\n", time.Now().Format(time.RFC3339))
-
-	// Add delay to give API time to complete setup (transactionID, DB creation, registration)
-	fmt.Println("⏳ Waiting 5 seconds for API setup to complete...")
-	time.Sleep(5 * time.Second)
-	fmt.Println("✅ Delay complete, processing callback...")
-	// cfg, err := config.GetConfig()
-	// if err != nil {
-	// 	fmt.Println("failed to load config: %w", err)
-	// }
-	// config.GetNodeNameByPort(cfg, req.Port)
-	err := json.NewDecoder(c.Request.Body).Decode(&req)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
-		fmt.Printf("Error reading response body: %s\n", err)
+	fmt.Printf("ftDappHandler triggered at %s\n", time.Now().Format(time.RFC3339))
+	if !bindRequest(c, &req) {
 		return
 	}
-	url := fmt.Sprintf("http://localhost:%s", req.Port)
+	client := newNodeClient(req.Port)
+	url := client.BaseURL()
 	fmt.Println("The url is :", url)
 
-	// // config := GetConfig()
 	smartContractHash := req.SmartContractHash
 	fmt.Println("Received Smart Contract hash: ", smartContractHash)
 
-	smartContractTokenData := rubix_interaction.GetSmartContractData(smartContractHash, url) //config.NodeAddress)
-	if smartContractTokenData == nil {
-		fmt.Println("Unable to fetch latest smart contract data")
-		return
-	}
-
-	fmt.Println("Smart Contract Token Data :", string(smartContractTokenData))
-
-	var dataReply SmartContractDataReply
-
-	if err := json.Unmarshal(smartContractTokenData, &dataReply); err != nil {
-		fmt.Println("Error:", err)
-		return
-	}
-	fmt.Println("Data reply in runDappHandler", dataReply)
-	smartContractData := dataReply.SCTDataReply
-	var relevantData string
-	for _, reply := range smartContractData {
-		fmt.Println("SmartContractData:", reply.SmartContractData)
-		relevantData = reply.SmartContractData
-	}
-	fmt.Println("The relevant data is :", relevantData)
-	var inputMap map[string]interface{}
-	err1 := json.Unmarshal([]byte(relevantData), &inputMap)
-	if err1 != nil {
-		fmt.Println("Error unmarshalling input map:", err1)
-		return
-	}
-	if len(inputMap) != 1 {
-		return
-	}
-
-	var funcName string
-	var inputStruct interface{}
-	for key, value := range inputMap {
-		funcName = key
-		inputStruct = value
-	}
-	fmt.Println("The function name extracted =", funcName)
-	fmt.Println("The inputStruct Value :", inputStruct)
-
-	hostFnRegistry := wasmbridge.NewHostFunctionRegistry()
-	wasmPath, err := getWasmContractPath(smartContractHash, req.Port)
-	if err != nil {
-		fmt.Println("Failed to get wasm path")
-	}
-	// Initialize the WASM module
-
-	wasmModule, err := wasmbridge.NewWasmModule(
-		wasmPath,
-		hostFnRegistry,
-		wasmbridge.WithRubixNodeAddress(url), //config.NodeAddress),
-		wasmbridge.WithQuorumType(2),
-	)
+	// The whole chain is fetched: if several transfers were committed before
+	// this callback ran, the latest block is not necessarily ours
+	dataReply, err := client.GetSmartContractTokenChainData(c.Request.Context(), &rubix_interaction.TokenChainDataRequest{
+		Token:  smartContractHash,
+		Latest: false,
+	})
 	if err != nil {
-		log.Printf("Failed to initialize WASM module: %v", err)
-		return
-	}
-
-	executionResult, errExecuteContract := executeAndGetContractResult(wasmModule, relevantData)
-	fmt.Println("----------- FT Execution Result: ", executionResult)
-	if errExecuteContract != nil {
-		fmt.Println("The executionResult is ", executionResult)
+		respondNodeError(c, "Unable to fetch smart contract data", err)
 		return
 	}
 
-	var response RubixResponse
-
-	// Convert JSON string to struct
-	if executionResult == "success" {
-		response = RubixResponse{Status: true, Message: "FT Transferred Succesfully"}
-	} else {
-		err = json.Unmarshal([]byte(executionResult), &response)
-		if err != nil {
-			log.Printf("Error parsing JSON: %v", err)
-			return
-		}
-	}
-
-	// Extract BlockId from the latest block for callback correlation
-	var latestBlockId string
-	if len(smartContractData) > 0 {
-		latestBlockId = smartContractData[len(smartContractData)-1].BlockId
-		fmt.Println("==========================================")
-		fmt.Printf("🔑 ftDappHandler: Extracted BlockId: %s\n", latestBlockId)
-		fmt.Printf("📊 Total blocks in data: %d\n", len(smartContractData))
-		fmt.Println("==========================================")
-	} else {
-		fmt.Println("⚠️  ftDappHandler: No blocks found in smart contract data!")
-	}
-
-	// Signal the waiting APITransferReward through the TransferManager
-	if latestBlockId != "" {
-		fmt.Println("==========================================")
-		fmt.Printf("⏰ [%s] Attempting to send callback response for BlockId: %s\n", time.Now().Format("15:04:05.000"), latestBlockId)
-		fmt.Println("==========================================")
-
-		manager := GetTransferManager()
-		callbackResponse := CallbackResponse{
-			Success:      response.Status,
-			Message:      response.Message,
-			Data:         response.Result,
-			BlockId:      latestBlockId,
-			ContractData: relevantData,
-		}
-
-		if !response.Status {
-			callbackResponse.Error = fmt.Sprintf("Contract execution failed: %v", response.Message)
-		}
-
-		// This will update DB and notify waiting channel (if any)
-		success := manager.SendCallbackResponse(latestBlockId, callbackResponse)
-		fmt.Println("==========================================")
-		if success {
-			fmt.Printf("✅ [%s] ftDappHandler: Successfully notified pending request for BlockId: %s\n", time.Now().Format("15:04:05.000"), latestBlockId)
-		} else {
-			fmt.Printf("⚠️  [%s] ftDappHandler: No pending request found for BlockId: %s (checking DB fallback)\n", time.Now().Format("15:04:05.000"), latestBlockId)
-		}
-		fmt.Println("==========================================")
-	}
+	// Only transfers started under this contract version are settled from its
+	// chain; those started under another version wait for that one's callback
+	settled := GetTransferManager().SettleFromChain(c.Request.Context(), smartContractHash, req.Port, url, dataReply.SCTDataReply)
+	fmt.Printf("ftDappHandler settled %d transfer(s)\n", len(settled))
 
 	resultFinal := gin.H{
 		"message": "DApp executed successfully",
-		"data":    response,
+		"data":    settled,
 	}
 
 	// Return a response
@@ -685,30 +836,22 @@ func ftContract2Handler(c *gin.Context) {
 	// 	fmt.Println("failed to load config: %w", err)
 	// }
 
-	err := json.NewDecoder(c.Request.Body).Decode(&req)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
-		fmt.Printf("Error reading response body: %s\n", err)
+	if !bindRequest(c, &req) {
 		return
 	}
-	url := fmt.Sprintf("http://localhost:%s", req.Port)
+	client := newNodeClient(req.Port)
+	url := client.BaseURL()
 	fmt.Println("The url is :", url)
 	// // config := GetConfig()
 	smartContractHash := req.SmartContractHash
 	fmt.Println("Received Smart Contract hash: ", smartContractHash)
 
-	smartContractTokenData := rubix_interaction.GetSmartContractData(smartContractHash, url) //config.NodeAddress)
-	if smartContractTokenData == nil {
-		fmt.Println("Unable to fetch latest smart contract data")
-		return
-	}
-
-	fmt.Println("Smart Contract Token Data :", string(smartContractTokenData))
-
-	var dataReply SmartContractDataReply
-
-	if err := json.Unmarshal(smartContractTokenData, &dataReply); err != nil {
-		fmt.Println("Error:", err)
+	dataReply, err := client.GetSmartContractTokenChainData(c.Request.Context(), &rubix_interaction.TokenChainDataRequest{
+		Token:  smartContractHash,
+		Latest: true,
+	})
+	if err != nil {
+		respondNodeError(c, "Unable to fetch latest smart contract data", err)
 		return
 	}
 	fmt.Println("Data reply in runDappHandler", dataReply)
@@ -721,9 +864,11 @@ func ftContract2Handler(c *gin.Context) {
 	var inputMap map[string]interface{}
 	err1 := json.Unmarshal([]byte(relevantData), &inputMap)
 	if err1 != nil {
+		respondError(c, http.StatusUnprocessableEntity, CodeUnexpectedBlock, "Block does not carry a contract input", err1)
 		return
 	}
 	if len(inputMap) != 1 {
+		respondError(c, http.StatusUnprocessableEntity, CodeUnexpectedBlock, "Block input must call exactly one function", nil)
 		return
 	}
 
@@ -736,28 +881,12 @@ func ftContract2Handler(c *gin.Context) {
 	fmt.Println("The function name extracted =", funcName)
 	fmt.Println("The inputStruct Value :", inputStruct)
 
-	hostFnRegistry := wasmbridge.NewHostFunctionRegistry()
-	wasmPath, err := getWasmContractPath(smartContractHash, req.Port)
-	if err != nil {
-		fmt.Println("Failed to get wasm path")
-	}
-	// Initialize the WASM module
-
-	wasmModule, err := wasmbridge.NewWasmModule(
-		wasmPath,
-		hostFnRegistry,
-		wasmbridge.WithRubixNodeAddress(url), //config.NodeAddress),
-		wasmbridge.WithQuorumType(2),
-	)
-	if err != nil {
-		log.Printf("Failed to initialize WASM module: %v", err)
-		return
-	}
-
-	executionResult, errExecuteContract := executeAndGetContractResult(wasmModule, relevantData)
+	hostFnRegistry := newContractRegistry()
+	executionResult, errExecuteContract := runContract(smartContractHash, req.Port, url, hostFnRegistry, relevantData)
 	fmt.Println("----------- FT Execution Result: ", executionResult)
 	if errExecuteContract != nil {
 		fmt.Println("The executionResult is ", executionResult)
+		respondError(c, http.StatusInternalServerError, CodeContractFailed, "Failed to run the contract", errExecuteContract)
 		return
 	}
 
@@ -770,6 +899,7 @@ func ftContract2Handler(c *gin.Context) {
 		err = json.Unmarshal([]byte(executionResult), &response)
 		if err != nil {
 			log.Printf("Error parsing JSON: %v", err)
+			respondError(c, http.StatusInternalServerError, CodeContractFailed, "Contract returned an unreadable result", err)
 			return
 		}
 	}
@@ -820,6 +950,45 @@ func getWasmContractPath(contractHash, port string) (string, error) {
 	return "", fmt.Errorf("no wasm contract found in directory: %v", contractDir)
 }
 
+// newContractRegistry returns the host functions a contract run by the
+// server can import: the bridge's own, the read-side state queries and, if
+// any record kinds are given, write_to_json_file for those kinds
+func newContractRegistry(kinds ...rubix_interaction.RecordKind) *wasmbridge.HostFunctionRegistry {
+	registry := registerStateReaders(wasmbridge.NewHostFunctionRegistry())
+	if len(kinds) > 0 {
+		registry.Register(rubix_interaction.NewWriteToJsonFile(kinds...))
+	}
+	return registry
+}
+
+// runContract loads the contract's WASM from the node's SmartContract directory
+// and calls it with contractInput. When nodeURL is set the module can reach
+// the node. It is a variable so tests can run handlers without a node's files.
+var runContract = func(contractHash string, port string, nodeURL string, registry *wasmbridge.HostFunctionRegistry, contractInput string) (string, error) {
+	wasmPath, err := getWasmContractPath(contractHash, port)
+	if err != nil {
+		return "", fmt.Errorf("failed to get wasm path: %w", err)
+	}
+	fmt.Println("The wasm path is :", wasmPath)
+
+	var wasmModule *wasmbridge.WasmModule
+	if nodeURL != "" {
+		wasmModule, err = wasmbridge.NewWasmModule(
+			wasmPath,
+			registry,
+			wasmbridge.WithRubixNodeAddress(nodeURL),
+			wasmbridge.WithQuorumType(2),
+		)
+	} else {
+		wasmModule, err = wasmbridge.NewWasmModule(wasmPath, registry)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize WASM module: %w", err)
+	}
+
+	return executeAndGetContractResult(wasmModule, contractInput)
+}
+
 func executeAndGetContractResult(wasmModule *wasmbridge.WasmModule, contractInput string) (string, error) {
 	// Call the function
 	contractResult, err := wasmModule.CallFunction(contractInput)
@@ -830,27 +999,11 @@ func executeAndGetContractResult(wasmModule *wasmbridge.WasmModule, contractInpu
 	return contractResult, nil
 }
 
-// GetRewardPoints takes a JSON file path and an activity ID and returns the reward points for that activity.
-func GetRewardPoints(filePath string, activityID string) (int, error) {
-	// Read the JSON file
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return 0, err
-	}
-
-	// Parse the JSON into a slice of Activity structs
-	var activities []Activity
-	err = json.Unmarshal(data, &activities)
+// GetRewardPoints returns the registered reward points of an activity
+func GetRewardPoints(activityID string) (int, error) {
+	activity, err := database.GetActivity(activityID)
 	if err != nil {
 		return 0, err
 	}
-
-	// Search for the activity ID and return the reward points
-	for _, activity := range activities {
-		if activity.ActivityID == activityID {
-			return activity.RewardPoints, nil
-		}
-	}
-
-	return 0, errors.New("activity ID not found")
+	return activity.RewardPoints, nil
 }
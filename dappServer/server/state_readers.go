@@ -0,0 +1,101 @@
+package server
+
+import (
+	"dapp-server/database"
+	rubix_interaction "dapp-server/rubix-interaction"
+	"errors"
+	"fmt"
+
+	wasmbridge "github.com/rubixchain/rubix-wasm/go-wasm-bridge"
+)
+
+type readActivityQuery struct {
+	ActivityID string `json:"activity_id"`
+}
+
+// ReadActivityResponse is the answer to read_activity. Activity is nil when
+// the activity is not registered.
+type ReadActivityResponse struct {
+	Found    bool                     `json:"found"`
+	Activity *database.ActivityRecord `json:"activity,omitempty"`
+}
+
+// NewReadActivity returns the "read_activity" host function, which looks
+// up a registered activity: {"activity_id": "..."}
+func NewReadActivity() *rubix_interaction.StateReader {
+	return rubix_interaction.NewStateReader("read_activity", func(query readActivityQuery) (interface{}, error) {
+		if query.ActivityID == "" {
+			return nil, fmt.Errorf("read_activity needs activity_id")
+		}
+		activity, err := database.GetActivity(query.ActivityID)
+		if errors.Is(err, database.ErrActivityNotFound) {
+			return ReadActivityResponse{Found: false}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return ReadActivityResponse{Found: true, Activity: activity}, nil
+	})
+}
+
+type isAdminQuery struct {
+	AdminDID string `json:"admin_did"`
+}
+
+// IsAdminResponse is the answer to is_admin
+type IsAdminResponse struct {
+	AdminDID string `json:"admin_did"`
+	IsAdmin  bool   `json:"is_admin"`
+}
+
+// NewIsAdmin returns the "is_admin" host function, which reports whether a
+// DID is a registered admin: {"admin_did": "..."}
+func NewIsAdmin() *rubix_interaction.StateReader {
+	return rubix_interaction.NewStateReader("is_admin", func(query isAdminQuery) (interface{}, error) {
+		if query.AdminDID == "" {
+			return nil, fmt.Errorf("is_admin needs admin_did")
+		}
+		isAdmin, err := database.IsAdmin(query.AdminDID)
+		if err != nil {
+			return nil, err
+		}
+		return IsAdminResponse{AdminDID: query.AdminDID, IsAdmin: isAdmin}, nil
+	})
+}
+
+type userClaimsQuery struct {
+	UserDID string `json:"user_did"`
+}
+
+// UserClaimsResponse is the answer to get_user_claims
+type UserClaimsResponse struct {
+	UserDID string                   `json:"user_did"`
+	Claims  []database.ActivityClaim `json:"claims"`
+}
+
+// NewGetUserClaims returns the "get_user_claims" host function, which lists
+// the activity claims a user holds: {"user_did": "..."}
+func NewGetUserClaims() *rubix_interaction.StateReader {
+	return rubix_interaction.NewStateReader("get_user_claims", func(query userClaimsQuery) (interface{}, error) {
+		if query.UserDID == "" {
+			return nil, fmt.Errorf("get_user_claims needs user_did")
+		}
+		claims, err := database.GetActivityClaims(query.UserDID)
+		if err != nil {
+			return nil, err
+		}
+		if claims == nil {
+			claims = []database.ActivityClaim{}
+		}
+		return UserClaimsResponse{UserDID: query.UserDID, Claims: claims}, nil
+	})
+}
+
+// registerStateReaders adds every read-side host function to registry.
+// Contracts that do not import them are unaffected.
+func registerStateReaders(registry *wasmbridge.HostFunctionRegistry) *wasmbridge.HostFunctionRegistry {
+	registry.Register(NewReadActivity())
+	registry.Register(NewIsAdmin())
+	registry.Register(NewGetUserClaims())
+	return registry
+}
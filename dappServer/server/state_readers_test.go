@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"dapp-server/database"
+	rubix_interaction "dapp-server/rubix-interaction"
+)
+
+func TestStateReadersAnswerContractQueries(t *testing.T) {
+	query := func(reader *rubix_interaction.StateReader, input string) map[string]interface{} {
+		t.Helper()
+		answer, err := reader.Query([]byte(input))
+		if err != nil {
+			t.Fatalf("%s(%s): %v", reader.Name(), input, err)
+		}
+		var decoded map[string]interface{}
+		if err := json.Unmarshal(answer, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		return decoded
+	}
+
+	answer := query(NewReadActivity(), `{"activity_id":"yoga-once"}`)
+	activity, _ := answer["activity"].(map[string]interface{})
+	if answer["found"] != true || activity["reward_points"] != float64(30) {
+		t.Fatalf("read_activity = %v", answer)
+	}
+	if answer := query(NewReadActivity(), `{"activity_id":"no-such-activity"}`); answer["found"] != false {
+		t.Fatalf("read_activity of a missing activity = %v", answer)
+	}
+
+	if _, err := database.AddAdmin("bafybmireaderadmin"); err != nil {
+		t.Fatal(err)
+	}
+	if answer := query(NewIsAdmin(), `{"admin_did":"bafybmireaderadmin"}`); answer["is_admin"] != true {
+		t.Fatalf("is_admin = %v", answer)
+	}
+	if answer := query(NewIsAdmin(), `{"admin_did":"bafybmireaderstranger"}`); answer["is_admin"] != false {
+		t.Fatalf("is_admin of a stranger = %v", answer)
+	}
+
+	node := startNode(t)
+	registerCallback(t, node, testTransferContract, "api/call-back-trigger")
+	const receiver = "bafybmireaderreceiver"
+	if status, body := postJSON(t, "/api/rewards/transfer", TransferRewardRequest{
+		ActivityID: []string{"yoga-once"},
+		UserDID:    receiver,
+		AdminDID:   testAdminDID,
+	}); status != http.StatusOK {
+		t.Fatalf("transfer = %d, %v", status, body)
+	}
+	answer = query(NewGetUserClaims(), `{"user_did":"`+receiver+`"}`)
+	claims, _ := answer["claims"].([]interface{})
+	if len(claims) != 1 || claims[0].(map[string]interface{})["activity_id"] != "yoga-once" {
+		t.Fatalf("get_user_claims = %v", answer)
+	}
+
+	if _, err := NewIsAdmin().Query([]byte(`{"did":"bafybmireaderadmin"}`)); err == nil {
+		t.Fatal("is_admin accepted a malformed query")
+	}
+}
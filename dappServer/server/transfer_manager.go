@@ -1,14 +1,24 @@
 package server
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"dapp-server/database"
+	rubix_interaction "dapp-server/rubix-interaction"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"regexp"
+	"sort"
 	"sync"
 	"time"
 )
 
 // CallbackResponse represents the result from ftDappHandler callback
 type CallbackResponse struct {
+	RequestID    string      `json:"request_id"`
 	Success      bool        `json:"success"`
 	Message      string      `json:"message"`
 	Data         interface{} `json:"data"`
@@ -24,11 +34,20 @@ type PendingRequest struct {
 	CreatedAt     time.Time
 }
 
-// TransferManager manages both persistent status (DB) and pending channels (in-memory)
+// TransferManager manages both persistent status (DB) and pending channels (in-memory).
+//
+// Every reward transfer gets a request ID before it is executed, and that ID
+// is embedded in the contract data as a "[ref:<id>]" marker. A callback then
+// correlates blocks to transfers by reading the marker back from each block,
+// rather than assuming the latest block belongs to whoever is waiting.
 type TransferManager struct {
-	// Temporary storage for pending requests: blockId -> channel
-	pendingByBlockId map[string]*PendingRequest
-	pendingMu        sync.RWMutex
+	// Requests whose HTTP caller is still waiting: requestID -> channel
+	pendingByRequestID map[string]*PendingRequest
+	pendingMu          sync.RWMutex
+
+	// Status watchers (long-poll and event stream clients): requestID -> set
+	watchers   map[string]map[chan struct{}]struct{}
+	watchersMu sync.Mutex
 }
 
 var (
@@ -40,41 +59,99 @@ var (
 func GetTransferManager() *TransferManager {
 	transferManagerOnce.Do(func() {
 		transferManager = &TransferManager{
-			pendingByBlockId: make(map[string]*PendingRequest),
+			pendingByRequestID: make(map[string]*PendingRequest),
+			watchers:           make(map[string]map[chan struct{}]struct{}),
 		}
+		database.OnTransferStatusChange(transferManager.notifyWatchers)
 		// Start cleanup goroutine
 		go transferManager.cleanupStaleRequests()
 	})
 	return transferManager
 }
 
-// CreateTransfer creates a new transfer status in DB and returns the status
+var transferReferencePattern = regexp.MustCompile(`\[ref:([0-9a-f]+)\]`)
+
+// NewTransferRequestID returns a fresh ID to correlate a transfer with its block
+func NewTransferRequestID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate request ID: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// TransferComment returns the FT transfer comment carrying the request ID
+func TransferComment(requestID string) string {
+	return fmt.Sprintf("Transfer of reward via contract [ref:%s]", requestID)
+}
+
+// transferReference extracts the request ID from transfer_sample_ft contract
+// data, or returns "" if the data does not carry one
+func transferReference(contractData string) string {
+	var payload struct {
+		TransferSampleFT *struct {
+			FTInfo struct {
+				Comment string `json:"comment"`
+			} `json:"ft_info"`
+		} `json:"transfer_sample_ft"`
+	}
+	if err := json.Unmarshal([]byte(contractData), &payload); err != nil || payload.TransferSampleFT == nil {
+		return ""
+	}
+	match := transferReferencePattern.FindStringSubmatch(payload.TransferSampleFT.FTInfo.Comment)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// TransferFingerprint identifies what a transfer request asked for, so a
+// reused idempotency key can be checked against the original request
+func TransferFingerprint(activityIDs []string, userDID string, adminDID string) string {
+	sorted := append([]string(nil), activityIDs...)
+	sort.Strings(sorted)
+	payload, _ := json.Marshal([]interface{}{sorted, userDID, adminDID})
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateTransfer creates a new transfer status in DB, together with the
+// activity claims it makes, and returns the status. If idempotencyKey is
+// already taken, database.ErrDuplicateIdempotencyKey is returned unwrapped;
+// if an activity was already claimed, a *database.DuplicateClaimsError is.
 func (m *TransferManager) CreateTransfer(
-	transactionID string,
-	blockId string,
+	requestID string,
+	idempotencyKey string,
 	contractHash string,
 	activityIDs []string,
 	userDID string,
 	adminDID string,
-	rewardPoints int,
+	reward Reward,
 ) (*database.TransferStatus, error) {
 
+	now := time.Now()
 	status := &database.TransferStatus{
-		RequestID:    transactionID,
-		BlockId:      blockId,
-		ActivityIDs:  activityIDs,
-		UserDID:      userDID,
-		AdminDID:     adminDID,
-		RewardPoints: rewardPoints,
-		Status:       "pending",
-		Message:      "Transfer initiated, waiting for blockchain confirmation",
-		ContractHash: contractHash,
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
+		RequestID:          requestID,
+		IdempotencyKey:     idempotencyKey,
+		RequestFingerprint: TransferFingerprint(activityIDs, userDID, adminDID),
+		ActivityIDs:        activityIDs,
+		UserDID:            userDID,
+		AdminDID:           adminDID,
+		RewardPoints:       reward.Points,
+		TokenCount:         reward.Tokens,
+		Status:             "pending",
+		Message:            "Transfer initiated, waiting for blockchain confirmation",
+		ContractHash:       contractHash,
+		CreatedAt:          now,
+		UpdatedAt:          now,
 	}
 
 	// Save to database
-	err := database.CreateTransferStatus(status)
+	err := database.CreateTransferWithClaims(status, activityClaims(requestID, userDID, activityIDs, now))
+	var duplicateClaims *database.DuplicateClaimsError
+	if err == database.ErrDuplicateIdempotencyKey || errors.As(err, &duplicateClaims) {
+		return nil, err
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create transfer status: %w", err)
 	}
@@ -82,107 +159,100 @@ func (m *TransferManager) CreateTransfer(
 	return status, nil
 }
 
-// RegisterPendingRequest creates a response channel for a blockId
-func (m *TransferManager) RegisterPendingRequest(transactionID string, blockId string) chan CallbackResponse {
+// RegisterPendingRequest creates a response channel for a request ID. It must
+// be called before the transfer is signed, since the node may call back
+// before the signature response returns.
+func (m *TransferManager) RegisterPendingRequest(requestID string) chan CallbackResponse {
 	m.pendingMu.Lock()
 	defer m.pendingMu.Unlock()
 
 	// Create response channel
 	responseChan := make(chan CallbackResponse, 1)
-	m.pendingByBlockId[blockId] = &PendingRequest{
-		TransactionID: transactionID,
+	m.pendingByRequestID[requestID] = &PendingRequest{
+		TransactionID: requestID,
 		ResponseChan:  responseChan,
 		CreatedAt:     time.Now(),
 	}
 
-	fmt.Printf("Registered pending request: transactionID=%s, blockId=%s\n", transactionID, blockId)
+	fmt.Printf("Registered pending request: requestID=%s\n", requestID)
 	return responseChan
 }
 
-// SendCallbackResponse sends a callback response to a pending request by blockId
-func (m *TransferManager) SendCallbackResponse(blockId string, response CallbackResponse) bool {
+// ForgetPendingRequest drops the waiter for a request whose caller is gone
+func (m *TransferManager) ForgetPendingRequest(requestID string) {
 	m.pendingMu.Lock()
 	defer m.pendingMu.Unlock()
-
-	fmt.Printf("📋 SendCallbackResponse: Looking for blockId: %s\n", blockId)
-	fmt.Printf("📋 Current pending requests count: %d\n", len(m.pendingByBlockId))
-
-	if req, exists := m.pendingByBlockId[blockId]; exists {
-		fmt.Printf("✅ Found pending request for blockId: %s, transactionID: %s\n", blockId, req.TransactionID)
-
-		// Update persistent status in DB
-		updates := map[string]interface{}{
-			"message": response.Message,
-		}
-		if response.Success {
-			updates["status"] = "success"
-		} else {
-			updates["status"] = "failed"
-			updates["error_details"] = response.Error
-		}
-
-		err := database.UpdateTransferStatus(req.TransactionID, updates)
-		if err != nil {
-			fmt.Printf("Failed to update transfer status in DB: %v\n", err)
-		}
-
-		// Send to channel if still waiting
-		select {
-		case req.ResponseChan <- response:
-			close(req.ResponseChan)
-			delete(m.pendingByBlockId, blockId)
-			fmt.Printf("Successfully sent callback response for blockId: %s\n", blockId)
-			return true
-		default:
-			// Channel closed or full
-			delete(m.pendingByBlockId, blockId)
-			fmt.Printf("Failed to send callback response (channel closed/full) for blockId: %s\n", blockId)
-			return false
-		}
-	}
-
-	// Even if no pending request, update status in DB by blockId
-	fmt.Printf("❌ No pending request found for blockId: %s\n", blockId)
-	fmt.Printf("📋 Available keys in pending requests: ")
-	for key := range m.pendingByBlockId {
-		fmt.Printf("%s, ", key)
-	}
-	fmt.Printf("\n")
-	fmt.Printf("⤵️  Falling back to DB update by blockId\n")
-	m.updateStatusByBlockId(blockId, response)
-	return false
+	delete(m.pendingByRequestID, requestID)
 }
 
-// updateStatusByBlockId updates status when we only have blockId (fallback for late callbacks)
-func (m *TransferManager) updateStatusByBlockId(blockId string, response CallbackResponse) {
-	status, err := database.GetTransferStatusByBlockId(blockId)
-	if err != nil {
-		fmt.Printf("Failed to find transfer by blockId %s: %v\n", blockId, err)
-		return
-	}
+// SetTransactionID records the Rubix transaction ID the transfer was signed under
+func (m *TransferManager) SetTransactionID(requestID string, transactionID string) error {
+	return database.UpdateTransferStatus(requestID, map[string]interface{}{
+		"transaction_id": transactionID,
+	})
+}
 
+// SendCallbackResponse records the outcome of a transfer and hands it to the
+// waiting request, if any. It reports whether a waiter received it.
+func (m *TransferManager) SendCallbackResponse(requestID string, response CallbackResponse) bool {
+	// Update persistent status in DB
 	updates := map[string]interface{}{
 		"message": response.Message,
 	}
+	if response.BlockId != "" {
+		updates["block_id"] = response.BlockId
+	}
 	if response.Success {
 		updates["status"] = "success"
 	} else {
 		updates["status"] = "failed"
 		updates["error_details"] = response.Error
 	}
+	if err := database.UpdateTransferStatus(requestID, updates); err != nil {
+		fmt.Printf("Failed to update transfer status in DB: %v\n", err)
+	}
+	if !response.Success {
+		// Nothing was transferred, so the activities can be claimed again
+		if err := database.ReleaseActivityClaims(requestID); err != nil {
+			fmt.Printf("Failed to release activity claims for %s: %v\n", requestID, err)
+		}
+	}
 
-	err = database.UpdateTransferStatus(status.RequestID, updates)
-	if err != nil {
-		fmt.Printf("Failed to update transfer status: %v\n", err)
-	} else {
-		fmt.Printf("Updated transfer status for transactionID: %s\n", status.RequestID)
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+
+	req, exists := m.pendingByRequestID[requestID]
+	if !exists {
+		fmt.Printf("No waiting request for requestID: %s (status stored in DB)\n", requestID)
+		return false
 	}
+	delete(m.pendingByRequestID, requestID)
+
+	// The channel is buffered, so this never blocks
+	select {
+	case req.ResponseChan <- response:
+		close(req.ResponseChan)
+		fmt.Printf("Successfully sent callback response for requestID: %s\n", requestID)
+		return true
+	default:
+		fmt.Printf("Failed to send callback response (channel full) for requestID: %s\n", requestID)
+		return false
+	}
+}
+
+// MarkFailed settles a transfer that never reached the chain
+func (m *TransferManager) MarkFailed(requestID string, message string, cause error) {
+	m.SendCallbackResponse(requestID, CallbackResponse{
+		Success: false,
+		Message: message,
+		Error:   cause.Error(),
+	})
 }
 
-// MarkTimeout marks a transfer as timed out and cleans up pending request
-func (m *TransferManager) MarkTimeout(transactionID string, blockId string) error {
-	// Update in database
-	err := database.UpdateTransferStatus(transactionID, map[string]interface{}{
+// MarkTimeout marks a transfer as timed out and cleans up pending request.
+// A transfer whose block has already been claimed is left alone.
+func (m *TransferManager) MarkTimeout(requestID string) error {
+	_, err := database.UpdateTransferStatusIf(requestID, []string{"pending"}, map[string]interface{}{
 		"status":  "timeout",
 		"message": "Transfer confirmation timed out (blockchain may still be processing)",
 	})
@@ -190,17 +260,141 @@ func (m *TransferManager) MarkTimeout(transactionID string, blockId string) erro
 		return fmt.Errorf("failed to mark timeout in DB: %w", err)
 	}
 
-	// Clean up pending request
-	m.pendingMu.Lock()
-	defer m.pendingMu.Unlock()
+	m.ForgetPendingRequest(requestID)
+	return nil
+}
 
-	if req, exists := m.pendingByBlockId[blockId]; exists {
-		close(req.ResponseChan)
-		delete(m.pendingByBlockId, blockId)
-		fmt.Printf("Cleaned up timed out request: transactionID=%s, blockId=%s\n", transactionID, blockId)
+// SettleFromChain matches the blocks of a transfer contract's token chain to
+// open transfers and runs the contract for each newly matched block. A block
+// matches a transfer when its contract data carries the transfer's request ID
+// and it was executed by the transfer's admin DID. Claims go through the
+// database, so concurrent callbacks never run the same block twice.
+func (m *TransferManager) SettleFromChain(
+	ctx context.Context,
+	contractHash string,
+	port string,
+	nodeURL string,
+	blocks []rubix_interaction.SmartContractBlock,
+) []CallbackResponse {
+	open, err := database.ListOpenTransfers(contractHash)
+	if err != nil {
+		fmt.Printf("Failed to list open transfers: %v\n", err)
+		return nil
+	}
+	if len(open) == 0 {
+		return nil
+	}
+	openByRequestID := make(map[string]*database.TransferStatus, len(open))
+	for _, transfer := range open {
+		openByRequestID[transfer.RequestID] = transfer
 	}
 
-	return nil
+	var settled []CallbackResponse
+	// Newest blocks first: open transfers are almost always near the tip
+	for i := len(blocks) - 1; i >= 0 && len(openByRequestID) > 0; i-- {
+		if ctx.Err() != nil {
+			break
+		}
+		block := blocks[i]
+		requestID := transferReference(block.SmartContractData)
+		transfer, ok := openByRequestID[requestID]
+		if !ok {
+			continue
+		}
+		delete(openByRequestID, requestID)
+
+		if block.ExecutorDID != transfer.AdminDID {
+			fmt.Printf("Block %s references %s but was executed by %s, expected %s\n",
+				block.BlockId, requestID, block.ExecutorDID, transfer.AdminDID)
+			continue
+		}
+
+		claimed, err := database.ClaimTransferBlock(requestID, block.BlockId)
+		if err != nil {
+			fmt.Printf("Failed to claim block %s for %s: %v\n", block.BlockId, requestID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		response := executeTransferBlock(contractHash, port, nodeURL, block)
+		response.RequestID = requestID
+		m.SendCallbackResponse(requestID, response)
+		settled = append(settled, response)
+	}
+	return settled
+}
+
+// executeTransferBlock runs the transfer contract for a claimed block
+func executeTransferBlock(contractHash string, port string, nodeURL string, block rubix_interaction.SmartContractBlock) CallbackResponse {
+	executionResult, err := runContract(contractHash, port, nodeURL, newContractRegistry(), block.SmartContractData)
+	fmt.Println("----------- FT Execution Result: ", executionResult)
+	if err != nil {
+		return CallbackResponse{
+			Success:      false,
+			Message:      "Failed to execute transfer contract",
+			Error:        err.Error(),
+			BlockId:      block.BlockId,
+			ContractData: block.SmartContractData,
+		}
+	}
+
+	var response RubixResponse
+	// Convert JSON string to struct
+	if executionResult == "success" {
+		response = RubixResponse{Status: true, Message: "FT Transferred Succesfully"}
+	} else if err := json.Unmarshal([]byte(executionResult), &response); err != nil {
+		response = RubixResponse{Status: false, Message: fmt.Sprintf("Unexpected contract result: %s", executionResult)}
+	}
+
+	callbackResponse := CallbackResponse{
+		Success:      response.Status,
+		Message:      response.Message,
+		Data:         response.Result,
+		BlockId:      block.BlockId,
+		ContractData: block.SmartContractData,
+	}
+	if !response.Status {
+		callbackResponse.Error = fmt.Sprintf("Contract execution failed: %v", response.Message)
+	}
+	return callbackResponse
+}
+
+// WatchTransfer returns a channel that is signalled whenever the transfer's
+// row changes, and a function to stop watching. Signals are coalesced, so
+// the watcher should re-read the row rather than count them.
+func (m *TransferManager) WatchTransfer(requestID string) (<-chan struct{}, func()) {
+	changed := make(chan struct{}, 1)
+
+	m.watchersMu.Lock()
+	if m.watchers[requestID] == nil {
+		m.watchers[requestID] = make(map[chan struct{}]struct{})
+	}
+	m.watchers[requestID][changed] = struct{}{}
+	m.watchersMu.Unlock()
+
+	stop := func() {
+		m.watchersMu.Lock()
+		defer m.watchersMu.Unlock()
+		delete(m.watchers[requestID], changed)
+		if len(m.watchers[requestID]) == 0 {
+			delete(m.watchers, requestID)
+		}
+	}
+	return changed, stop
+}
+
+func (m *TransferManager) notifyWatchers(requestID string) {
+	m.watchersMu.Lock()
+	defer m.watchersMu.Unlock()
+	for changed := range m.watchers[requestID] {
+		select {
+		case changed <- struct{}{}:
+		default:
+			// A signal is already queued
+		}
+	}
 }
 
 // cleanupStaleRequests removes stale pending requests (timeout after 10 minutes)
@@ -211,36 +405,20 @@ func (m *TransferManager) cleanupStaleRequests() {
 	for range ticker.C {
 		m.pendingMu.Lock()
 		now := time.Now()
-		for blockId, req := range m.pendingByBlockId {
+		for requestID, req := range m.pendingByRequestID {
 			if now.Sub(req.CreatedAt) > 10*time.Minute {
 				close(req.ResponseChan)
-				delete(m.pendingByBlockId, blockId)
-				fmt.Printf("Cleaned up stale pending request: transactionID=%s, blockId=%s\n", req.TransactionID, blockId)
+				delete(m.pendingByRequestID, requestID)
+				fmt.Printf("Cleaned up stale pending request: requestID=%s\n", requestID)
 			}
 		}
 		m.pendingMu.Unlock()
 	}
 }
 
-// UpdatePendingRequestBlockId updates the blockId key for a pending request
-// This is used when we initially register with a temporary blockId, then get the actual one
-func (m *TransferManager) UpdatePendingRequestBlockId(oldBlockId string, newBlockId string) {
-	m.pendingMu.Lock()
-	defer m.pendingMu.Unlock()
-
-	if req, exists := m.pendingByBlockId[oldBlockId]; exists {
-		// Move the pending request to the new blockId key
-		m.pendingByBlockId[newBlockId] = req
-		delete(m.pendingByBlockId, oldBlockId)
-		fmt.Printf("🔄 Updated pending request blockId mapping: %s → %s\n", oldBlockId, newBlockId)
-	} else {
-		fmt.Printf("⚠️  UpdatePendingRequestBlockId: No pending request found for oldBlockId: %s\n", oldBlockId)
-	}
-}
-
 // GetPendingCount returns the number of pending requests (for debugging)
 func (m *TransferManager) GetPendingCount() int {
 	m.pendingMu.RLock()
 	defer m.pendingMu.RUnlock()
-	return len(m.pendingByBlockId)
+	return len(m.pendingByRequestID)
 }
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"dapp-server/config"
+	"dapp-server/database"
+	rubix_interaction "dapp-server/rubix-interaction"
+	"fmt"
+	"time"
+)
+
+// RecoveryReport summarises what Recover did with the transfers a previous
+// run left unfinished
+type RecoveryReport struct {
+	Found       int      `json:"found"`
+	Settled     int      `json:"settled"`
+	Interrupted int      `json:"interrupted"`
+	TimedOut    int      `json:"timed_out"`
+	Errors      []string `json:"errors,omitempty"`
+}
+
+// Recover settles the transfers that were still in flight when the server
+// last stopped. Only transfers created before startedAt are touched, so it
+// can run alongside new requests.
+//
+// Open transfers are matched against the token chain exactly as a callback
+// would match them. Those without a block yet are moved to "timeout", which
+// keeps them open: a late callback still settles them. Transfers whose
+// contract was running when the server stopped are marked failed rather
+// than re-run, since the contract may already have moved the tokens; their
+// activity claims are kept for the same reason.
+func (m *TransferManager) Recover(ctx context.Context, startedAt time.Time) RecoveryReport {
+	var report RecoveryReport
+
+	unsettled, err := database.ListUnsettledTransfers(startedAt)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return report
+	}
+	report.Found = len(unsettled)
+	if len(unsettled) == 0 {
+		return report
+	}
+	fmt.Printf("Recovering %d unsettled transfer(s)\n", len(unsettled))
+
+	var open []*database.TransferStatus
+	for _, transfer := range unsettled {
+		if transfer.Status != "processing" {
+			open = append(open, transfer)
+			continue
+		}
+		interrupted, err := database.UpdateTransferStatusIf(transfer.RequestID, []string{"processing"}, map[string]interface{}{
+			"status":        "failed",
+			"message":       "Server stopped while the transfer contract was running",
+			"error_details": fmt.Sprintf("contract execution for block %s was interrupted; check the receiver's balance before retrying", transfer.BlockId),
+		})
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", transfer.RequestID, err))
+			continue
+		}
+		if interrupted {
+			report.Interrupted++
+		}
+	}
+
+	settled, _, errs := m.settleOpenTransfers(ctx, open)
+	report.Settled = len(settled)
+	report.Errors = append(report.Errors, errs...)
+
+	for _, transfer := range open {
+		if transfer.Status != "pending" {
+			continue
+		}
+		timedOut, err := database.UpdateTransferStatusIf(transfer.RequestID, []string{"pending"}, map[string]interface{}{
+			"status":  "timeout",
+			"message": "Server restarted before the transfer was confirmed (blockchain may still be processing)",
+		})
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", transfer.RequestID, err))
+			continue
+		}
+		if timedOut {
+			report.TimedOut++
+		}
+	}
+
+	fmt.Printf("Transfer recovery finished: %+v\n", report)
+	return report
+}
+
+// settleOpenTransfers fetches the token chain of every contract the given
+// transfers were made on, from the node of the admin that made them, and
+// settles whatever it can match. unchecked holds the request IDs of the
+// transfers whose chain could not be read, so nothing is known about them.
+func (m *TransferManager) settleOpenTransfers(ctx context.Context, transfers []*database.TransferStatus) (settled []CallbackResponse, unchecked map[string]bool, errs []string) {
+	unchecked = make(map[string]bool)
+	cfg, err := config.GetConfig()
+	if err != nil {
+		for _, transfer := range transfers {
+			unchecked[transfer.RequestID] = true
+		}
+		return nil, unchecked, []string{err.Error()}
+	}
+
+	type chainSource struct {
+		contractHash string
+		port         string
+	}
+	var sources []chainSource
+	requestIDs := make(map[chainSource][]string)
+	for _, transfer := range transfers {
+		port, exists := config.GetPortByDid(cfg, transfer.AdminDID)
+		if !exists {
+			unchecked[transfer.RequestID] = true
+			errs = append(errs, fmt.Sprintf("%s: node port not found for admin DID %s", transfer.RequestID, transfer.AdminDID))
+			continue
+		}
+		source := chainSource{transfer.ContractHash, port}
+		if _, seen := requestIDs[source]; !seen {
+			sources = append(sources, source)
+		}
+		requestIDs[source] = append(requestIDs[source], transfer.RequestID)
+	}
+
+	for _, source := range sources {
+		client := newNodeClient(source.port)
+		dataReply, err := client.GetSmartContractTokenChainData(ctx, &rubix_interaction.TokenChainDataRequest{
+			Token:  source.contractHash,
+			Latest: false,
+		})
+		if err != nil {
+			for _, requestID := range requestIDs[source] {
+				unchecked[requestID] = true
+			}
+			errs = append(errs, fmt.Sprintf("%s on node %s: %v", source.contractHash, source.port, err))
+			continue
+		}
+		settled = append(settled, m.SettleFromChain(ctx, source.contractHash, source.port, client.BaseURL(), dataReply.SCTDataReply)...)
+	}
+	return settled, unchecked, errs
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"dapp-server/database"
+	rubix_interaction "dapp-server/rubix-interaction"
+)
+
+// commitTransfer records a transfer and commits its block without going
+// through the API, as if the server stopped right after signing
+func commitTransfer(t *testing.T, receiver string) string {
+	t.Helper()
+	requestID := createTransfer(t, receiver)
+	ctx := context.Background()
+	client := newNodeClient(testPort)
+	message, err := transferContractMessage(requestID, testAdminDID, receiver, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	executeID, err := client.ExecuteSmartContract(ctx, rubix_interaction.NewExecuteSmartContractRequest(
+		testTransferContract, testAdminDID, message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rubix_interaction.Sign(ctx, client, testAdminDID, executeID); err != nil {
+		t.Fatal(err)
+	}
+	return requestID
+}
+
+func TestRecoverSettlesTransfersLeftInFlight(t *testing.T) {
+	node := startNode(t)
+
+	committed := commitTransfer(t, "bafybmirecoveredreceiver")
+	unconfirmed := createTransfer(t, "bafybmiunconfirmedreceiver")
+	interrupted := createTransfer(t, "bafybmiinterruptedreceiver")
+	if _, err := database.ClaimTransferBlock(interrupted, "1-interrupted"); err != nil {
+		t.Fatal(err)
+	}
+
+	report := GetTransferManager().Recover(context.Background(), time.Now())
+	if len(report.Errors) != 0 {
+		t.Fatalf("recovery errors: %v", report.Errors)
+	}
+
+	blocks := node.Blocks(testTransferContract)
+	if got := transferStatus(t, committed); got.Status != "success" || got.BlockId != blocks[len(blocks)-1].BlockId {
+		t.Errorf("committed transfer = %s with block %q", got.Status, got.BlockId)
+	}
+	if got := transferStatus(t, unconfirmed); got.Status != "timeout" {
+		t.Errorf("unconfirmed transfer = %s, want timeout", got.Status)
+	}
+	if got := transferStatus(t, interrupted); got.Status != "failed" {
+		t.Errorf("interrupted transfer = %s, want failed", got.Status)
+	}
+
+	// A block that only shows up after recovery is still settled by its callback
+	registerCallback(t, node, testTransferContract, "api/call-back-trigger")
+	ctx := context.Background()
+	client := newNodeClient(testPort)
+	message, err := transferContractMessage(unconfirmed, testAdminDID, "bafybmiunconfirmedreceiver", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	executeID, err := client.ExecuteSmartContract(ctx, rubix_interaction.NewExecuteSmartContractRequest(
+		testTransferContract, testAdminDID, message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rubix_interaction.Sign(ctx, client, testAdminDID, executeID); err != nil {
+		t.Fatal(err)
+	}
+	if got := transferStatus(t, unconfirmed); got.Status != "success" {
+		t.Errorf("late transfer = %s, want success", got.Status)
+	}
+}
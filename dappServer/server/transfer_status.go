@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"dapp-server/database"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxStatusWait caps ?wait= on the status endpoint
+	maxStatusWait = 60 * time.Second
+	// Event streams are closed after this long even if the transfer is
+	// still open; EventSource clients reconnect on their own
+	transferStreamMaxDuration = 10 * time.Minute
+	transferStreamKeepAlive   = 15 * time.Second
+)
+
+// isFinalTransferStatus reports whether a transfer can no longer change.
+// "timeout" is not final: a late callback or the reconciler may still settle it.
+func isFinalTransferStatus(status string) bool {
+	return status == "success" || status == "failed"
+}
+
+// transferStateChanged reports whether b is a step on from a
+func transferStateChanged(a *database.TransferStatus, b *database.TransferStatus) bool {
+	return a.Status != b.Status || a.BlockId != b.BlockId || a.TransactionID != b.TransactionID
+}
+
+// parseStatusWait reads the ?wait= long-poll duration, capped at maxStatusWait
+func parseStatusWait(c *gin.Context) (time.Duration, error) {
+	value := c.Query("wait")
+	if value == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(value)
+	if err != nil || wait < 0 {
+		return 0, fmt.Errorf("wait must be a duration such as 30s")
+	}
+	if wait > maxStatusWait {
+		wait = maxStatusWait
+	}
+	return wait, nil
+}
+
+// waitForTransferChange blocks until the transfer moves on from current, the
+// wait elapses or ctx is done, and returns the latest known state
+func waitForTransferChange(ctx context.Context, current *database.TransferStatus, wait time.Duration) *database.TransferStatus {
+	if wait <= 0 || isFinalTransferStatus(current.Status) {
+		return current
+	}
+
+	changed, stop := GetTransferManager().WatchTransfer(current.RequestID)
+	defer stop()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	latest := current
+	for {
+		// Re-read first: the row may have changed before the watch was set up
+		if status, err := database.GetTransferStatus(current.RequestID); err == nil {
+			latest = status
+			if transferStateChanged(current, latest) {
+				return latest
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return latest
+		case <-ctx.Done():
+			return latest
+		}
+	}
+}
+
+// waitForFinalTransfer waits up to timeout for the transfer to succeed or fail
+// and returns the latest known state
+func waitForFinalTransfer(ctx context.Context, current *database.TransferStatus, timeout time.Duration) *database.TransferStatus {
+	deadline := time.Now().Add(timeout)
+	for !isFinalTransferStatus(current.Status) && ctx.Err() == nil {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		current = waitForTransferChange(ctx, current, remaining)
+	}
+	return current
+}
+
+// streamTransferStatus sends the transfer's state as a Server-Sent Event
+// and then one event per transition, until it reaches a final status
+func streamTransferStatus(c *gin.Context, current *database.TransferStatus) {
+	requestID := current.RequestID
+	changed, stop := GetTransferManager().WatchTransfer(requestID)
+	defer stop()
+	keepAlive := time.NewTicker(transferStreamKeepAlive)
+	defer keepAlive.Stop()
+	deadline := time.NewTimer(transferStreamMaxDuration)
+	defer deadline.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	var sent *database.TransferStatus
+	c.Stream(func(w io.Writer) bool {
+		latest, err := database.GetTransferStatus(requestID)
+		if err != nil {
+			c.SSEvent("error", gin.H{"message": err.Error()})
+			return false
+		}
+		if sent == nil || transferStateChanged(sent, latest) {
+			c.SSEvent("status", latest)
+			sent = latest
+		}
+		if isFinalTransferStatus(latest.Status) {
+			return false
+		}
+
+		select {
+		case <-changed:
+			return true
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			return true
+		case <-deadline.C:
+			return false
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// wantsEventStream reports whether the client asked for Server-Sent Events
+func wantsEventStream(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FieldErrors maps a request field to what is wrong with it
+type FieldErrors map[string]string
+
+// validatable is a request body that checks its own fields
+type validatable interface {
+	Validate() FieldErrors
+}
+
+// didPattern matches a Rubix DID: a CIDv1 in lowercase base32, which always
+// starts with "bafybmi"
+var didPattern = regexp.MustCompile(`^bafybmi[a-z0-9]+$`)
+
+// maxIDLength bounds activity IDs and other identifiers chosen by clients
+const maxIDLength = 128
+
+// DID records a problem with field unless value is a well-formed DID
+func (f FieldErrors) DID(field string, value string) {
+	switch {
+	case value == "":
+		f.add(field, "is required")
+	case !didPattern.MatchString(value):
+		f.add(field, "must be a Rubix DID (bafybmi...)")
+	}
+}
+
+// ID records a problem with field unless value is a usable identifier
+func (f FieldErrors) ID(field string, value string) {
+	switch {
+	case strings.TrimSpace(value) == "":
+		f.add(field, "is required")
+	case strings.TrimSpace(value) != value:
+		f.add(field, "must not start or end with spaces")
+	case len(value) > maxIDLength:
+		f.add(field, fmt.Sprintf("must be at most %d characters", maxIDLength))
+	}
+}
+
+// Required records a problem with field if value is empty
+func (f FieldErrors) Required(field string, value string) {
+	if strings.TrimSpace(value) == "" {
+		f.add(field, "is required")
+	}
+}
+
+// Positive records a problem with field unless value is above zero
+func (f FieldErrors) Positive(field string, value int) {
+	if value <= 0 {
+		f.add(field, "must be a positive number")
+	}
+}
+
+// AtMost records a problem with field if value is above max
+func (f FieldErrors) AtMost(field string, value int, max int64) {
+	if int64(value) > max {
+		f.add(field, fmt.Sprintf("must be at most %d", max))
+	}
+}
+
+// add keeps the first problem found with a field
+func (f FieldErrors) add(field string, problem string) {
+	if _, ok := f[field]; !ok {
+		f[field] = problem
+	}
+}
+
+// bindRequest decodes the JSON body into req and, if req validates itself,
+// checks it. On failure the error response has been written and it returns
+// false.
+func bindRequest(c *gin.Context, req interface{}) bool {
+	if err := json.NewDecoder(c.Request.Body).Decode(req); err != nil {
+		fmt.Printf("Error reading request body: %s\n", err)
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body", err)
+		return false
+	}
+	if v, ok := req.(validatable); ok {
+		if problems := v.Validate(); len(problems) > 0 {
+			respondError(c, http.StatusBadRequest, CodeValidationFailed, "Invalid request", nil, gin.H{"fields": problems})
+			return false
+		}
+	}
+	return true
+}
+
+// validDIDParam checks a DID taken from the path. On failure the error
+// response has been written and it returns false.
+func validDIDParam(c *gin.Context, did string) bool {
+	problems := FieldErrors{}
+	problems.DID("did", did)
+	if len(problems) > 0 {
+		respondError(c, http.StatusBadRequest, CodeValidationFailed, "Invalid request", nil, gin.H{"fields": problems})
+		return false
+	}
+	return true
+}
+
+// validIDParam checks an identifier taken from the path. On failure the
+// error response has been written and it returns false.
+func validIDParam(c *gin.Context, field string, value string) bool {
+	problems := FieldErrors{}
+	problems.ID(field, value)
+	if len(problems) > 0 {
+		respondError(c, http.StatusBadRequest, CodeValidationFailed, "Invalid request", nil, gin.H{"fields": problems})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestFieldErrorsKeepTheFirstProblem(t *testing.T) {
+	problems := FieldErrors{}
+	problems.DID("admin_did", "")
+	problems.DID("user_did", "alice")
+	problems.DID("actor_did", testAdminDID)
+	problems.ID("activity_id", " padded ")
+	problems.ID("name", strings.Repeat("x", maxIDLength+1))
+	problems.ID("contract", "transfer_contract")
+	problems.Required("wasm_path", "  ")
+	problems.Positive("reward_points", 0)
+	problems.Positive("reward_points", -1)
+	problems.AtMost("points", 1<<32, math.MaxUint32)
+	problems.AtMost("tokens", math.MaxUint32, math.MaxUint32)
+	problems.add("user_did", "is not a member")
+
+	want := FieldErrors{
+		"admin_did":     "is required",
+		"user_did":      "must be a Rubix DID (bafybmi...)",
+		"activity_id":   "must not start or end with spaces",
+		"name":          "must be at most 128 characters",
+		"wasm_path":     "is required",
+		"reward_points": "must be a positive number",
+		"points":        "must be at most 4294967295",
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("problems = %v, want %v", problems, want)
+	}
+	for field, problem := range want {
+		if problems[field] != problem {
+			t.Errorf("%s = %q, want %q", field, problems[field], problem)
+		}
+	}
+}